@@ -0,0 +1,20 @@
+package xmldom
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestArgNodesWithoutOwnerDocument checks that strings passed to the
+// variadic methods of a node with no owner document are rejected instead
+// of dereferencing the missing document.
+func TestArgNodesWithoutOwnerDocument(t *testing.T) {
+	orphan := &element{node: node{nodeType: ELEMENT_NODE, nodeName: "orphan"}}
+
+	if err := orphan.ReplaceChildren("text"); err == nil || !strings.HasPrefix(err.Error(), "InvalidStateError") {
+		t.Errorf("ReplaceChildren(string) = %v, want InvalidStateError", err)
+	}
+	if err := orphan.ReplaceChildren(); err != nil {
+		t.Errorf("ReplaceChildren() without strings should not need a document, got %v", err)
+	}
+}
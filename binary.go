@@ -0,0 +1,364 @@
+package xmldom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The binary format is a magic header and version byte, a table of interned
+// names and namespace URIs, the document properties, an optional doctype
+// and finally the node tree in document order. Every integer is an unsigned
+// varint and every string a varint length followed by its bytes.
+const (
+	binaryMagic   = "XDOM"
+	binaryVersion = 1
+)
+
+// ErrInvalidBinary is returned by UnmarshalBinary for data that was not
+// produced by MarshalBinary or was written by an unsupported version.
+var ErrInvalidBinary = errors.New("xmldom: invalid binary document")
+
+// MarshalBinary encodes doc in a compact binary form that UnmarshalBinary
+// can load much faster than the equivalent XML can be parsed. The encoding
+// keeps every node type, namespace and attribute, the doctype with its
+// entities and notations, and the document URL and encoding properties.
+// Source positions are not kept.
+func MarshalBinary(doc Document) ([]byte, error) {
+	d, ok := doc.(*document)
+	if !ok {
+		return nil, NewDOMException("NotSupportedError", "MarshalBinary requires a document created by this package")
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	w := &binaryWriter{names: make(map[DOMString]uint64)}
+	w.string(d.url)
+	w.string(d.documentURI)
+	w.string(d.characterSet)
+	w.string(d.contentType)
+	if dt, ok := d.doctype.(*documentType); ok {
+		w.body.WriteByte(1)
+		w.doctype(dt)
+	} else {
+		w.body.WriteByte(0)
+	}
+	if err := w.children(d); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(binaryMagic)
+	out.WriteByte(binaryVersion)
+	out.Write(binary.AppendUvarint(nil, uint64(len(w.table))))
+	for _, name := range w.table {
+		out.Write(binary.AppendUvarint(nil, uint64(len(name))))
+		out.WriteString(string(name))
+	}
+	out.Write(w.body.Bytes())
+	return out.Bytes(), nil
+}
+
+// UnmarshalBinary rebuilds a document from data produced by MarshalBinary.
+func UnmarshalBinary(data []byte) (Document, error) {
+	if len(data) < len(binaryMagic)+1 || string(data[:len(binaryMagic)]) != binaryMagic {
+		return nil, ErrInvalidBinary
+	}
+	if v := data[len(binaryMagic)]; v != binaryVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidBinary, v)
+	}
+	r := &binaryReader{data: data[len(binaryMagic)+1:]}
+	count := r.uvarint()
+	if count > uint64(len(r.data)) {
+		return nil, ErrInvalidBinary
+	}
+	r.table = make([]DOMString, count)
+	for i := range r.table {
+		r.table[i] = r.string()
+	}
+
+	doc, err := NewDOMImplementation().CreateDocument("", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	d := doc.(*document)
+	d.url = r.string()
+	d.documentURI = r.string()
+	d.characterSet = r.string()
+	d.contentType = r.string()
+	if r.byte() == 1 {
+		d.doctype = r.doctype(d)
+	}
+	if err := r.children(d, d); err != nil {
+		return nil, err
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrInvalidBinary, len(r.data))
+	}
+	return doc, nil
+}
+
+type binaryWriter struct {
+	body  bytes.Buffer
+	names map[DOMString]uint64
+	table []DOMString
+	buf   [binary.MaxVarintLen64]byte
+}
+
+func (w *binaryWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(w.buf[:], v)
+	w.body.Write(w.buf[:n])
+}
+
+func (w *binaryWriter) string(s DOMString) {
+	w.uvarint(uint64(len(s)))
+	w.body.WriteString(string(s))
+}
+
+// name writes s as an index into the interned name table
+func (w *binaryWriter) name(s DOMString) {
+	index, ok := w.names[s]
+	if !ok {
+		index = uint64(len(w.table))
+		w.names[s] = index
+		w.table = append(w.table, s)
+	}
+	w.uvarint(index)
+}
+
+func (w *binaryWriter) doctype(dt *documentType) {
+	w.name(dt.name)
+	w.string(dt.publicId)
+	w.string(dt.systemId)
+	w.string(dt.internalSubset)
+	w.uvarint(uint64(dt.entities.Length()))
+	for _, n := range dt.entities.nodes {
+		ent := n.(*entity)
+		w.name(ent.nodeName)
+		w.string(ent.publicId)
+		w.string(ent.systemId)
+		w.string(ent.notationName)
+		var value DOMString
+		for child := ent.firstChild; child != nil; child = child.NextSibling() {
+			value += child.NodeValue()
+		}
+		w.string(value)
+	}
+	w.uvarint(uint64(dt.notations.Length()))
+	for _, n := range dt.notations.nodes {
+		nt := n.(*notation)
+		w.name(nt.nodeName)
+		w.string(nt.publicId)
+		w.string(nt.systemId)
+	}
+}
+
+func (w *binaryWriter) children(parent Node) error {
+	count := 0
+	for child := parent.FirstChild(); child != nil; child = child.NextSibling() {
+		count++
+	}
+	w.uvarint(uint64(count))
+	for child := parent.FirstChild(); child != nil; child = child.NextSibling() {
+		if err := w.node(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *binaryWriter) node(n Node) error {
+	w.body.WriteByte(byte(n.NodeType()))
+	switch n := n.(type) {
+	case *element:
+		w.name(n.namespaceURI)
+		w.name(n.nodeName)
+		w.uvarint(uint64(n.attributes.Length()))
+		for _, a := range n.attributes.nodes {
+			at := a.(*attr)
+			w.name(at.namespaceURI)
+			w.name(at.nodeName)
+			w.string(at.nodeValue)
+			if at.rawValue != "" && at.rawValueFor == at.nodeValue {
+				w.string(at.rawValue)
+			} else {
+				w.string("")
+			}
+		}
+		return w.children(n)
+	case *processingInstruction:
+		w.name(n.nodeName)
+		w.string(n.nodeValue)
+	case *entityReference:
+		w.name(n.nodeName)
+	case *documentType:
+		// Only the node type is written here; the doctype itself is in the
+		// header
+	default:
+		switch n.NodeType() {
+		case TEXT_NODE, CDATA_SECTION_NODE, COMMENT_NODE:
+			w.string(n.NodeValue())
+		default:
+			return NewDOMException("NotSupportedError", fmt.Sprintf("cannot encode %s node", n.NodeName()))
+		}
+	}
+	return nil
+}
+
+type binaryReader struct {
+	data  []byte
+	table []DOMString
+	err   error
+}
+
+func (r *binaryReader) fail() {
+	if r.err == nil {
+		r.err = ErrInvalidBinary
+	}
+	r.data = nil
+}
+
+func (r *binaryReader) byte() byte {
+	if len(r.data) == 0 {
+		r.fail()
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) string() DOMString {
+	n := r.uvarint()
+	if n > uint64(len(r.data)) {
+		r.fail()
+		return ""
+	}
+	s := DOMString(r.data[:n])
+	r.data = r.data[n:]
+	return s
+}
+
+func (r *binaryReader) name() DOMString {
+	index := r.uvarint()
+	if index >= uint64(len(r.table)) {
+		r.fail()
+		return ""
+	}
+	return r.table[index]
+}
+
+func (r *binaryReader) doctype(d *document) DocumentType {
+	dt := &documentType{
+		node:      node{nodeType: DOCUMENT_TYPE_NODE, ownerDocument: d},
+		entities:  NewNamedNodeMap(),
+		notations: NewNamedNodeMap(),
+	}
+	dt.name = r.name()
+	dt.nodeName = dt.name
+	dt.publicId = r.string()
+	dt.systemId = r.string()
+	dt.internalSubset = r.string()
+	for i, count := uint64(0), r.uvarint(); i < count && r.err == nil; i++ {
+		ent := &entity{node: node{nodeType: ENTITY_NODE, ownerDocument: d}}
+		ent.nodeName = r.name()
+		ent.publicId = r.string()
+		ent.systemId = r.string()
+		ent.notationName = r.string()
+		if value := r.string(); value != "" {
+			ent.linkLastChild(ent, d.CreateTextNode(value))
+		}
+		markReadOnly(ent)
+		dt.entities.nodes = append(dt.entities.nodes, ent)
+	}
+	for i, count := uint64(0), r.uvarint(); i < count && r.err == nil; i++ {
+		nt := &notation{node: node{nodeType: NOTATION_NODE, ownerDocument: d}}
+		nt.nodeName = r.name()
+		nt.publicId = r.string()
+		nt.systemId = r.string()
+		markReadOnly(nt)
+		dt.notations.nodes = append(dt.notations.nodes, nt)
+	}
+	return dt
+}
+
+func (r *binaryReader) children(d *document, parent Node) error {
+	count := r.uvarint()
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		child, err := r.node(d)
+		if err != nil {
+			return err
+		}
+		if child == nil {
+			continue
+		}
+		if _, err := parent.AppendChild(child); err != nil {
+			return err
+		}
+	}
+	return r.err
+}
+
+func (r *binaryReader) node(d *document) (Node, error) {
+	switch nodeType := NodeType(r.byte()); nodeType {
+	case ELEMENT_NODE:
+		// Names are not checked, as in Decode, so that every document the
+		// Decoder accepts round-trips
+		elem := d.newElementNS(r.name(), r.name())
+		if r.err != nil {
+			return nil, r.err
+		}
+		for i, count := uint64(0), r.uvarint(); i < count && r.err == nil; i++ {
+			namespaceURI, qualifiedName, value := r.name(), r.name(), r.string()
+			raw := r.string()
+			if err := elem.setAttributeNS(namespaceURI, qualifiedName, value); err != nil {
+				return nil, err
+			}
+			if raw != "" {
+				setAttrRawValue(elem.GetAttributeNodeNS(namespaceURI, localNameOf(qualifiedName)), raw)
+			}
+		}
+		return elem, r.children(d, elem)
+	case TEXT_NODE:
+		return d.CreateTextNode(r.string()), r.err
+	case CDATA_SECTION_NODE:
+		return d.CreateCDATASection(r.string())
+	case COMMENT_NODE:
+		return d.CreateComment(r.string()), r.err
+	case PROCESSING_INSTRUCTION_NODE:
+		return d.CreateProcessingInstruction(r.name(), r.string())
+	case ENTITY_REFERENCE_NODE:
+		return d.CreateEntityReference(r.name())
+	case DOCUMENT_TYPE_NODE:
+		if d.doctype == nil {
+			return nil, ErrInvalidBinary
+		}
+		return d.doctype, nil
+	default:
+		r.fail()
+		return nil, fmt.Errorf("%w: unknown node type %d", ErrInvalidBinary, nodeType)
+	}
+}
+
+// localNameOf returns the part of qualifiedName after its prefix
+func localNameOf(qualifiedName DOMString) DOMString {
+	if _, local, ok := strings.Cut(string(qualifiedName), ":"); ok {
+		return DOMString(local)
+	}
+	return qualifiedName
+}
@@ -0,0 +1,36 @@
+package xmldom_test
+
+import (
+	"testing"
+
+	"github.com/gogo-agent/xmldom"
+)
+
+func BenchmarkUnmarshalBinary(b *testing.B) {
+	doc, err := xmldom.UnmarshalDOM([]byte(generateXML(20, 4)))
+	if err != nil {
+		b.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+	data, err := xmldom.MarshalBinary(doc)
+	if err != nil {
+		b.Fatalf("MarshalBinary failed: %v", err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := xmldom.UnmarshalBinary(data); err != nil {
+			b.Fatalf("UnmarshalBinary failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalBinary_ReparseXML(b *testing.B) {
+	data := []byte(generateXML(20, 4))
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := xmldom.UnmarshalDOM(data); err != nil {
+			b.Fatalf("UnmarshalDOM failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package xmldom_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gogo-agent/xmldom"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	src := `<!DOCTYPE doc [<!ENTITY co "Acme">]>` +
+		`<doc xmlns="urn:default" xmlns:p="urn:p" id="top">` +
+		`<?render fast?><!-- note --><p:item p:kind="a" plain="x &amp; y">text &co; more</p:item>` +
+		`<empty/></doc>`
+	decoder := xmldom.NewDecoder(strings.NewReader(src))
+	decoder.SetExpandEntityReferences(false)
+	doc, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	cdata, _ := doc.CreateCDATASection("raw <data>")
+	doc.DocumentElement().AppendChild(cdata)
+	doc.SetDocumentURI("http://example.com/doc.xml")
+
+	data, err := xmldom.MarshalBinary(doc)
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	loaded, err := xmldom.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+
+	if !loaded.DocumentElement().IsEqualNode(doc.DocumentElement()) {
+		path, reason, _ := xmldom.FirstDifference(doc.DocumentElement(), loaded.DocumentElement())
+		t.Fatalf("rehydrated tree differs at %s: %s", path, reason)
+	}
+	if loaded.DocumentURI() != "http://example.com/doc.xml" {
+		t.Errorf("DocumentURI = %q", loaded.DocumentURI())
+	}
+	if loaded.Doctype() == nil || loaded.Doctype().Name() != "doc" {
+		t.Fatalf("doctype was not restored")
+	}
+	if ent := loaded.Doctype().Entities().GetNamedItem("co"); ent == nil || ent.TextContent() != "Acme" {
+		t.Errorf("entity declaration was not restored")
+	}
+	if got := loaded.GetElementById("top"); got == nil || got.LocalName() != "doc" {
+		t.Errorf("id index was not rebuilt")
+	}
+	if last := loaded.DocumentElement().LastChild(); last.NodeType() != xmldom.CDATA_SECTION_NODE {
+		t.Errorf("CDATA section became %s", last.NodeName())
+	}
+
+	want, _ := xmldom.Marshal(doc)
+	got, _ := xmldom.Marshal(loaded)
+	if string(got) != string(want) {
+		t.Errorf("serialization differs:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestBinaryRoundTripNames(t *testing.T) {
+	// Documents the Decoder accepts round-trip even when their names would
+	// be rejected by CreateElementNS or SetAttributeNS
+	inputs := []string{
+		`<xml:foo xmlns:xml="http://www.w3.org/XML/1998/namespace"/>`,
+		`<r xmlns:xml="http://www.w3.org/XML/1998/namespace" xml:lang="en" xml:space="preserve"/>`,
+		`<r xmlns:p="urn:p" xmlns="urn:d" p:a="1"><p:c p:a="3"/></r>`,
+		`<r :="colon" a:="trailing"/>`,
+	}
+	for _, src := range inputs {
+		doc, err := xmldom.NewDecoder(strings.NewReader(src)).Decode()
+		if err != nil {
+			t.Fatalf("Decode(%s) failed: %v", src, err)
+		}
+		data, err := xmldom.MarshalBinary(doc)
+		if err != nil {
+			t.Fatalf("MarshalBinary(%s) failed: %v", src, err)
+		}
+		loaded, err := xmldom.UnmarshalBinary(data)
+		if err != nil {
+			t.Errorf("UnmarshalBinary(%s) failed: %v", src, err)
+			continue
+		}
+		if path, reason, equal := xmldom.FirstDifference(doc, loaded); !equal {
+			t.Errorf("round trip of %s differs at %s: %s", src, path, reason)
+		}
+	}
+}
+
+func TestUnmarshalBinaryInvalid(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("<xml/>"), []byte("XDOM\x01\x05")} {
+		if _, err := xmldom.UnmarshalBinary(data); !errors.Is(err, xmldom.ErrInvalidBinary) {
+			t.Errorf("UnmarshalBinary(%q) error = %v, want ErrInvalidBinary", data, err)
+		}
+	}
+}
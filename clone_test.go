@@ -0,0 +1,74 @@
+package xmldom
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSafeCloneNodeRejectsSharedSubtrees builds trees whose links are
+// corrupted directly, as misuse of the internal fields would, and checks
+// that SafeCloneNode reports them instead of looping.
+func TestSafeCloneNodeRejectsSharedSubtrees(t *testing.T) {
+	doc, err := NewDecoder(strings.NewReader(`<root><a><x/></a><b/></root>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	root := doc.DocumentElement()
+
+	clone, err := SafeCloneNode(root)
+	if err != nil {
+		t.Fatalf("SafeCloneNode() on a proper tree failed: %v", err)
+	}
+	if !clone.IsEqualNode(root) {
+		t.Errorf("SafeCloneNode() should produce an equal copy")
+	}
+
+	// Share <x/> between <a> and <b>
+	a := getInternalNode(root.FirstChild())
+	b := getInternalNode(root.LastChild())
+	b.firstChild = a.firstChild
+	b.lastChild = a.firstChild
+	if _, err := SafeCloneNode(root); err == nil || !strings.HasPrefix(err.Error(), "HierarchyRequestError") {
+		t.Errorf("SafeCloneNode() with a shared child = %v, want HierarchyRequestError", err)
+	}
+	b.firstChild, b.lastChild = nil, nil
+
+	// Point <b>'s next sibling link back at <a>, forming a cycle
+	b.nextSibling = root.FirstChild()
+	if _, err := SafeCloneNode(root); err == nil || !strings.HasPrefix(err.Error(), "HierarchyRequestError") {
+		t.Errorf("SafeCloneNode() with a sibling cycle = %v, want HierarchyRequestError", err)
+	}
+}
+
+func TestCheckTreeIntegrity(t *testing.T) {
+	doc, err := NewDecoder(strings.NewReader(`<!DOCTYPE root><root a="1"><a><x/>text</a><b/><!--c--></root>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if errs := doc.CheckTreeIntegrity(); len(errs) != 0 {
+		t.Fatalf("CheckTreeIntegrity() on a sound tree = %v", errs)
+	}
+
+	// Break the back link from <b/> to <a>
+	root := doc.DocumentElement()
+	b := getInternalNode(root.FirstChild().NextSibling())
+	saved := b.previousSibling
+	b.previousSibling = nil
+	errs := doc.CheckTreeIntegrity()
+	if len(errs) != 1 {
+		t.Fatalf("CheckTreeIntegrity() = %v, want one error", errs)
+	}
+	integrity, ok := errs[0].(*TreeIntegrityError)
+	if !ok || integrity.Path != "/root[1]/b[1]" || !strings.Contains(integrity.Problem, "previousSibling") {
+		t.Errorf("CheckTreeIntegrity() = %v, want a previousSibling error at /root[1]/b[1]", errs[0])
+	}
+	b.previousSibling = saved
+
+	// A wrong parent and a stale lastChild
+	x := getInternalNode(root.FirstChild().FirstChild())
+	x.parentNode = root
+	getInternalNode(root).lastChild = root.FirstChild()
+	if errs := doc.CheckTreeIntegrity(); len(errs) != 2 {
+		t.Errorf("CheckTreeIntegrity() = %v, want two errors", errs)
+	}
+}
@@ -0,0 +1,32 @@
+package xmldom
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestContentPartsInsideEntityReference checks that elements in the
+// replacement text of an entity reference are listed in place. The decoder
+// only gives entity references text, so the reference is built by hand.
+func TestContentPartsInsideEntityReference(t *testing.T) {
+	doc, err := NewDecoder(strings.NewReader(`<p>a<b/></p>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	p := doc.DocumentElement()
+	ref, _ := doc.CreateEntityReference("e")
+	i, _ := doc.CreateElement("i")
+	impl := ref.(*entityReference)
+	impl.linkLastChild(impl, doc.CreateTextNode("x"))
+	impl.linkLastChild(impl, i)
+	impl.linkLastChild(impl, doc.CreateTextNode("y"))
+	p.InsertBefore(ref, p.LastChild())
+
+	parts := p.ContentParts()
+	if len(parts) != 4 {
+		t.Fatalf("ContentParts() returned %d parts, want 4: %+v", len(parts), parts)
+	}
+	if parts[0].Text != "ax" || parts[1].Element != Element(i) || parts[2].Text != "y" || parts[3].Element == nil {
+		t.Errorf("ContentParts() = %+v, want \"ax\", <i>, \"y\", <b>", parts)
+	}
+}
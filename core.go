@@ -1,10 +1,18 @@
 package xmldom
 
 import (
+	"encoding/binary"
 	"fmt"
+	"hash"
+	"hash/fnv"
+	"iter"
+	"net/url"
 	"reflect"
+	"slices"
+	"sort"
 	"strings"
 	sync "sync"
+	"weak"
 )
 
 // DOMString is a string type used in the DOM.
@@ -40,6 +48,12 @@ const (
 	NOTATION_NODE               NodeType = 12
 )
 
+// Namespace URIs reserved by Namespaces in XML
+const (
+	xmlNamespaceURI   = "http://www.w3.org/XML/1998/namespace"
+	xmlnsNamespaceURI = "http://www.w3.org/2000/xmlns/"
+)
+
 type DocumentPositionType = uint16
 
 // DocumentPosition constants
@@ -122,17 +136,28 @@ type Node interface {
 	LocalName() DOMString
 	HasAttributes() bool
 	BaseURI() DOMString
+	ResolveURI(relative DOMString) (DOMString, error)
 	IsConnected() bool
 	CompareDocumentPosition(otherNode Node) DocumentPositionType
 	Contains(otherNode Node) bool
 	GetRootNode() Node
+	PreviousElementSibling() Element
+	NextElementSibling() Element
+	Depth() int
+	SubtreeNodeCount() int
+	TextNodes(includeCDATA bool) []Text
+	EachAttribute(fn func(owner Element, attr Attr))
 	IsDefaultNamespace(namespaceURI DOMString) bool
 	IsEqualNode(otherNode Node) bool
+	StructuralHash() uint64
 	IsSameNode(otherNode Node) bool
 	LookupPrefix(namespaceURI DOMString) DOMString
 	LookupNamespaceURI(prefix DOMString) DOMString
 	TextContent() DOMString
 	SetTextContent(value DOMString)
+	DescendantsReverse() iter.Seq[Node]
+	MoveTo(newParent Node, refChild Node) error
+	FindFirst(pred func(Node) bool) Node
 }
 
 // Document interface represents a document node
@@ -149,17 +174,34 @@ type Document interface {
 	CreateProcessingInstruction(target, data DOMString) (ProcessingInstruction, error)
 	CreateAttribute(name DOMString) (Attr, error)
 	CreateEntityReference(name DOMString) (EntityReference, error)
+	// GetElementsByTagName matches elements by qualified name, as the DOM
+	// specification requires: an element created with CreateElementNS as
+	// "p:item" matches "p:item" but not "item". SetTagNameMatch can relax
+	// this to also match local names.
 	GetElementsByTagName(tagname DOMString) NodeList
+	GetElementsByName(name DOMString) NodeList
+	SetTagNameMatch(mode TagNameMatch)
+	SetQueryProvider(provider QueryProvider)
+	SetAttributeDefault(tagName, name, value DOMString)
+	AttributeDefault(tagName, name DOMString) (DOMString, bool)
 	ImportNode(importedNode Node, deep bool) (Node, error)
 	CreateElementNS(namespaceURI, qualifiedName DOMString) (Element, error)
 	CreateAttributeNS(namespaceURI, qualifiedName DOMString) (Attr, error)
 	GetElementsByTagNameNS(namespaceURI, localName DOMString) NodeList
 	GetElementById(elementId DOMString) Element
+	SetIdAttributeName(name DOMString)
+	QuerySelector(selector string) (Element, error)
+	QuerySelectorAll(selector string) (NodeList, error)
+	CheckReferentialIntegrity(idrefAttrs map[DOMString][]DOMString) []error
+	FindDuplicateIds() map[DOMString][]Element
+	CheckTreeIntegrity() []error
 	AdoptNode(source Node) (Node, error)
+	ReplaceChildren(nodes ...any) error
 	CreateNodeIterator(root Node, whatToShow ShowWhatType, filter NodeFilter) (NodeIterator, error)
 	CreateTreeWalker(root Node, whatToShow ShowWhatType, filter NodeFilter) (TreeWalker, error)
 	CreateRange() Range
 	NormalizeDocument()
+	ReadView() ReadOnlyDocument
 	RenameNode(node Node, namespaceURI, qualifiedName DOMString) (Node, error)
 
 	// XPath evaluation methods following DOM Living Standard
@@ -171,6 +213,7 @@ type Document interface {
 	// Document properties
 	URL() DOMString
 	DocumentURI() DOMString
+	SetDocumentURI(uri DOMString)
 	CharacterSet() DOMString
 	Charset() DOMString
 	InputEncoding() DOMString
@@ -188,6 +231,7 @@ type Element interface {
 	SetAttributeNode(newAttr Attr) (Attr, error)
 	RemoveAttributeNode(oldAttr Attr) (Attr, error)
 	GetElementsByTagName(name DOMString) NodeList
+	GetElementsByTagNames(names ...DOMString) NodeList
 	GetAttributeNS(namespaceURI, localName DOMString) DOMString
 	SetAttributeNS(namespaceURI, qualifiedName, value DOMString) error
 	RemoveAttributeNS(namespaceURI, localName DOMString) error
@@ -196,19 +240,43 @@ type Element interface {
 	GetElementsByTagNameNS(namespaceURI, localName DOMString) NodeList
 	HasAttribute(name DOMString) bool
 	HasAttributeNS(namespaceURI, localName DOMString) bool
+	NonNamespaceAttributes() []Attr
+	GetAttributeNames() []DOMString
+	CanonicalAttributeString() string
+	NamespaceDeclarations() map[DOMString]DOMString
+	CloneWithChildren(deep bool, keep func(Node) bool) Element
+	NormalizedTextContent() DOMString
+	HasMixedContent() bool
+	ContentParts() []ContentPart
+	EffectiveAttribute(name DOMString) (value DOMString, specified bool)
+	InnerXML() (string, error)
+	OuterXML() (string, error)
+	SetInnerXML(markup DOMString) error
+	ChangeNamespace(oldNS, newNS DOMString) error
+	Closest(selector string) (Element, error)
 
 	// Element manipulation methods from Living Standard (applicable to XML)
 	ToggleAttribute(name DOMString, force ...bool) bool
 
+	// ReplaceWith, Before, After, Prepend, Append and ReplaceChildren take
+	// Nodes and strings, either DOMString or string. Each string is
+	// inserted as a new Text node, even when it is empty.
 	Remove()
-	ReplaceWith(nodes ...Node) error
-	Before(nodes ...Node) error
-	After(nodes ...Node) error
-	Prepend(nodes ...Node) error
-	Append(nodes ...Node) error
+	ReplaceWith(nodes ...any) error
+	Before(nodes ...any) error
+	After(nodes ...any) error
+	Prepend(nodes ...any) error
+	Append(nodes ...any) error
+	ReplaceChildren(nodes ...any) error
+	InsertAdjacentElement(where DOMString, element Element) (Element, error)
+	WrapChildren(wrapper Element) error
+	Unwrap() error
+	MergeWithPrevious() error
+	SetOuterXML(markup DOMString) error
 
 	// Element DOM properties from Living Standard
 	Children() ElementList // Returns live collection of child elements
+	ChildrenByNamespace(namespaceURI DOMString) ElementList
 	FirstElementChild() Element
 	LastElementChild() Element
 	PreviousElementSibling() Element
@@ -223,6 +291,7 @@ type Attr interface {
 	Value() DOMString
 	SetValue(value DOMString)
 	OwnerElement() Element
+	Specified() bool
 }
 
 // CharacterData interface represents character data
@@ -237,10 +306,11 @@ type CharacterData interface {
 	DeleteData(offset, count uint) error
 	ReplaceData(offset, count uint, arg DOMString) error
 
-	// CharacterData manipulation methods from Living Standard
-	Before(nodes ...Node) error
-	After(nodes ...Node) error
-	ReplaceWith(nodes ...Node) error
+	// CharacterData manipulation methods from Living Standard. Like their
+	// Element counterparts they take Nodes and strings.
+	Before(nodes ...any) error
+	After(nodes ...any) error
+	ReplaceWith(nodes ...any) error
 	Remove()
 }
 
@@ -248,6 +318,8 @@ type CharacterData interface {
 type Text interface {
 	CharacterData
 	SplitText(offset uint) (Text, error)
+	WholeText() DOMString
+	ReplaceWholeText(content DOMString) (Text, error)
 }
 
 // Comment interface represents a comment node
@@ -388,6 +460,7 @@ type Range interface {
 	IsPointInRange(node Node, offset uint32) (bool, error)
 	ComparePoint(node Node, offset uint32) (int16, error)
 	IntersectsNode(node Node) bool
+	Intersects(other Range) bool
 
 	ToString() string
 }
@@ -443,6 +516,8 @@ type liveList[T any] struct {
 	live      bool                 // True if the list is live and should be updated on mutations.
 	doc       *document            // A pointer to the owner document, used to access activeNodeLists.
 	update    func()               // The function to call to update the list of items.
+	dirty     bool                 // True if items is stale and update must run before it is read.
+	mu        sync.Mutex           // Serializes recomputation by concurrent readers.
 }
 
 // nodeList represents an ordered collection of nodes
@@ -459,6 +534,9 @@ func (dl *liveList[T]) Item(index uint) T {
 		dl.doc.mu.RLock()
 		defer dl.doc.mu.RUnlock()
 	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.refresh()
 	if index >= uint(len(dl.items)) {
 		return zero
 	}
@@ -471,70 +549,112 @@ func (dl *liveList[T]) Length() uint {
 		dl.doc.mu.RLock()
 		defer dl.doc.mu.RUnlock()
 	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.refresh()
 	return uint(len(dl.items))
 }
 
-// namedNodeMap represents a collection of nodes accessible by name
+// refresh recomputes the items of a list that is dirty because it was just
+// created or the tree has changed since it was last read. The caller holds
+// dl.mu and the document's read lock, so the tree is stable.
+func (dl *liveList[T]) refresh() {
+	if dl.dirty && dl.update != nil {
+		dl.update()
+	}
+	dl.dirty = false
+}
+
+// NewStaticNodeList returns a NodeList holding a copy of nodes.
+// The list is static: it is not registered with any document and does not
+// track later mutations of the tree.
+func NewStaticNodeList(nodes []Node) NodeList {
+	items := make([]Node, len(nodes))
+	copy(items, nodes)
+	return &nodeList{items: items}
+}
+
+// namedNodeMap represents a collection of nodes accessible by name.
+// Nodes are kept in insertion order. Two attributes may share a qualified
+// name when their namespaces differ, so lookups by qualified name return
+// the first match while the NS variants match namespace and local name.
 type namedNodeMap struct {
-	items map[DOMString]Node
-	order []DOMString
+	nodes []Node
 }
 
 func NewNamedNodeMap() *namedNodeMap {
-	return &namedNodeMap{
-		items: make(map[DOMString]Node),
-		order: []DOMString{},
+	return &namedNodeMap{}
+}
+
+// indexOf returns the position of the first node named name, or -1
+func (nnm *namedNodeMap) indexOf(name DOMString) int {
+	for i, node := range nnm.nodes {
+		if node.NodeName() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// indexOfNS returns the position of the node with the given namespace URI
+// and local name, or -1
+func (nnm *namedNodeMap) indexOfNS(namespaceURI, localName DOMString) int {
+	for i, node := range nnm.nodes {
+		if node.NamespaceURI() == namespaceURI && node.LocalName() == localName {
+			return i
+		}
 	}
+	return -1
+}
+
+func (nnm *namedNodeMap) removeAt(i int) Node {
+	node := nnm.nodes[i]
+	nnm.nodes = append(nnm.nodes[:i], nnm.nodes[i+1:]...)
+	return node
 }
 
 func (nnm *namedNodeMap) GetNamedItem(name DOMString) Node {
-	return nnm.items[name]
+	if i := nnm.indexOf(name); i >= 0 {
+		return nnm.nodes[i]
+	}
+	return nil
 }
 
 func (nnm *namedNodeMap) SetNamedItem(arg Node) (Node, error) {
 	if arg.NodeType() != ATTRIBUTE_NODE {
 		return nil, NewDOMException("HierarchyRequestError", "Node is not an attribute")
 	}
-	name := arg.NodeName()
-	oldArg := nnm.items[name]
-	if oldArg == nil {
-		nnm.order = append(nnm.order, name)
+	if i := nnm.indexOf(arg.NodeName()); i >= 0 {
+		oldArg := nnm.nodes[i]
+		nnm.nodes[i] = arg
+		return oldArg, nil
 	}
-	nnm.items[name] = arg
-	return oldArg, nil
+	nnm.nodes = append(nnm.nodes, arg)
+	return nil, nil
 }
 
 func (nnm *namedNodeMap) RemoveNamedItem(name DOMString) (Node, error) {
-	node := nnm.items[name]
-	if node == nil {
+	i := nnm.indexOf(name)
+	if i < 0 {
 		return nil, NewDOMException("NotFoundError", "Node not found")
 	}
-	delete(nnm.items, name)
-	for i, n := range nnm.order {
-		if n == name {
-			nnm.order = append(nnm.order[:i], nnm.order[i+1:]...)
-			break
-		}
-	}
-	return node, nil
+	return nnm.removeAt(i), nil
 }
 
 func (nnm *namedNodeMap) Item(index uint) Node {
-	if index >= uint(len(nnm.order)) {
+	if index >= uint(len(nnm.nodes)) {
 		return nil
 	}
-	return nnm.items[nnm.order[index]]
+	return nnm.nodes[index]
 }
 
 func (nnm *namedNodeMap) Length() uint {
-	return uint(len(nnm.order))
+	return uint(len(nnm.nodes))
 }
 
 func (nnm *namedNodeMap) GetNamedItemNS(namespaceURI, localName DOMString) Node {
-	for _, node := range nnm.items {
-		if node.NamespaceURI() == namespaceURI && node.LocalName() == localName {
-			return node
-		}
+	if i := nnm.indexOfNS(namespaceURI, localName); i >= 0 {
+		return nnm.nodes[i]
 	}
 	return nil
 }
@@ -543,44 +663,23 @@ func (nnm *namedNodeMap) SetNamedItemNS(arg Node) (Node, error) {
 	if arg.NodeType() != ATTRIBUTE_NODE {
 		return nil, NewDOMException("HierarchyRequestError", "Node is not an attribute")
 	}
-	var oldArg Node
-	var oldName DOMString
-	for name, node := range nnm.items {
-		if node.NamespaceURI() == arg.NamespaceURI() && node.LocalName() == arg.LocalName() {
-			oldArg = node
-			oldName = name
-			break
-		}
-	}
-	if oldArg != nil {
-		delete(nnm.items, oldName)
-		for i, name := range nnm.order {
-			if name == oldName {
-				nnm.order[i] = arg.NodeName()
-				break
-			}
-		}
-	} else {
-		nnm.order = append(nnm.order, arg.NodeName())
+	if i := nnm.indexOfNS(arg.NamespaceURI(), arg.LocalName()); i >= 0 {
+		oldArg := nnm.nodes[i]
+		nnm.nodes[i] = arg
+		return oldArg, nil
 	}
-	nnm.items[arg.NodeName()] = arg
-	return oldArg, nil
+	nnm.nodes = append(nnm.nodes, arg)
+	return nil, nil
 }
 
+// RemoveNamedItemNS removes the node matching both namespaceURI and
+// localName, leaving any other node with the same qualified name in place.
 func (nnm *namedNodeMap) RemoveNamedItemNS(namespaceURI, localName DOMString) (Node, error) {
-	for name, node := range nnm.items {
-		if node.NamespaceURI() == namespaceURI && node.LocalName() == localName {
-			delete(nnm.items, name)
-			for i, n := range nnm.order {
-				if n == name {
-					nnm.order = append(nnm.order[:i], nnm.order[i+1:]...)
-					break
-				}
-			}
-			return node, nil
-		}
+	i := nnm.indexOfNS(namespaceURI, localName)
+	if i < 0 {
+		return nil, NewDOMException("NotFoundError", "Node not found")
 	}
-	return nil, NewDOMException("NotFoundError", "Node not found")
+	return nnm.removeAt(i), nil
 }
 
 // ===========================================================================
@@ -606,6 +705,9 @@ type node struct {
 
 	// Source position information (set during parsing, zero values if not available)
 	sourcePosition position
+
+	// readOnly marks nodes inside an entity reference's expansion
+	readOnly bool
 }
 
 func (n *node) NodeType() uint16 {
@@ -621,6 +723,9 @@ func (n *node) NodeValue() DOMString {
 }
 
 func (n *node) SetNodeValue(value DOMString) error {
+	if n.readOnly {
+		return errReadOnly()
+	}
 	switch n.nodeType {
 	case ATTRIBUTE_NODE:
 		// This is handled by Attr.SetValue, but we need to allow it here for the interface
@@ -667,7 +772,7 @@ func (n *node) ChildNodes() NodeList {
 			}
 			nl.items = nodes
 		}
-		nl.update()
+		nl.dirty = true // populated on first read
 
 		if doc != nil {
 			if doc.activeNodeLists == nil {
@@ -784,8 +889,8 @@ func (n *node) InsertBefore(newChild Node, refChild Node) (Node, error) {
 func (n *node) insertBeforeInternal(newChild Node, refChild Node) (Node, error) {
 
 	// Remove from current parent if exists - done internally to avoid deadlock
-	if newChild.ParentNode() != nil {
-		oldParent := newChild.ParentNode()
+	oldParent := newChild.ParentNode()
+	if oldParent != nil {
 		oc := getInternalNode(newChild)
 
 		// Update sibling links
@@ -810,7 +915,7 @@ func (n *node) insertBeforeInternal(newChild Node, refChild Node) (Node, error)
 			}
 			// Update old parent's live NodeList if it exists
 			if op.childNodes != nil && op.childNodes.update != nil {
-				op.childNodes.update()
+				op.childNodes.dirty = true
 			}
 		}
 
@@ -859,11 +964,11 @@ func (n *node) insertBeforeInternal(newChild Node, refChild Node) (Node, error)
 
 	// Update live NodeList if it exists
 	if n.childNodes != nil && n.childNodes.update != nil {
-		n.childNodes.update()
+		n.childNodes.dirty = true
 	}
 	if doc := n.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
-			d.notifyMutation()
+			d.notifyMutation(n, oldParent)
 		}
 	}
 	return newChild, nil
@@ -943,8 +1048,8 @@ func (n *node) ReplaceChild(newChild Node, oldChild Node) (Node, error) {
 // replaceChildInternal handles the actual replacement without DocumentFragment expansion
 func (n *node) replaceChildInternal(newChild Node, oldChild Node) (Node, error) {
 	// Remove from current parent if exists - done internally to avoid deadlock
-	if newChild.ParentNode() != nil {
-		oldParent := newChild.ParentNode()
+	oldParent := newChild.ParentNode()
+	if oldParent != nil {
 		oc := getInternalNode(newChild)
 
 		// Update sibling links
@@ -969,7 +1074,7 @@ func (n *node) replaceChildInternal(newChild Node, oldChild Node) (Node, error)
 			}
 			// Update old parent's live NodeList if it exists
 			if op.childNodes != nil && op.childNodes.update != nil {
-				op.childNodes.update()
+				op.childNodes.dirty = true
 			}
 		}
 
@@ -1008,11 +1113,11 @@ func (n *node) replaceChildInternal(newChild Node, oldChild Node) (Node, error)
 
 	// Update live NodeList if it exists
 	if n.childNodes != nil && n.childNodes.update != nil {
-		n.childNodes.update()
+		n.childNodes.dirty = true
 	}
 	if doc := n.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
-			d.notifyMutation()
+			d.notifyMutation(n, oldParent)
 		}
 	}
 	return oldChild, nil
@@ -1047,11 +1152,11 @@ func (n *node) RemoveChild(oldChild Node) (Node, error) {
 
 	// Update live NodeList if it exists
 	if n.childNodes != nil && n.childNodes.update != nil {
-		n.childNodes.update()
+		n.childNodes.dirty = true
 	}
 	if doc := n.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
-			d.notifyMutation()
+			d.notifyMutation(n)
 		}
 	}
 	return oldChild, nil
@@ -1066,22 +1171,14 @@ func (n *node) HasChildNodes() bool {
 }
 
 func (n *node) CloneNode(deep bool) Node {
-	clone := &node{
-		nodeType:      n.nodeType,
-		nodeName:      n.nodeName,
-		nodeValue:     n.nodeValue,
-		ownerDocument: n.ownerDocument,
-		namespaceURI:  n.namespaceURI,
-		prefix:        n.prefix,
-		localName:     n.localName,
-	}
+	base := n.cloneBase()
+	clone := &base
 
 	if n.attributes != nil {
 		clone.attributes = NewNamedNodeMap()
-		for _, key := range n.attributes.order {
-			attr := n.attributes.items[key]
+		for _, attr := range n.attributes.nodes {
 			clonedAttr := attr.CloneNode(true)
-			clone.attributes.SetNamedItem(clonedAttr)
+			clone.attributes.nodes = append(clone.attributes.nodes, clonedAttr)
 		}
 	}
 
@@ -1093,73 +1190,414 @@ func (n *node) CloneNode(deep bool) Node {
 	return clone
 }
 
-func (n *node) Normalize() {
-	// Collect children and build normalized list
-	children := []Node{}
-	var mergedText strings.Builder
-	var hasTextNodes bool
+// cloneBase returns a copy of n's own properties, without its attributes,
+// relatives or children, for the CloneNode methods to build on
+func (n *node) cloneBase() node {
+	return node{
+		nodeType:      n.nodeType,
+		nodeName:      n.nodeName,
+		nodeValue:     n.nodeValue,
+		ownerDocument: n.ownerDocument,
+		namespaceURI:  n.namespaceURI,
+		prefix:        n.prefix,
+		localName:     n.localName,
+	}
+}
 
-	child := n.firstChild
-	for child != nil {
-		switch child.NodeType() {
-		case TEXT_NODE:
-			// Merge all text nodes, including empty ones
-			data := string(child.NodeValue())
-			mergedText.WriteString(data)
-			hasTextNodes = true
-			// Don't add text nodes to children - we're merging them
+// cloneChildren appends a deep copy of each of n's children to clone
+func (n *node) cloneChildren(clone Node) {
+	for child := n.firstChild; child != nil; child = child.NextSibling() {
+		clone.AppendChild(child.CloneNode(true))
+	}
+}
+
+// argNodes converts the arguments of the Living Standard's variadic
+// methods to nodes. Nodes are kept and strings, including empty ones,
+// become new Text nodes owned by doc. Anything else returns
+// HierarchyRequestError. Strings return InvalidStateError if doc is nil.
+func argNodes(doc Document, args []any) ([]Node, error) {
+	nodes := make([]Node, 0, len(args))
+	for _, arg := range args {
+		var data DOMString
+		switch v := arg.(type) {
+		case Node:
+			nodes = append(nodes, v)
+			continue
+		case DOMString:
+			data = v
+		case string:
+			data = DOMString(v)
 		default:
-			// Non-text node: flush any pending merged text first
-			if hasTextNodes {
-				// Create text node with merged content (even if empty)
-				mergedContent := mergedText.String()
-				textNode := n.ownerDocument.CreateTextNode(DOMString(mergedContent))
-				children = append(children, textNode)
-				mergedText.Reset()
-				hasTextNodes = false
-			}
-			// Recursively normalize element children
-			if child.HasChildNodes() {
-				child.Normalize()
+			return nil, NewDOMException("HierarchyRequestError", fmt.Sprintf("%T is neither a Node nor a string", arg))
+		}
+		if doc == nil {
+			return nil, NewDOMException("InvalidStateError", "No owner document to create a Text node in")
+		}
+		nodes = append(nodes, doc.CreateTextNode(data))
+	}
+	return nodes, nil
+}
+
+// replaceChildren implements ReplaceChildren. Every node is checked as a
+// child of parent before the old children are removed, so a failure leaves
+// the tree untouched. The ids of removed elements are dropped from the
+// document's index and those of the inserted ones added.
+func replaceChildren(parent Node, nodes []Node) error {
+	if getInternalNode(parent).readOnly {
+		return errReadOnly()
+	}
+	d, isDoc := parent.(*document)
+	owner := parent.OwnerDocument()
+	if isDoc {
+		owner = d
+	} else {
+		d, _ = owner.(*document)
+	}
+
+	var inserted []Node
+	for _, n := range nodes {
+		if n == nil {
+			return NewDOMException("HierarchyRequestError", "Invalid node")
+		}
+		// A document type created on its own has no owner until it is
+		// inserted, so only one owned by another document is foreign
+		if n.OwnerDocument() != owner && !(n.NodeType() == DOCUMENT_TYPE_NODE && n.OwnerDocument() == nil) {
+			return NewDOMException("WrongDocumentError", "")
+		}
+		for ancestor := parent; ancestor != nil; ancestor = ancestor.ParentNode() {
+			if isSameNode(ancestor, n) {
+				return NewDOMException("HierarchyRequestError", "Cannot insert a node as a descendant of itself")
 			}
-			children = append(children, child)
 		}
-		child = child.NextSibling()
+		if n.NodeType() == DOCUMENT_FRAGMENT_NODE {
+			for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+				inserted = append(inserted, child)
+			}
+		} else {
+			inserted = append(inserted, n)
+		}
 	}
 
-	// Flush any remaining text at the end
-	if hasTextNodes {
-		mergedContent := mergedText.String()
-		textNode := n.ownerDocument.CreateTextNode(DOMString(mergedContent))
-		children = append(children, textNode)
+	var doctype DocumentType
+	var elements int
+	for _, n := range inserted {
+		switch n.NodeType() {
+		case DOCUMENT_NODE, ATTRIBUTE_NODE, ENTITY_NODE, NOTATION_NODE:
+			return NewDOMException("HierarchyRequestError", "Node cannot be a child")
+		case DOCUMENT_TYPE_NODE:
+			if !isDoc || doctype != nil {
+				return NewDOMException("HierarchyRequestError", "Only a document can have a single document type child")
+			}
+			doctype, _ = n.(DocumentType)
+		case ELEMENT_NODE:
+			if elements++; isDoc && elements > 1 {
+				return NewDOMException("HierarchyRequestError", "Document can have only one element child")
+			}
+		case TEXT_NODE, CDATA_SECTION_NODE, ENTITY_REFERENCE_NODE:
+			if isDoc {
+				return NewDOMException("HierarchyRequestError", "Document cannot have text children")
+			}
+		}
 	}
 
-	// Clear old child relationships first
-	originalFirst := n.firstChild
-	child = originalFirst
-	for child != nil {
-		next := child.NextSibling()
-		if c := getInternalNode(child); c != nil {
-			c.parentNode = nil
-			c.previousSibling = nil
-			c.nextSibling = nil
+	for child := parent.FirstChild(); child != nil; child = parent.FirstChild() {
+		if _, err := parent.RemoveChild(child); err != nil {
+			return err
+		}
+		if d != nil {
+			d.mu.Lock()
+			d.unindexIds(child)
+			d.mu.Unlock()
+		}
+	}
+	if isDoc {
+		d.mu.Lock()
+		d.documentElement = nil
+		d.doctype = doctype
+		d.mu.Unlock()
+	}
+	for _, n := range inserted {
+		if n.NodeType() == DOCUMENT_TYPE_NODE && n.OwnerDocument() == nil {
+			// Kept as the document's doctype outside its children, as the
+			// decoder and CreateDocument do
+			continue
+		}
+		if _, err := parent.AppendChild(n); err != nil {
+			return err
+		}
+		if d != nil {
+			d.mu.Lock()
+			d.indexIds(n)
+			d.mu.Unlock()
 		}
-		child = next
 	}
+	if d != nil {
+		d.mu.Lock()
+		d.notifyMutation()
+		d.mu.Unlock()
+	}
+	return nil
+}
 
-	// Clear and rebuild children
-	n.firstChild = nil
-	n.lastChild = nil
+// MoveTo moves n and its subtree into newParent before refChild, or to the
+// end of newParent's children if refChild is nil. It inserts as
+// InsertBefore does, so live lists and the id index stay current, and it
+// also keeps the Ranges created on the document in step: boundaries inside
+// the moved subtree travel with it, and offsets in the old and new parent
+// are shifted past the node as it leaves and arrives. Documents, document
+// fragments and attributes cannot be moved and return
+// HierarchyRequestError.
+func (n *node) MoveTo(newParent Node, refChild Node) error {
+	return NewDOMException("HierarchyRequestError", "Node cannot be moved")
+}
+
+func (e *element) MoveTo(newParent Node, refChild Node) error {
+	return moveNode(e, newParent, refChild)
+}
+
+func (t *text) MoveTo(newParent Node, refChild Node) error {
+	return moveNode(t, newParent, refChild)
+}
+
+func (c *cdataSection) MoveTo(newParent Node, refChild Node) error {
+	return moveNode(c, newParent, refChild)
+}
+
+func (c *comment) MoveTo(newParent Node, refChild Node) error {
+	return moveNode(c, newParent, refChild)
+}
+
+func (pi *processingInstruction) MoveTo(newParent Node, refChild Node) error {
+	return moveNode(pi, newParent, refChild)
+}
+
+func (er *entityReference) MoveTo(newParent Node, refChild Node) error {
+	return moveNode(er, newParent, refChild)
+}
+
+func (dt *documentType) MoveTo(newParent Node, refChild Node) error {
+	return moveNode(dt, newParent, refChild)
+}
+
+// moveNode implements MoveTo for self
+func moveNode(self, newParent, refChild Node) error {
+	if newParent == nil {
+		return NewDOMException("HierarchyRequestError", "Cannot move a node to a nil parent")
+	}
+	if refChild != nil && isSameNode(refChild, self) {
+		return nil
+	}
+	oldParent := self.ParentNode()
+	var oldIndex uint32
+	if oldParent != nil {
+		oldIndex = childIndex(self)
+	}
+	if _, err := newParent.InsertBefore(self, refChild); err != nil {
+		return err
+	}
 
-	// Re-append normalized children
-	for _, child := range children {
-		n.AppendChild(child)
+	d, ok := self.OwnerDocument().(*document)
+	if !ok {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	newIndex := childIndex(self)
+	d.activeRanges = slices.DeleteFunc(d.activeRanges, func(p weak.Pointer[domRange]) bool { return p.Value() == nil })
+	for _, p := range d.activeRanges {
+		r := p.Value()
+		if r == nil || r.detached {
+			continue
+		}
+		for _, b := range []struct {
+			container Node
+			offset    *uint32
+		}{{r.startContainer, &r.startOffset}, {r.endContainer, &r.endOffset}} {
+			if oldParent != nil && isSameNode(b.container, oldParent) && *b.offset > oldIndex {
+				*b.offset--
+			}
+			if isSameNode(b.container, newParent) && *b.offset > newIndex {
+				*b.offset++
+			}
+		}
 	}
+	return nil
+}
 
-	// Update live NodeList if it exists
-	if n.childNodes != nil && n.childNodes.update != nil {
-		n.childNodes.update()
+// MoveAll moves the nodes of list, in order, into target before refChild,
+// or to the end of target's children if refChild is nil, as MoveTo does
+// for each. The list is copied first, so a live list that changes as its
+// nodes move, such as the result of GetElementsByTagName, is moved whole.
+// If refChild is itself in the list, the nodes go before the first sibling
+// after it that is not. Moving stops at the first error, leaving the nodes
+// moved so far in place.
+func MoveAll(list NodeList, target Node, refChild Node) error {
+	if list == nil {
+		return nil
+	}
+	nodes := make([]Node, 0, list.Length())
+	for i := uint(0); i < list.Length(); i++ {
+		nodes = append(nodes, list.Item(i))
+	}
+	for refChild != nil && slices.ContainsFunc(nodes, func(n Node) bool { return isSameNode(n, refChild) }) {
+		refChild = refChild.NextSibling()
+	}
+	for _, n := range nodes {
+		if err := n.MoveTo(target, refChild); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// childIndex returns the position of n among its parent's children
+func childIndex(n Node) uint32 {
+	index := uint32(0)
+	for sibling := getInternalNode(n).previousSibling; sibling != nil; sibling = getInternalNode(sibling).previousSibling {
+		index++
+	}
+	return index
+}
+
+// SafeCloneNode deep-clones n like CloneNode(true), but first checks that
+// n's subtree is a proper tree. A node reached twice, through a child or
+// sibling link shared by mistake, would make CloneNode loop or duplicate
+// nodes; SafeCloneNode returns HierarchyRequestError instead.
+func SafeCloneNode(n Node) (Node, error) {
+	if n == nil {
+		return nil, nil
+	}
+	if d, ok := n.OwnerDocument().(*document); ok {
+		d.mu.RLock()
+		err := checkTree(n)
+		d.mu.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+	} else if err := checkTree(n); err != nil {
+		return nil, err
+	}
+	return n.CloneNode(true), nil
+}
+
+// checkTree returns HierarchyRequestError if any node below root can be
+// reached more than once by following child and sibling links
+func checkTree(root Node) error {
+	visited := map[*node]bool{getInternalNode(root): true}
+	var check func(parent *node) error
+	check = func(parent *node) error {
+		for child := parent.firstChild; child != nil; {
+			impl := getInternalNode(child)
+			if impl == nil {
+				return nil
+			}
+			if visited[impl] {
+				return NewDOMException("HierarchyRequestError", fmt.Sprintf("node %q appears more than once in the tree", child.NodeName()))
+			}
+			visited[impl] = true
+			if err := check(impl); err != nil {
+				return err
+			}
+			child = impl.nextSibling
+		}
+		return nil
+	}
+	return check(getInternalNode(root))
+}
+
+// TreeIntegrityError reports an inconsistent link found by
+// Document.CheckTreeIntegrity.
+type TreeIntegrityError struct {
+	Path    string // location of the node, each step numbered among same-named siblings
+	Problem string
+}
+
+func (e *TreeIntegrityError) Error() string {
+	return e.Path + ": " + e.Problem
+}
+
+// CheckTreeIntegrity verifies that the parent, child and sibling links of
+// every node in the document agree with each other, that no node is
+// reachable twice, and that every node and attribute belongs to the
+// document. It returns one *TreeIntegrityError per problem, in document
+// order, and nil for a sound tree. It is a diagnostic for corruption left
+// by bugs; the public API keeps trees consistent.
+func (d *document) CheckTreeIntegrity() []error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var errs []error
+	report := func(path, problem string) {
+		errs = append(errs, &TreeIntegrityError{Path: path, Problem: problem})
+	}
+	visited := map[*node]bool{&d.node: true}
+
+	var check func(p *node, path string)
+	check = func(p *node, path string) {
+		if (p.firstChild == nil) != (p.lastChild == nil) {
+			report(path, "firstChild and lastChild disagree on whether there are children")
+			return
+		}
+		if p.firstChild != nil && getInternalNode(p.firstChild).previousSibling != nil {
+			report(path, "first child has a previous sibling")
+		}
+		counts := make(map[string]int)
+		var prev *node
+		for child := p.firstChild; child != nil; {
+			c := getInternalNode(child)
+			name := stepName(child)
+			counts[name]++
+			childPath := fmt.Sprintf("%s/%s[%d]", path, name, counts[name])
+			if visited[c] {
+				report(childPath, "node is reachable more than once")
+				return
+			}
+			visited[c] = true
+
+			if getInternalNode(c.parentNode) != p {
+				report(childPath, "parentNode does not point to the parent")
+			}
+			if prev != nil && getInternalNode(c.previousSibling) != prev {
+				report(childPath, "previousSibling does not point to the preceding sibling")
+			}
+			if c.ownerDocument != Document(d) {
+				report(childPath, "ownerDocument is not the document")
+			}
+			if e, ok := child.(*element); ok && e.attributes != nil {
+				for _, a := range e.attributes.nodes {
+					impl, ok := a.(*attr)
+					if !ok {
+						continue
+					}
+					if impl.ownerDocument != Document(d) {
+						report(childPath+"/@"+string(impl.nodeName), "ownerDocument is not the document")
+					}
+					if impl.ownerElement != Element(e) {
+						report(childPath+"/@"+string(impl.nodeName), "ownerElement does not point to the element")
+					}
+				}
+			}
+			check(c, childPath)
+
+			if c.nextSibling == nil && getInternalNode(p.lastChild) != c {
+				report(path, "lastChild is not the last sibling")
+			}
+			prev = c
+			child = c.nextSibling
+		}
+	}
+	check(&d.node, "")
+	return errs
+}
+
+func (n *node) Normalize() {
+	// normalizeNode rewrites sibling and parent links directly, so hold the
+	// owner document's write lock as the locking AppendChild/RemoveChild do
+	if d, ok := n.ownerDocument.(*document); ok {
+		d.mu.Lock()
+		defer d.mu.Unlock()
 	}
+	normalizeNode(n)
 }
 
 func (n *node) IsSupported(feature DOMString, version DOMString) bool {
@@ -1193,11 +1631,11 @@ func (n *node) SetPrefix(prefix DOMString) error {
 		return NewDOMException("NamespaceError", "Cannot set prefix for a node with no namespace URI")
 	}
 
-	if prefix == "xml" && n.namespaceURI != "http://www.w.org/XML/1998/namespace" {
+	if prefix == "xml" && n.namespaceURI != xmlNamespaceURI {
 		return NewDOMException("NamespaceError", "Invalid namespace URI for 'xml' prefix")
 	}
 
-	if prefix == "xmlns" && n.namespaceURI != "http://www.w3.org/2000/xmlns/" {
+	if prefix == "xmlns" && n.namespaceURI != xmlnsNamespaceURI {
 		return NewDOMException("NamespaceError", "Invalid namespace URI for 'xmlns' prefix")
 	}
 
@@ -1235,21 +1673,66 @@ func (n *node) HasAttributes() bool {
 }
 
 func (n *node) BaseURI() DOMString {
-	// For Document nodes, the base URI is the document's address.
+	// For Document nodes, the base URI is the document's address; the
+	// document type overrides BaseURI to report it.
 	if n.nodeType == DOCUMENT_NODE {
-		// Assuming Document struct has a URL field or similar.
-		// For now, return an empty string or a placeholder.
-		// This will need to be properly implemented when Document URL is added.
 		return ""
 	}
 
 	// For other nodes, it's inherited from the parent.
+	var base DOMString
 	if n.parentNode != nil {
-		return n.parentNode.BaseURI()
+		base = n.parentNode.BaseURI()
+	}
+
+	// An xml:base attribute on an element is resolved against the inherited base
+	if n.nodeType == ELEMENT_NODE {
+		if xmlBase, ok := n.xmlBase(); ok {
+			if resolved, err := resolveURIReference(base, xmlBase); err == nil {
+				return resolved
+			}
+		}
+	}
+	return base
+}
+
+// xmlBase returns the value of the node's xml:base attribute, if present
+func (n *node) xmlBase() (DOMString, bool) {
+	if n.attributes == nil {
+		return "", false
+	}
+	attr := n.attributes.GetNamedItemNS(xmlNamespaceURI, "base")
+	if attr == nil {
+		attr = n.attributes.GetNamedItem("xml:base")
+	}
+	if attr == nil {
+		return "", false
 	}
+	return attr.NodeValue(), true
+}
+
+// ResolveURI resolves a URI reference, such as an xlink:href value, against
+// the node's BaseURI and returns the absolute result. If the node has no
+// base URI the reference is returned unchanged. A malformed reference
+// returns a SyntaxError.
+func (n *node) ResolveURI(relative DOMString) (DOMString, error) {
+	return resolveURIReference(n.BaseURI(), relative)
+}
 
-	// If no parent and not a Document, then base URI is not available.
-	return "" // Or a representation of null, like an empty string for DOMString
+// resolveURIReference resolves ref against base as described by RFC 3986
+func resolveURIReference(base, ref DOMString) (DOMString, error) {
+	refURL, err := url.Parse(string(ref))
+	if err != nil {
+		return "", NewDOMException("SyntaxError", "Invalid URI reference: "+string(ref))
+	}
+	if base == "" {
+		return ref, nil
+	}
+	baseURL, err := url.Parse(string(base))
+	if err != nil {
+		return "", NewDOMException("SyntaxError", "Invalid base URI: "+string(base))
+	}
+	return DOMString(baseURL.ResolveReference(refURL).String()), nil
 }
 
 func (n *node) IsConnected() bool {
@@ -1406,6 +1889,36 @@ func (n *node) Contains(otherNode Node) bool {
 	return false // n is not an ancestor of otherNode
 }
 
+// PreviousElementSibling returns the nearest preceding sibling that is an
+// element, skipping text, comments and other nodes.
+func (n *node) PreviousElementSibling() Element {
+	sibling := n.PreviousSibling()
+	for sibling != nil {
+		if sibling.NodeType() == ELEMENT_NODE {
+			if elem, ok := sibling.(Element); ok {
+				return elem
+			}
+		}
+		sibling = sibling.PreviousSibling()
+	}
+	return nil
+}
+
+// NextElementSibling returns the nearest following sibling that is an
+// element, skipping text, comments and other nodes.
+func (n *node) NextElementSibling() Element {
+	sibling := n.NextSibling()
+	for sibling != nil {
+		if sibling.NodeType() == ELEMENT_NODE {
+			if elem, ok := sibling.(Element); ok {
+				return elem
+			}
+		}
+		sibling = sibling.NextSibling()
+	}
+	return nil
+}
+
 func (n *node) GetRootNode() Node {
 	current := Node(n)
 	for parent := current.ParentNode(); parent != nil; parent = current.ParentNode() {
@@ -1414,16 +1927,109 @@ func (n *node) GetRootNode() Node {
 	return current
 }
 
-func (n *node) IsDefaultNamespace(namespaceURI DOMString) bool {
-	// If the node is an Attr node, it does not have a default namespace.
-	if n.NodeType() == ATTRIBUTE_NODE {
-		return false
+// Depth returns the number of ancestors between n and its root node: 0 for
+// a document or a detached subtree's root, 1 for the document element.
+func (n *node) Depth() int {
+	depth := 0
+	for parent := n.ParentNode(); parent != nil; parent = parent.ParentNode() {
+		depth++
 	}
+	return depth
+}
 
-	// If the node has a namespace URI and it matches the given namespaceURI,
-	// and it has no prefix, then it's the default namespace.
-	if n.NamespaceURI() == namespaceURI && n.Prefix() == "" {
-		return true
+// SubtreeNodeCount returns the number of nodes in the subtree rooted at n,
+// including n itself. Attributes are not counted.
+func (n *node) SubtreeNodeCount() int {
+	count := 0
+	stack := []Node{n}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		count++
+		for child := current.FirstChild(); child != nil; child = child.NextSibling() {
+			stack = append(stack, child)
+		}
+	}
+	return count
+}
+
+// TextNodes returns the Text nodes in the subtree rooted at n, in document
+// order, including those inside entity references. CDATA sections are
+// included only when includeCDATA is true.
+func (n *node) TextNodes(includeCDATA bool) []Text {
+	var texts []Text
+	var walk func(parent Node)
+	walk = func(parent Node) {
+		for child := parent.FirstChild(); child != nil; child = child.NextSibling() {
+			switch child.NodeType() {
+			case TEXT_NODE:
+				texts = append(texts, child.(Text))
+			case CDATA_SECTION_NODE:
+				if includeCDATA {
+					texts = append(texts, child.(Text))
+				}
+			default:
+				walk(child)
+			}
+		}
+	}
+	walk(n)
+	return texts
+}
+
+// EachAttribute calls fn for every attribute of every element in the
+// subtree rooted at n, in document order and, within an element, in
+// attribute order. The attributes are gathered under the document's read
+// lock before fn is first called, so fn may modify the tree.
+func (n *node) EachAttribute(fn func(owner Element, attr Attr)) {
+	eachAttribute(n, nil, fn)
+}
+
+func (e *element) EachAttribute(fn func(owner Element, attr Attr)) {
+	eachAttribute(e, e, fn)
+}
+
+// eachAttribute implements EachAttribute for the subtree below root, and
+// for self first when root is an element
+func eachAttribute(root Node, self *element, fn func(owner Element, attr Attr)) {
+	type visit struct {
+		owner Element
+		attr  Attr
+	}
+	var visits []visit
+	collect := func(e *element) {
+		for _, a := range e.attributes.nodes {
+			visits = append(visits, visit{e, a.(Attr)})
+		}
+	}
+
+	d, _ := getInternalNode(root).ownerDocument.(*document)
+	if d != nil {
+		d.mu.RLock()
+	}
+	if self != nil {
+		collect(self)
+	}
+	collectElements(root, collect)
+	if d != nil {
+		d.mu.RUnlock()
+	}
+
+	for _, v := range visits {
+		fn(v.owner, v.attr)
+	}
+}
+
+func (n *node) IsDefaultNamespace(namespaceURI DOMString) bool {
+	// If the node is an Attr node, it does not have a default namespace.
+	if n.NodeType() == ATTRIBUTE_NODE {
+		return false
+	}
+
+	// If the node has a namespace URI and it matches the given namespaceURI,
+	// and it has no prefix, then it's the default namespace.
+	if n.NamespaceURI() == namespaceURI && n.Prefix() == "" {
+		return true
 	}
 
 	// For other nodes, check the parent.
@@ -1503,6 +2109,63 @@ func (n *node) IsEqualNode(otherNode Node) bool {
 	return true
 }
 
+// StructuralHash returns a hash of the subtree rooted at n covering the
+// same properties IsEqualNode compares: node types, names, namespaces,
+// values, attributes regardless of their order, and children in order.
+// Nodes for which IsEqualNode reports true hash identically, and the hash
+// is stable across runs, so it can key caches or detect that a subtree
+// changed.
+func (n *node) StructuralHash() uint64 {
+	h := fnv.New64a()
+	hashNode(h, n)
+	return h.Sum64()
+}
+
+// hashNode writes n and its subtree to h. Every field is length prefixed so
+// that adjacent values cannot run into each other.
+func hashNode(h hash.Hash64, n Node) {
+	var buf [binary.MaxVarintLen64]byte
+	writeString := func(s DOMString) {
+		h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(s)))])
+		h.Write([]byte(s))
+	}
+
+	h.Write(buf[:binary.PutUvarint(buf[:], uint64(n.NodeType()))])
+	writeString(n.NodeName())
+	writeString(n.LocalName())
+	writeString(n.NamespaceURI())
+	writeString(n.Prefix())
+	writeString(n.NodeValue())
+
+	if attrs := n.Attributes(); attrs != nil && n.NodeType() == ELEMENT_NODE {
+		sorted := make([]Attr, 0, attrs.Length())
+		for i := uint(0); i < attrs.Length(); i++ {
+			if attr, ok := attrs.Item(i).(Attr); ok {
+				sorted = append(sorted, attr)
+			}
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			if sorted[i].NamespaceURI() != sorted[j].NamespaceURI() {
+				return sorted[i].NamespaceURI() < sorted[j].NamespaceURI()
+			}
+			return sorted[i].NodeName() < sorted[j].NodeName()
+		})
+		h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(sorted)))])
+		for _, attr := range sorted {
+			hashNode(h, attr)
+		}
+	}
+
+	count := 0
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		count++
+	}
+	h.Write(buf[:binary.PutUvarint(buf[:], uint64(count))])
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		hashNode(h, child)
+	}
+}
+
 func (n *node) IsSameNode(otherNode Node) bool {
 	return isSameNode(Node(n), otherNode)
 }
@@ -1585,7 +2248,7 @@ func (n *node) TextContent() DOMString {
 		switch node.NodeType() {
 		case TEXT_NODE, CDATA_SECTION_NODE, COMMENT_NODE, PROCESSING_INSTRUCTION_NODE:
 			buf.WriteString(string(node.NodeValue()))
-		case ELEMENT_NODE, DOCUMENT_FRAGMENT_NODE:
+		case ELEMENT_NODE, DOCUMENT_FRAGMENT_NODE, ENTITY_NODE, ENTITY_REFERENCE_NODE:
 			child := node.FirstChild()
 			for child != nil {
 				traverse(child)
@@ -1604,7 +2267,7 @@ func (n *node) SetTextContent(value DOMString) {
 
 	// Update child nodes live list if it exists
 	if n.childNodes != nil && n.childNodes.update != nil {
-		n.childNodes.update()
+		n.childNodes.dirty = true
 	}
 
 	// If value is not empty, create a new text node and append it
@@ -1625,8 +2288,15 @@ type document struct {
 	implementation  DOMImplementation
 	documentElement Element
 	idMap           map[DOMString]Element
+	duplicateIds    map[DOMString]bool // ids seen on more than one element
+	idAttrName      DOMString          // attribute indexed as the id, "" meaning "id"
 	activeNodeLists []*nodeList
-	mu              sync.RWMutex // Mutex for protecting concurrent access to the DOM
+	activeElemLists []*elementList
+	activeRanges    []weak.Pointer[domRange] // ranges whose boundaries MoveTo carries along, held weakly
+	tagNameMatch    TagNameMatch
+	queryProvider   QueryProvider
+	attrDefaults    map[DOMString]map[DOMString]DOMString // tag name to attribute defaults
+	mu              sync.RWMutex                          // Mutex for protecting concurrent access to the DOM
 
 	// Document properties
 	url          DOMString
@@ -1693,11 +2363,23 @@ func (d *document) RemoveChild(oldChild Node) (Node, error) {
 
 	// Update live NodeList if it exists
 	if d.childNodes != nil && d.childNodes.update != nil {
-		d.childNodes.update()
+		d.childNodes.dirty = true
 	}
+	d.releaseSharedIds(oldChild)
 	return oldChild, nil
 }
 
+// ReplaceChildren replaces d's children with nodes, in order, as
+// Element.ReplaceChildren does. The result must be a valid document: at
+// most one document type and one element, and no text.
+func (d *document) ReplaceChildren(nodes ...any) error {
+	children, err := argNodes(d, nodes)
+	if err != nil {
+		return err
+	}
+	return replaceChildren(d, children)
+}
+
 func (d *document) Doctype() DocumentType {
 	if d.doctype != nil {
 		return d.doctype
@@ -1798,6 +2480,8 @@ func (d *document) CreateProcessingInstruction(target, data DOMString) (Processi
 	}, nil
 }
 
+// CreateAttribute creates an attribute named name with an empty value. It
+// has no owner element until it is added with Element.SetAttributeNode.
 func (d *document) CreateAttribute(name DOMString) (Attr, error) {
 	if !IsValidName(name) {
 		return nil, NewDOMException("InvalidCharacterError", "Invalid character in attribute name")
@@ -1811,26 +2495,165 @@ func (d *document) CreateAttribute(name DOMString) (Attr, error) {
 	}, nil
 }
 
+// CreateEntityReference creates an entity reference. If the document type
+// declares the entity, the reference gets a read-only copy of the entity's
+// replacement content as its children.
 func (d *document) CreateEntityReference(name DOMString) (EntityReference, error) {
-	return &entityReference{
+	ref := &entityReference{
 		node: node{
 			nodeType:      ENTITY_REFERENCE_NODE,
 			nodeName:      name,
 			ownerDocument: d,
 		},
-	}, nil
+	}
+	if dt, ok := d.doctype.(*documentType); ok && dt.entities != nil {
+		if ent := dt.entities.GetNamedItem(name); ent != nil {
+			for child := ent.FirstChild(); child != nil; child = child.NextSibling() {
+				var copied Node
+				if child.NodeType() == TEXT_NODE {
+					copied = d.CreateTextNode(child.NodeValue())
+				} else {
+					copied = child.CloneNode(true)
+				}
+				markReadOnly(copied)
+				ref.linkLastChild(ref, copied)
+			}
+		}
+	}
+	return ref, nil
+}
+
+// linkLastChild attaches child as the last child of n, whose interface
+// value is self, without any checks or list updates
+func (n *node) linkLastChild(self Node, child Node) {
+	c := getInternalNode(child)
+	c.parentNode = self
+	c.previousSibling = n.lastChild
+	c.nextSibling = nil
+	if n.lastChild != nil {
+		getInternalNode(n.lastChild).nextSibling = child
+	} else {
+		n.firstChild = child
+	}
+	n.lastChild = child
+}
+
+// markReadOnly flags n and its descendants as read-only
+func markReadOnly(n Node) {
+	getInternalNode(n).readOnly = true
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		markReadOnly(child)
+	}
+}
+
+func errReadOnly() error {
+	return NewDOMException("NoModificationAllowedError", "Node is read-only")
+}
+
+// TagNameMatch controls how GetElementsByTagName compares names.
+type TagNameMatch int
+
+const (
+	// TagNameMatchQualified matches on the qualified name only (the default,
+	// as required by the DOM specification).
+	TagNameMatchQualified TagNameMatch = iota
+	// TagNameMatchLocalName additionally matches elements whose local name
+	// equals the requested name, so "item" also finds <p:item>.
+	TagNameMatchLocalName
+)
+
+// SetTagNameMatch sets the matching mode used by GetElementsByTagName lists
+// created afterwards on this document and its elements.
+func (d *document) SetTagNameMatch(mode TagNameMatch) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tagNameMatch = mode
+}
+
+// QueryProvider supplies the results of GetElementsByTagName and
+// GetElementsByTagNameNS calls on a document and its elements, for example
+// from indexes built ahead of time over a large static document. root is
+// the node the call was made on. Returning nil leaves the call to the
+// default live list.
+type QueryProvider interface {
+	GetElementsByTagName(root Node, name DOMString) NodeList
+	GetElementsByTagNameNS(root Node, namespaceURI, localName DOMString) NodeList
+}
+
+// SetQueryProvider installs provider for the GetElementsByTagName and
+// GetElementsByTagNameNS calls made afterwards on this document and its
+// elements. Passing nil restores the default lists.
+func (d *document) SetQueryProvider(provider QueryProvider) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queryProvider = provider
+}
+
+// provider returns the installed query provider, or nil. It is read before
+// the document is locked for a query, so the provider may call back into
+// the document.
+func (d *document) provider() QueryProvider {
+	if d == nil {
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.queryProvider
+}
+
+// SetAttributeDefault declares value as the default of the attribute name
+// on elements whose qualified name is tagName, as an ATTLIST declaration
+// would. Defaults are not added to elements; Element.EffectiveAttribute
+// falls back to them when the attribute is not set.
+func (d *document) SetAttributeDefault(tagName, name, value DOMString) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.attrDefaults == nil {
+		d.attrDefaults = make(map[DOMString]map[DOMString]DOMString)
+	}
+	if d.attrDefaults[tagName] == nil {
+		d.attrDefaults[tagName] = make(map[DOMString]DOMString)
+	}
+	d.attrDefaults[tagName][name] = value
+}
+
+// AttributeDefault returns the default declared for the attribute name on
+// elements named tagName, and whether there is one.
+func (d *document) AttributeDefault(tagName, name DOMString) (DOMString, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	value, ok := d.attrDefaults[tagName][name]
+	return value, ok
+}
+
+// tagNameFilter returns the GetElementsByTagName filter for name under the
+// document's current matching mode
+func (d *document) tagNameFilter(name DOMString) func(Node) bool {
+	byLocalName := d != nil && d.tagNameMatch == TagNameMatchLocalName
+	return func(n Node) bool {
+		if n.NodeType() != ELEMENT_NODE {
+			return false
+		}
+		if name == "*" || n.NodeName() == name {
+			return true
+		}
+		return byLocalName && n.LocalName() == name
+	}
 }
 
 func (d *document) GetElementsByTagName(tagname DOMString) NodeList {
+	if p := d.provider(); p != nil {
+		if nl := p.GetElementsByTagName(d, tagname); nl != nil {
+			return nl
+		}
+	}
 	d.mu.RLock()
 	defer d.mu.RUnlock()
 	nl := &nodeList{
-		root: d,
-		filter: func(n Node) bool {
-			return n.NodeType() == ELEMENT_NODE && (tagname == "*" || n.NodeName() == tagname)
-		},
-		live: true,
-		doc:  d,
+		root:   d,
+		filter: d.tagNameFilter(tagname),
+		live:   true,
+		doc:    d,
 	}
 	nl.update = func() {
 		nodes := []Node{}
@@ -1849,7 +2672,7 @@ func (d *document) GetElementsByTagName(tagname DOMString) NodeList {
 		helper(nl.root)
 		nl.items = nodes
 	}
-	nl.update() // initial population
+	nl.dirty = true // populated on first read
 	if d.activeNodeLists == nil {
 		d.activeNodeLists = []*nodeList{}
 	}
@@ -1857,6 +2680,45 @@ func (d *document) GetElementsByTagName(tagname DOMString) NodeList {
 	return nl
 }
 
+// GetElementsByName returns a live list of the elements whose name
+// attribute equals name, in document order.
+func (d *document) GetElementsByName(name DOMString) NodeList {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.newElementList(d, func(n Node) bool {
+		e, ok := n.(*element)
+		if !ok || e.attributes == nil {
+			return false
+		}
+		attr := e.attributes.GetNamedItem("name")
+		return attr != nil && attr.NodeValue() == name
+	})
+}
+
+// newElementList returns a live list of the elements below root that
+// filter accepts, in document order, registered with d so that mutations
+// mark it stale. The caller holds d.mu.
+func (d *document) newElementList(root Node, filter func(Node) bool) *nodeList {
+	nl := &nodeList{
+		root:   root,
+		filter: filter,
+		live:   true,
+		doc:    d,
+	}
+	nl.update = func() {
+		nodes := []Node{}
+		collectElements(nl.root, func(e *element) {
+			if nl.filter(e) {
+				nodes = append(nodes, e)
+			}
+		})
+		nl.items = nodes
+	}
+	nl.dirty = true // populated on first read
+	d.activeNodeLists = append(d.activeNodeLists, nl)
+	return nl
+}
+
 func (d *document) getElementsByTagNameHelper(n Node, tagname DOMString, result *[]Node) {
 	internal := getInternalNode(n)
 	if internal == nil {
@@ -1881,38 +2743,110 @@ func (d *document) ImportNode(importedNode Node, deep bool) (Node, error) {
 	newNode := importedNode.CloneNode(deep)
 
 	// Set the owner document for the new node and its children
-	var setOwner func(Node)
-	setOwner = func(n Node) {
-		if internalNode := getInternalNode(n); internalNode != nil {
-			internalNode.ownerDocument = d
-		}
-		// Recursively set owner for children
-		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
-			setOwner(child)
-		}
-		// Recursively set owner for attributes
-		if n.Attributes() != nil {
-			for i := uint(0); i < n.Attributes().Length(); i++ {
-				setOwner(n.Attributes().Item(i))
-			}
+	setOwnerDocument(newNode, d)
+
+	d.mu.Lock()
+	d.indexIds(newNode)
+	d.mu.Unlock()
+
+	return newNode, nil
+}
+
+// setOwnerDocument makes d the owner document of n, its descendants and
+// their attributes
+func setOwnerDocument(n Node, d Document) {
+	if internalNode := getInternalNode(n); internalNode != nil {
+		internalNode.ownerDocument = d
+	}
+	// Recursively set owner for children
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		setOwnerDocument(child, d)
+	}
+	// Recursively set owner for attributes
+	if n.Attributes() != nil {
+		for i := uint(0); i < n.Attributes().Length(); i++ {
+			setOwnerDocument(n.Attributes().Item(i), d)
 		}
 	}
+}
 
-	setOwner(newNode)
+// CloneNode returns a new document from the same DOMImplementation with
+// d's URL and encoding properties. A deep clone also copies the doctype
+// and every child, and indexes the ids of the copied elements, so it can
+// serve as a snapshot of d.
+func (d *document) CloneNode(deep bool) Node {
+	impl := d.implementation
+	if impl == nil {
+		impl = NewDOMImplementation()
+	}
+	created, err := impl.CreateDocument("", "", nil)
+	if err != nil {
+		return nil
+	}
+	clone, ok := created.(*document)
+	if !ok {
+		return created
+	}
+	clone.url = d.url
+	clone.documentURI = d.documentURI
+	clone.characterSet = d.characterSet
+	clone.contentType = d.contentType
+	clone.tagNameMatch = d.tagNameMatch
+	clone.idAttrName = d.idAttrName
+	for tagName, defaults := range d.attrDefaults {
+		for name, value := range defaults {
+			clone.SetAttributeDefault(tagName, name, value)
+		}
+	}
+	if !deep {
+		return clone
+	}
 
-	return newNode, nil
+	if dt, ok := d.doctype.(*documentType); ok {
+		clone.doctype = dt.cloneFor(clone)
+	}
+	for child := d.firstChild; child != nil; child = child.NextSibling() {
+		var copied Node
+		if isSameNode(child, d.doctype) {
+			copied = clone.doctype
+		} else if copied, err = clone.ImportNode(child, true); err != nil {
+			return nil
+		}
+		if _, err := clone.AppendChild(copied); err != nil {
+			return nil
+		}
+		if elem, ok := copied.(Element); ok && isSameNode(child, d.documentElement) {
+			clone.documentElement = elem
+		}
+	}
+	return clone
 }
 
+// CreateElementNS creates an element with the given namespace and
+// qualified name. The name must be a QName: at most one colon, with a
+// non-empty prefix and local name on either side, or InvalidCharacterError
+// is returned. A prefix without a namespace, or a reserved prefix or
+// namespace used wrongly, returns NamespaceError.
 func (d *document) CreateElementNS(namespaceURI, qualifiedName DOMString) (Element, error) {
 	if !IsValidName(qualifiedName) {
 		return nil, NewDOMException("InvalidCharacterError", "Invalid character in element qualified name")
 	}
+	if err := validateQualifiedNameNS(namespaceURI, qualifiedName); err != nil {
+		return nil, err
+	}
 
-	// Reject reserved namespace URIs
-	if namespaceURI == "http://www.w3.org/2000/xmlns/" || namespaceURI == "http://www.w3.org/XML/1998/namespace" {
+	// Reject reserved namespace URIs; the XML namespace is only allowed
+	// with its own prefix
+	prefix, _ := parseQualifiedName(qualifiedName)
+	if namespaceURI == xmlnsNamespaceURI || namespaceURI == xmlNamespaceURI && prefix != "xml" {
 		return nil, NewDOMException("NamespaceError", "Reserved namespace URI")
 	}
+	return d.newElementNS(namespaceURI, qualifiedName), nil
+}
 
+// newElementNS creates an element without checking its name, for the
+// Decoder, which accepts any name XML 1.0 allows
+func (d *document) newElementNS(namespaceURI, qualifiedName DOMString) *element {
 	prefix, localName := parseQualifiedName(qualifiedName)
 	return &element{
 		node: node{
@@ -1924,27 +2858,42 @@ func (d *document) CreateElementNS(namespaceURI, qualifiedName DOMString) (Eleme
 			localName:     localName,
 			attributes:    NewNamedNodeMap(),
 		},
-	}, nil
+	}
 }
 
+// CreateAttributeNS creates an attribute with the given namespace and
+// qualified name, which is checked as in CreateElementNS.
 func (d *document) CreateAttributeNS(namespaceURI, qualifiedName DOMString) (Attr, error) {
 	if !IsValidName(qualifiedName) {
 		return nil, NewDOMException("InvalidCharacterError", "Invalid character in attribute qualified name")
 	}
+	if err := validateQualifiedNameNS(namespaceURI, qualifiedName); err != nil {
+		return nil, err
+	}
+	return newAttrNS(d, namespaceURI, qualifiedName), nil
+}
+
+// newAttrNS creates an attribute owned by doc without checking its name
+func newAttrNS(doc Document, namespaceURI, qualifiedName DOMString) *attr {
 	prefix, localName := parseQualifiedName(qualifiedName)
 	return &attr{
 		node: node{
 			nodeType:      ATTRIBUTE_NODE,
 			nodeName:      qualifiedName,
-			ownerDocument: d,
+			ownerDocument: doc,
 			namespaceURI:  namespaceURI,
 			prefix:        prefix,
 			localName:     localName,
 		},
-	}, nil
+	}
 }
 
 func (d *document) GetElementsByTagNameNS(namespaceURI, localName DOMString) NodeList {
+	if p := d.provider(); p != nil {
+		if nl := p.GetElementsByTagNameNS(d, namespaceURI, localName); nl != nil {
+			return nl
+		}
+	}
 	nl := &nodeList{
 		root: d,
 		filter: func(n Node) bool {
@@ -1972,7 +2921,7 @@ func (d *document) GetElementsByTagNameNS(namespaceURI, localName DOMString) Nod
 		helper(nl.root)
 		nl.items = nodes
 	}
-	nl.update() // initial population
+	nl.dirty = true // populated on first read
 	if d.activeNodeLists == nil {
 		d.activeNodeLists = []*nodeList{}
 	}
@@ -1999,11 +2948,42 @@ func (d *document) getElementsByTagNameNSHelper(n Node, namespaceURI, localName
 func (d *document) GetElementById(elementId DOMString) Element {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
-	if d.idMap != nil {
-		if elem := d.idMap[elementId]; elem != nil {
+	return d.elementById(elementId)
+}
+
+// SetIdAttributeName makes GetElementById index the attribute name on every
+// element in place of "id", for vocabularies whose identifiers are named
+// differently. The id index is rebuilt from the current tree. An empty name
+// restores "id".
+func (d *document) SetIdAttributeName(name DOMString) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.idAttrName = name
+	d.idMap = nil
+	d.duplicateIds = nil
+	d.indexIds(d)
+}
+
+// idAttribute returns the name of the attribute indexed as the id
+func (d *document) idAttribute() DOMString {
+	if d == nil || d.idAttrName == "" {
+		return "id"
+	}
+	return d.idAttrName
+}
+
+// elementById looks up elementId without locking. Ids known to be shared
+// by several elements are resolved by a scan so that the first element in
+// document order wins.
+func (d *document) elementById(elementId DOMString) Element {
+	if d.duplicateIds[elementId] {
+		if elem := d.firstElementWithId(elementId); elem != nil {
 			return elem
 		}
 	}
+	if elem := d.idMap[elementId]; elem != nil {
+		return elem
+	}
 	return nil
 }
 
@@ -2012,6 +2992,22 @@ func (d *document) AdoptNode(source Node) (Node, error) {
 		return nil, nil
 	}
 
+	switch source.NodeType() {
+	case ELEMENT_NODE, ATTRIBUTE_NODE, TEXT_NODE, CDATA_SECTION_NODE, COMMENT_NODE,
+		PROCESSING_INSTRUCTION_NODE, DOCUMENT_FRAGMENT_NODE, ENTITY_REFERENCE_NODE:
+	default:
+		return nil, NewDOMException("NotSupportedError", fmt.Sprintf("Cannot adopt a %s node", source.NodeName()))
+	}
+
+	// An attribute is taken from its owner element
+	if attr, ok := source.(Attr); ok {
+		if owner := attr.OwnerElement(); owner != nil {
+			if _, err := owner.RemoveAttributeNode(attr); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	// If the source node has a parent, remove it from its parent.
 	if source.ParentNode() != nil {
 		// If the source is the document element of its owner document, clear the documentElement field
@@ -2025,25 +3021,22 @@ func (d *document) AdoptNode(source Node) (Node, error) {
 		source.ParentNode().RemoveChild(source)
 	}
 
-	// Set the owner document for the source node and its children (including attributes).
-	var setOwner func(Node)
-	setOwner = func(n Node) {
-		if internalNode := getInternalNode(n); internalNode != nil {
-			internalNode.ownerDocument = d
-		}
-		// Recursively set owner for children
-		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
-			setOwner(child)
-		}
-		// Recursively set owner for attributes
-		if n.Attributes() != nil {
-			for i := uint(0); i < n.Attributes().Length(); i++ {
-				setOwner(n.Attributes().Item(i))
-			}
-		}
+	// Move the ids in the subtree from the source document's index to ours
+	sourceDoc, _ := source.OwnerDocument().(*document)
+	if sourceDoc != nil && sourceDoc != d {
+		sourceDoc.mu.Lock()
+		sourceDoc.unindexIds(source)
+		sourceDoc.mu.Unlock()
 	}
 
-	setOwner(source)
+	// Set the owner document for the source node and its children (including attributes).
+	setOwnerDocument(source, d)
+
+	if sourceDoc != d {
+		d.mu.Lock()
+		d.indexIds(source)
+		d.mu.Unlock()
+	}
 
 	return source, nil
 }
@@ -2785,63 +3778,387 @@ func (r *domRange) DeleteContents() error {
 	if r.Collapsed() {
 		return nil
 	}
-
-	// This is a simplified implementation
-	// A full implementation would handle partial text node deletion
-	// and maintain proper DOM structure
-
-	return nil
+	// Deleting is extracting without keeping what was removed
+	return r.extractInto(nil)
 }
 
 func (r *domRange) ExtractContents() (DocumentFragment, error) {
-	// This is a simplified implementation
-	// A full implementation would extract and return the contents
-	frag := r.doc.CreateDocumentFragment()
-	return frag, nil
-}
-
-func (r *domRange) CloneContents() (DocumentFragment, error) {
-	// This is a simplified implementation
-	// A full implementation would clone and return the contents
 	frag := r.doc.CreateDocumentFragment()
+	if r.Collapsed() {
+		return frag, nil
+	}
+	if err := r.splitTextBoundaries(); err != nil {
+		return nil, err
+	}
+	if err := r.extractInto(frag); err != nil {
+		return nil, err
+	}
 	return frag, nil
 }
 
-func (r *domRange) InsertNode(node Node) error {
-	if node == nil {
-		return NewDOMException("InvalidNodeTypeError", "Node cannot be null")
+// splitTextBoundaries splits Text boundary containers with SplitText so
+// both boundaries fall between nodes, leaving the selected text in nodes of
+// its own. Containers without a parent are left for extractInto to copy.
+func (r *domRange) splitTextBoundaries() error {
+	if t, ok := r.endContainer.(Text); ok && t.NodeType() == TEXT_NODE && t.ParentNode() != nil {
+		if r.endOffset > 0 && r.endOffset < r.getNodeLength(t) {
+			if _, err := t.SplitText(uint(r.endOffset)); err != nil {
+				return err
+			}
+		}
+		index := r.getNodeIndex(t)
+		if r.endOffset > 0 {
+			index++
+		}
+		r.endContainer, r.endOffset = t.ParentNode(), index
+	}
+	if t, ok := r.startContainer.(Text); ok && t.NodeType() == TEXT_NODE && t.ParentNode() != nil {
+		if r.startOffset > 0 && r.startOffset < r.getNodeLength(t) {
+			if _, err := t.SplitText(uint(r.startOffset)); err != nil {
+				return err
+			}
+			if r.endContainer == t.ParentNode() {
+				// The split added a node before the end boundary
+				r.endOffset++
+			}
+		}
+		index := r.getNodeIndex(t)
+		if r.startOffset > 0 {
+			index++
+		}
+		r.startContainer, r.startOffset = t.ParentNode(), index
 	}
-
-	// This is a simplified implementation
-	// A full implementation would properly insert the node at the start position
-
 	return nil
 }
 
-func (r *domRange) SurroundContents(newParent Node) error {
-	if newParent == nil {
-		return NewDOMException("InvalidNodeTypeError", "Node cannot be null")
+// extractInto moves the selected content into frag and collapses the range
+// to the point its contents were removed from. Partially selected elements
+// are copied shallowly and filled by extracting from a sub-range. With a nil
+// frag the content is removed and nothing is copied.
+func (r *domRange) extractInto(frag Node) error {
+	startNode, startOffset := r.startContainer, r.startOffset
+	endNode, endOffset := r.endContainer, r.endOffset
+
+	if startNode == endNode && isRangeCharacterData(startNode) {
+		if err := extractRangeData(frag, startNode, startOffset, endOffset); err != nil {
+			return err
+		}
+		r.Collapse(true)
+		return nil
 	}
 
-	// This is a simplified implementation
-	// A full implementation would extract contents and insert them into newParent
+	ancestor := r.CommonAncestorContainer()
+	var firstPartial, lastPartial Node
+	var contained []Node
+	for child := ancestor.FirstChild(); child != nil; child = child.NextSibling() {
+		switch {
+		case !isInclusiveAncestor(startNode, endNode) && isInclusiveAncestor(child, startNode):
+			firstPartial = child
+		case !isInclusiveAncestor(endNode, startNode) && isInclusiveAncestor(child, endNode):
+			lastPartial = child
+		default:
+			if r.containsNode(child) {
+				contained = append(contained, child)
+			}
+		}
+	}
+	for _, n := range contained {
+		if frag != nil && n.NodeType() == DOCUMENT_TYPE_NODE {
+			return NewDOMException("HierarchyRequestError", "Range contains a DocumentType node")
+		}
+	}
 
-	return nil
-}
+	newNode, newOffset := r.collapsePointAfterRemoval()
 
-func (r *domRange) CloneRange() Range {
-	return &domRange{
-		startContainer: r.startContainer,
-		startOffset:    r.startOffset,
-		endContainer:   r.endContainer,
-		endOffset:      r.endOffset,
-		doc:            r.doc,
-	}
+	if firstPartial != nil {
+		if isRangeCharacterData(firstPartial) {
+			if err := extractRangeData(frag, startNode, startOffset, r.getNodeLength(startNode)); err != nil {
+				return err
+			}
+		} else {
+			sub := &domRange{doc: r.doc, startContainer: startNode, startOffset: startOffset,
+				endContainer: firstPartial, endOffset: r.getNodeLength(firstPartial)}
+			if err := extractPartial(frag, firstPartial, sub); err != nil {
+				return err
+			}
+		}
+	}
+	for _, n := range contained {
+		if err := moveRangeNode(frag, n); err != nil {
+			return err
+		}
+	}
+	if lastPartial != nil {
+		if isRangeCharacterData(lastPartial) {
+			if err := extractRangeData(frag, endNode, 0, endOffset); err != nil {
+				return err
+			}
+		} else {
+			sub := &domRange{doc: r.doc, startContainer: lastPartial, startOffset: 0,
+				endContainer: endNode, endOffset: endOffset}
+			if err := extractPartial(frag, lastPartial, sub); err != nil {
+				return err
+			}
+		}
+	}
+
+	r.startContainer, r.startOffset = newNode, newOffset
+	r.Collapse(true)
+	return nil
+}
+
+// containsNode reports whether n lies entirely within the range
+func (r *domRange) containsNode(n Node) bool {
+	parent := n.ParentNode()
+	if parent == nil {
+		return false
+	}
+	index := r.getNodeIndex(n)
+	return r.comparePositions(parent, index, r.startContainer, r.startOffset) >= 0 &&
+		r.comparePositions(parent, index+1, r.endContainer, r.endOffset) <= 0
+}
+
+// extractPartial appends a shallow copy of the partially selected node
+// partial to frag and moves the part of it selected by sub into the copy
+func extractPartial(frag Node, partial Node, sub *domRange) error {
+	if frag == nil {
+		return sub.extractInto(nil)
+	}
+	clone := partial.CloneNode(false)
+	if _, err := frag.AppendChild(clone); err != nil {
+		return err
+	}
+	return sub.extractInto(clone)
+}
+
+// extractRangeData appends a copy of n holding the characters between
+// start and end to frag, unless frag is nil, and removes them from n
+func extractRangeData(frag Node, n Node, start, end uint32) error {
+	if frag == nil {
+		return deleteRangeData(n, start, end)
+	}
+	var data DOMString
+	if cd, ok := n.(CharacterData); ok {
+		data = cd.Data()
+	} else if pi, ok := n.(ProcessingInstruction); ok {
+		data = pi.Data()
+	}
+	doc := n.OwnerDocument()
+	var copied Node
+	switch n.NodeType() {
+	case TEXT_NODE:
+		copied = doc.CreateTextNode(data[start:end])
+	case CDATA_SECTION_NODE:
+		cdata, err := doc.CreateCDATASection(data[start:end])
+		if err != nil {
+			return err
+		}
+		copied = cdata
+	case COMMENT_NODE:
+		copied = doc.CreateComment(data[start:end])
+	case PROCESSING_INSTRUCTION_NODE:
+		pi, err := doc.CreateProcessingInstruction(n.NodeName(), data[start:end])
+		if err != nil {
+			return err
+		}
+		copied = pi
+	}
+	if _, err := frag.AppendChild(copied); err != nil {
+		return err
+	}
+	return deleteRangeData(n, start, end)
+}
+
+// moveRangeNode detaches n from its parent and appends it to frag, unless
+// frag is nil
+func moveRangeNode(frag Node, n Node) error {
+	if err := detachRangeNode(n); err != nil || frag == nil {
+		return err
+	}
+	_, err := frag.AppendChild(n)
+	return err
+}
+
+// detachRangeNode removes n from its parent, if it has one
+func detachRangeNode(n Node) error {
+	if parent := n.ParentNode(); parent != nil {
+		if _, err := parent.RemoveChild(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *domRange) CloneContents() (DocumentFragment, error) {
+	// This is a simplified implementation
+	// A full implementation would clone and return the contents
+	frag := r.doc.CreateDocumentFragment()
+	return frag, nil
+}
+
+func (r *domRange) InsertNode(node Node) error {
+	if node == nil {
+		return NewDOMException("InvalidNodeTypeError", "Node cannot be null")
+	}
+
+	collapsed := r.Collapsed()
+
+	// An end boundary between children follows the child after it, so it
+	// stays behind the inserted nodes and any node split off below
+	var endRef Node
+	trackEnd := !collapsed && !isRangeCharacterData(r.endContainer)
+	if trackEnd {
+		endRef = r.endContainer.FirstChild()
+		for i := uint32(0); i < r.endOffset && endRef != nil; i++ {
+			endRef = endRef.NextSibling()
+		}
+		if endRef == node {
+			endRef = node.NextSibling()
+		}
+	}
+
+	parent, reference := r.startContainer, Node(nil)
+	switch parent.NodeType() {
+	case COMMENT_NODE, PROCESSING_INSTRUCTION_NODE:
+		return NewDOMException("HierarchyRequestError", "Cannot insert into character data")
+	case TEXT_NODE, CDATA_SECTION_NODE:
+		// Insert between the two halves of the text, splitting it if needed
+		t, ok := parent.(Text)
+		if !ok || t.ParentNode() == nil {
+			return NewDOMException("HierarchyRequestError", "Text container has no parent")
+		}
+		switch {
+		case r.startOffset == 0:
+			// Keep the start boundary ahead of the inserted content
+			reference = t
+			r.startContainer, r.startOffset = t.ParentNode(), r.getNodeIndex(t)
+		case r.startOffset >= r.getNodeLength(t):
+			reference = t.NextSibling()
+		default:
+			second, err := t.SplitText(uint(r.startOffset))
+			if err != nil {
+				return err
+			}
+			if r.endContainer == t && r.endOffset > r.startOffset {
+				r.endContainer, r.endOffset = second, r.endOffset-r.startOffset
+			}
+			reference = second
+		}
+		parent = t.ParentNode()
+	default:
+		reference = parent.FirstChild()
+		for i := uint32(0); i < r.startOffset && reference != nil; i++ {
+			reference = reference.NextSibling()
+		}
+	}
+	if reference == node {
+		reference = node.NextSibling()
+	}
+
+	if _, err := parent.InsertBefore(node, reference); err != nil {
+		return err
+	}
+
+	// The start boundary stays before the inserted content; a collapsed
+	// range grows to cover it
+	if collapsed {
+		r.endContainer = parent
+		if reference != nil {
+			r.endOffset = r.getNodeIndex(reference)
+		} else {
+			r.endOffset = r.getNodeLength(parent)
+		}
+	} else if trackEnd {
+		if endRef != nil {
+			r.endOffset = r.getNodeIndex(endRef)
+		} else {
+			r.endOffset = r.getNodeLength(r.endContainer)
+		}
+	}
+	return nil
+}
+
+// SurroundContents moves the contents of the range into newParent, which
+// replaces them in the tree and is then selected by the range. Boundaries
+// inside Text nodes split them, so only the selected text is moved and the
+// rest stays in place. A range that partially selects any other node, such
+// as one starting inside an element and ending outside it, returns
+// InvalidStateError without changing the tree.
+func (r *domRange) SurroundContents(newParent Node) error {
+	if newParent == nil {
+		return NewDOMException("InvalidNodeTypeError", "Node cannot be null")
+	}
+
+	switch newParent.NodeType() {
+	case DOCUMENT_NODE, DOCUMENT_TYPE_NODE, DOCUMENT_FRAGMENT_NODE:
+		return NewDOMException("InvalidNodeTypeError", "Node cannot surround range contents")
+	}
+	if r.partiallySelectsNonText() {
+		return NewDOMException("InvalidStateError", "Range partially selects a non-Text node")
+	}
+
+	frag, err := r.ExtractContents()
+	if err != nil {
+		return err
+	}
+	for child := newParent.FirstChild(); child != nil; child = newParent.FirstChild() {
+		if _, err := newParent.RemoveChild(child); err != nil {
+			return err
+		}
+	}
+	if err := r.InsertNode(newParent); err != nil {
+		return err
+	}
+	if _, err := newParent.AppendChild(frag); err != nil {
+		return err
+	}
+	return r.SelectNode(newParent)
+}
+
+// partiallySelectsNonText reports whether a node other than a Text node
+// encloses one boundary of the range but not the other
+func (r *domRange) partiallySelectsNonText() bool {
+	check := func(from, other Node) bool {
+		for n := from; n != nil; n = n.ParentNode() {
+			if isInclusiveAncestor(n, other) {
+				return false
+			}
+			if t := n.NodeType(); t != TEXT_NODE && t != CDATA_SECTION_NODE {
+				return true
+			}
+		}
+		return false
+	}
+	return check(r.startContainer, r.endContainer) || check(r.endContainer, r.startContainer)
+}
+
+func (r *domRange) CloneRange() Range {
+	clone := &domRange{
+		startContainer: r.startContainer,
+		startOffset:    r.startOffset,
+		endContainer:   r.endContainer,
+		endOffset:      r.endOffset,
+		doc:            r.doc,
+	}
+	if d, ok := r.doc.(*document); ok {
+		d.mu.Lock()
+		d.activeRanges = append(d.activeRanges, weak.Make(clone))
+		d.mu.Unlock()
+	}
+	return clone
 }
 
 func (r *domRange) Detach() {
 	// Mark the range as detached
 	r.detached = true
+	if d, ok := r.doc.(*document); ok {
+		d.mu.Lock()
+		d.activeRanges = slices.DeleteFunc(d.activeRanges, func(p weak.Pointer[domRange]) bool {
+			other := p.Value()
+			return other == nil || other == r
+		})
+		d.mu.Unlock()
+	}
 }
 
 func (r *domRange) IsPointInRange(node Node, offset uint32) (bool, error) {
@@ -2907,10 +4224,61 @@ func (r *domRange) IntersectsNode(node Node) bool {
 	return cmp >= 0
 }
 
+// Intersects reports whether r and other overlap. Ranges that only meet at
+// a boundary point share no content and do not intersect, but a collapsed
+// range intersects a range containing its point, boundaries included.
+func (r *domRange) Intersects(other Range) bool {
+	if other == nil {
+		return false
+	}
+	// r starts before other ends, and other starts before r ends
+	startsBefore, err := r.CompareBoundaryPoints(END_TO_START, other)
+	if err != nil {
+		return false
+	}
+	endsAfter, err := r.CompareBoundaryPoints(START_TO_END, other)
+	if err != nil {
+		return false
+	}
+	if r.Collapsed() || other.Collapsed() {
+		return startsBefore <= 0 && endsAfter >= 0
+	}
+	return startsBefore < 0 && endsAfter > 0
+}
+
+// ToString returns the text selected by the range: the clipped data of Text
+// boundary containers and the data of every Text and CDATASection node in
+// between, in document order. Comments and processing instructions are
+// left out.
 func (r *domRange) ToString() string {
-	// This is a simplified implementation
-	// A full implementation would extract text content from the range
-	return ""
+	if r.startContainer == nil || r.Collapsed() {
+		return ""
+	}
+	isText := func(n Node) bool {
+		t := n.NodeType()
+		return t == TEXT_NODE || t == CDATA_SECTION_NODE
+	}
+	data := func(n Node) string {
+		return string(n.NodeValue())
+	}
+
+	if r.startContainer == r.endContainer && isText(r.startContainer) {
+		return data(r.startContainer)[r.startOffset:r.endOffset]
+	}
+
+	var b strings.Builder
+	if isText(r.startContainer) {
+		b.WriteString(data(r.startContainer)[r.startOffset:])
+	}
+	for _, n := range r.containedNodes() {
+		for _, t := range n.TextNodes(true) {
+			b.WriteString(string(t.Data()))
+		}
+	}
+	if isText(r.endContainer) {
+		b.WriteString(data(r.endContainer)[:r.endOffset])
+	}
+	return b.String()
 }
 
 // Helper methods
@@ -2924,10 +4292,13 @@ func (r *domRange) validateOffset(node Node, offset uint32) error {
 
 func (r *domRange) getNodeLength(node Node) uint32 {
 	switch node.NodeType() {
-	case TEXT_NODE, COMMENT_NODE, PROCESSING_INSTRUCTION_NODE:
+	case TEXT_NODE, CDATA_SECTION_NODE, COMMENT_NODE, PROCESSING_INSTRUCTION_NODE:
 		if cd, ok := node.(CharacterData); ok {
 			return uint32(cd.Length())
 		}
+		if pi, ok := node.(ProcessingInstruction); ok {
+			return uint32(len(pi.Data()))
+		}
 	default:
 		count := uint32(0)
 		for child := node.FirstChild(); child != nil; child = child.NextSibling() {
@@ -3017,9 +4388,89 @@ func (r *domRange) comparePositions(node1 Node, offset1 uint32, node2 Node, offs
 	return 0
 }
 
+// containedNodes returns the nodes fully selected by the range whose
+// parent is not itself fully selected, in document order
+func (r *domRange) containedNodes() []Node {
+	ancestor := r.CommonAncestorContainer()
+	if ancestor == nil {
+		return nil
+	}
+	var contained []Node
+	var walk func(parent Node)
+	walk = func(parent Node) {
+		index := uint32(0)
+		for child := parent.FirstChild(); child != nil; child = child.NextSibling() {
+			switch {
+			case r.comparePositions(parent, index+1, r.startContainer, r.startOffset) <= 0:
+				// Ends before the start boundary
+			case r.comparePositions(parent, index, r.endContainer, r.endOffset) >= 0:
+				return
+			case isInclusiveAncestor(child, r.startContainer), isInclusiveAncestor(child, r.endContainer):
+				walk(child)
+			default:
+				contained = append(contained, child)
+			}
+			index++
+		}
+	}
+	walk(ancestor)
+	return contained
+}
+
+// collapsePointAfterRemoval returns the boundary point the range collapses
+// to once its contents are removed: the start boundary if the start
+// container encloses the end container, otherwise the point just after the
+// start container's highest ancestor that does not enclose the end.
+func (r *domRange) collapsePointAfterRemoval() (Node, uint32) {
+	if isInclusiveAncestor(r.startContainer, r.endContainer) {
+		return r.startContainer, r.startOffset
+	}
+	reference := r.startContainer
+	for parent := reference.ParentNode(); parent != nil && !isInclusiveAncestor(parent, r.endContainer); parent = reference.ParentNode() {
+		reference = parent
+	}
+	return reference.ParentNode(), r.getNodeIndex(reference) + 1
+}
+
+// isInclusiveAncestor reports whether ancestor is n or one of its ancestors
+func isInclusiveAncestor(ancestor, n Node) bool {
+	for ; n != nil; n = n.ParentNode() {
+		if isSameNode(n, ancestor) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRangeCharacterData reports whether range offsets within n count
+// characters rather than children
+func isRangeCharacterData(n Node) bool {
+	switch n.NodeType() {
+	case TEXT_NODE, CDATA_SECTION_NODE, COMMENT_NODE, PROCESSING_INSTRUCTION_NODE:
+		return true
+	}
+	return false
+}
+
+// deleteRangeData removes the characters between start and end from a
+// character data node or processing instruction
+func deleteRangeData(n Node, start, end uint32) error {
+	if end <= start {
+		return nil
+	}
+	if cd, ok := n.(CharacterData); ok {
+		return cd.DeleteData(uint(start), uint(end-start))
+	}
+	if pi, ok := n.(ProcessingInstruction); ok {
+		data := pi.Data()
+		return pi.SetData(data[:start] + data[end:])
+	}
+	return nil
+}
+
 func (d *document) CreateRange() Range {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
 	r := &domRange{
 		startContainer: d,
@@ -3028,6 +4479,7 @@ func (d *document) CreateRange() Range {
 		endOffset:      0,
 		doc:            d,
 	}
+	d.activeRanges = append(d.activeRanges, weak.Make(r))
 	return r
 }
 
@@ -3037,84 +4489,109 @@ func (d *document) NormalizeDocument() {
 
 	// Normalize the document element if it exists
 	if d.documentElement != nil {
-		d.documentElement.Normalize()
+		normalizeNode(d.documentElement)
 	} else {
 		// If no document element, normalize the document itself
-		d.Normalize()
+		normalizeNode(d)
 	}
 }
 
-func normalizeNode(node Node) {
-	// First recursively normalize all element children
-	child := node.FirstChild()
-	for child != nil {
-		next := child.NextSibling()
-		if child.NodeType() == ELEMENT_NODE {
-			normalizeNode(child)
-		}
-		child = next
+func (d *document) Normalize() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	normalizeNode(d)
+}
+
+// normalizeNode merges adjacent Text nodes and removes empty ones throughout
+// the subtree rooted at root. The subtree is walked with an explicit stack so
+// deeply nested documents cannot exhaust the goroutine stack, and text is
+// spliced in place: the first node of each run keeps its identity and
+// absorbs the data of its following siblings. Callers must hold the owner
+// document's write lock.
+func normalizeNode(root Node) {
+	rootNode := getInternalNode(root)
+	if rootNode == nil {
+		return
 	}
 
-	// Now normalize this node's direct children - collect and merge text nodes
-	children := []Node{}
-	var mergedText strings.Builder
-	var hasPendingText bool
+	mutated := false
+	stack := []*node{rootNode}
+	for len(stack) > 0 {
+		parent := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
 
-	child = node.FirstChild()
-	for child != nil {
-		switch child.NodeType() {
-		case TEXT_NODE:
-			if data := string(child.NodeValue()); data != "" {
-				mergedText.WriteString(data)
-				hasPendingText = true
+		changed := false
+		for child := parent.firstChild; child != nil; {
+			next := child.NextSibling()
+			if child.NodeType() != TEXT_NODE {
+				if c := getInternalNode(child); c != nil && c.firstChild != nil {
+					stack = append(stack, c)
+				}
+				child = next
+				continue
 			}
-			// Don't add the original text node to children - we're merging
-		default:
-			// Non-text node: flush any pending merged text
-			if hasPendingText {
-				textNode := node.OwnerDocument().CreateTextNode(DOMString(mergedText.String()))
-				children = append(children, textNode)
-				mergedText.Reset()
-				hasPendingText = false
+
+			t := getInternalNode(child)
+			if next != nil && next.NodeType() == TEXT_NODE {
+				var merged strings.Builder
+				merged.WriteString(string(t.nodeValue))
+				for next != nil && next.NodeType() == TEXT_NODE {
+					merged.WriteString(string(next.NodeValue()))
+					following := next.NextSibling()
+					parent.unlinkChild(next)
+					next = following
+				}
+				t.nodeValue = DOMString(merged.String())
+				changed = true
 			}
-			children = append(children, child)
+			if t.nodeValue == "" {
+				parent.unlinkChild(child)
+				changed = true
+			}
+			child = next
 		}
-		child = child.NextSibling()
-	}
 
-	// Flush any remaining text at the end
-	if hasPendingText {
-		textNode := node.OwnerDocument().CreateTextNode(DOMString(mergedText.String()))
-		children = append(children, textNode)
+		if changed {
+			mutated = true
+			// Update live NodeList if it exists
+			if parent.childNodes != nil && parent.childNodes.update != nil {
+				parent.childNodes.dirty = true
+			}
+		}
 	}
 
-	// Clear and rebuild children if we have changes to make
-	if n := getInternalNode(node); n != nil {
-		// Clear all child relationships
-		oldChild := n.firstChild
-		for oldChild != nil {
-			next := oldChild.NextSibling()
-			if oc := getInternalNode(oldChild); oc != nil {
-				oc.parentNode = nil
-				oc.previousSibling = nil
-				oc.nextSibling = nil
-			}
-			oldChild = next
+	if mutated {
+		if d, ok := rootNode.ownerDocument.(*document); ok {
+			d.notifyMutation(root)
 		}
+	}
+}
 
-		n.firstChild = nil
-		n.lastChild = nil
+// unlinkChild detaches oldChild from n's child list, fixing up sibling and
+// first/last child links. It does not take locks, refresh live lists or
+// notify the document; callers are responsible for that.
+func (n *node) unlinkChild(oldChild Node) {
+	oc := getInternalNode(oldChild)
 
-		// Re-append normalized children
-		for _, child := range children {
-			node.AppendChild(child)
+	if oc.previousSibling != nil {
+		if prevNode := getInternalNode(oc.previousSibling); prevNode != nil {
+			prevNode.nextSibling = oc.nextSibling
 		}
+	} else {
+		n.firstChild = oc.nextSibling
+	}
 
-		// Update live NodeList if it exists
-		if n.childNodes != nil && n.childNodes.update != nil {
-			n.childNodes.update()
+	if oc.nextSibling != nil {
+		if nextNode := getInternalNode(oc.nextSibling); nextNode != nil {
+			nextNode.previousSibling = oc.previousSibling
 		}
+	} else {
+		n.lastChild = oc.previousSibling
 	}
+
+	oc.parentNode = nil
+	oc.nextSibling = nil
+	oc.previousSibling = nil
 }
 
 func (d *document) RenameNode(node Node, namespaceURI, qualifiedName DOMString) (Node, error) {
@@ -3127,38 +4604,49 @@ func (d *document) RenameNode(node Node, namespaceURI, qualifiedName DOMString)
 
 	// Only element and attribute nodes can be renamed
 	switch node.NodeType() {
-	case ELEMENT_NODE:
-		if elem, ok := node.(*element); ok {
-			// Validate the qualified name
-			if qualifiedName == "" {
-				return nil, NewDOMException("InvalidCharacterError", "Qualified name cannot be empty")
-			}
-
-			// Update the element's name and namespace directly
-			elem.nodeName = qualifiedName
-			elem.namespaceURI = namespaceURI
-			elem.localName = qualifiedName // Should be parsed from qualifiedName
+	case ELEMENT_NODE, ATTRIBUTE_NODE:
+	default:
+		return nil, NewDOMException("NotSupportedError", "Only element and attribute nodes can be renamed")
+	}
 
-			return elem, nil
-		}
+	// Validate the qualified name as CreateElementNS and CreateAttributeNS do
+	if qualifiedName == "" {
+		return nil, NewDOMException("InvalidCharacterError", "Qualified name cannot be empty")
+	}
+	if !IsValidName(qualifiedName) {
+		return nil, NewDOMException("InvalidCharacterError", "Invalid character in qualified name")
+	}
+	if err := validateQualifiedNameNS(namespaceURI, qualifiedName); err != nil {
+		return nil, err
+	}
+	prefix, localName := parseQualifiedName(qualifiedName)
 
-	case ATTRIBUTE_NODE:
-		if attr, ok := node.(*attr); ok {
-			// Validate the qualified name
-			if qualifiedName == "" {
-				return nil, NewDOMException("InvalidCharacterError", "Qualified name cannot be empty")
+	switch n := node.(type) {
+	case *element:
+		if namespaceURI == xmlnsNamespaceURI || namespaceURI == xmlNamespaceURI && prefix != "xml" {
+			return nil, NewDOMException("NamespaceError", "Reserved namespace URI")
+		}
+		n.nodeName = qualifiedName
+		n.namespaceURI = namespaceURI
+		n.prefix = prefix
+		n.localName = localName
+		d.notifyMutation(n)
+		return n, nil
+	case *attr:
+		oldName := n.nodeName
+		n.nodeName = qualifiedName
+		n.namespaceURI = namespaceURI
+		n.prefix = prefix
+		n.localName = localName
+		// Renaming to or from id moves the owner element in the id index
+		if owner, ok := n.ownerElement.(*element); ok && oldName != qualifiedName {
+			if oldName == d.idAttribute() {
+				d.releaseId(owner, n.nodeValue)
 			}
-
-			// Update the attribute's name and namespace
-			attr.nodeName = qualifiedName
-			attr.namespaceURI = namespaceURI
-			attr.localName = qualifiedName // Should be parsed from qualifiedName
-
-			return attr, nil
+			d.updateIdMappingForElement(owner, qualifiedName, "", n.nodeValue)
 		}
-
-	default:
-		return nil, NewDOMException("NotSupportedError", "Only element and attribute nodes can be renamed")
+		d.notifyMutation(n.ownerElement)
+		return n, nil
 	}
 
 	return nil, NewDOMException("InvalidNodeTypeError", "Invalid node type")
@@ -3177,6 +4665,25 @@ func (d *document) DocumentURI() DOMString {
 	return d.documentURI
 }
 
+// SetDocumentURI sets the document's address, which is also its URL and
+// the base URI inherited by its nodes.
+func (d *document) SetDocumentURI(uri DOMString) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.documentURI = uri
+	d.url = uri
+}
+
+// BaseURI returns the document's address
+func (d *document) BaseURI() DOMString {
+	return d.DocumentURI()
+}
+
+// ResolveURI resolves a URI reference against the document's address
+func (d *document) ResolveURI(relative DOMString) (DOMString, error) {
+	return resolveURIReference(d.BaseURI(), relative)
+}
+
 func (d *document) CharacterSet() DOMString {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
@@ -3288,35 +4795,62 @@ func (d *document) removeIdMapping(idValue DOMString) {
 }
 
 func (d *document) updateIdMappingForElement(element Element, attributeName DOMString, oldValue, newValue DOMString) {
-	if attributeName != "id" {
+	if attributeName != d.idAttribute() {
 		return
 	}
 
 	// Remove old mapping if it exists
-	if oldValue != "" {
-		d.removeIdMapping(oldValue)
-	}
+	d.releaseId(element, oldValue)
 
 	// Add new mapping if new value is not empty
 	if newValue != "" {
 		if d.idMap == nil {
 			d.idMap = make(map[DOMString]Element)
 		}
+		if existing := d.idMap[newValue]; existing != nil && !isSameNode(existing, element) && elementHasId(existing, newValue) {
+			if d.duplicateIds == nil {
+				d.duplicateIds = make(map[DOMString]bool)
+			}
+			d.duplicateIds[newValue] = true
+		}
 		d.idMap[newValue] = element
 	}
 }
 
-// notifyMutation is called whenever the DOM tree is mutated. It iterates
-// over all active live NodeLists and calls their update function to keep
-// them in sync with the DOM.
-func (d *document) notifyMutation() {
+// notifyMutation is called whenever the DOM tree is mutated. It marks the
+// active live lists dirty; each is recomputed the next time it is read, so
+// a mutation costs O(lists) however large the tree, and lists that are not
+// read again cost nothing more. targets are the nodes whose subtrees
+// changed, such as the parent a child was inserted into and the parent it
+// was moved from. A list whose root contains none of them is left clean.
+// With no targets every list is marked.
+func (d *document) notifyMutation(targets ...Node) {
 	for _, nl := range d.activeNodeLists {
-		if nl.update != nil {
-			nl.update()
+		if !nl.dirty && mutationAffects(nl.root, targets) {
+			nl.dirty = true
+		}
+	}
+	for _, el := range d.activeElemLists {
+		if !el.dirty && mutationAffects(el.root, targets) {
+			el.dirty = true
 		}
 	}
 }
 
+// mutationAffects reports whether a change to the subtree of any of targets
+// can change a list rooted at root. Lists without a root are always affected.
+func mutationAffects(root Node, targets []Node) bool {
+	if root == nil || len(targets) == 0 {
+		return true
+	}
+	for _, target := range targets {
+		if target != nil && isInclusiveAncestor(root, target) {
+			return true
+		}
+	}
+	return false
+}
+
 // ===========================================================================
 // Element Implementation
 // ===========================================================================
@@ -3389,8 +4923,8 @@ func (e *element) InsertBefore(newChild Node, refChild Node) (Node, error) {
 func (e *element) insertBeforeInternal(newChild Node, refChild Node) (Node, error) {
 
 	// Remove from current parent if exists - done internally to avoid deadlock
-	if newChild.ParentNode() != nil {
-		oldParent := newChild.ParentNode()
+	oldParent := newChild.ParentNode()
+	if oldParent != nil {
 		oc := getInternalNode(newChild)
 
 		// Update sibling links
@@ -3415,7 +4949,7 @@ func (e *element) insertBeforeInternal(newChild Node, refChild Node) (Node, erro
 			}
 			// Update old parent's live NodeList if it exists
 			if op.childNodes != nil && op.childNodes.update != nil {
-				op.childNodes.update()
+				op.childNodes.dirty = true
 			}
 		}
 
@@ -3464,11 +4998,11 @@ func (e *element) insertBeforeInternal(newChild Node, refChild Node) (Node, erro
 
 	// Update live NodeList if it exists
 	if e.childNodes != nil && e.childNodes.update != nil {
-		e.childNodes.update()
+		e.childNodes.dirty = true
 	}
 	if doc := e.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
-			d.notifyMutation()
+			d.notifyMutation(e, oldParent)
 		}
 	}
 	return newChild, nil
@@ -3560,8 +5094,8 @@ func (e *element) ReplaceChild(newChild Node, oldChild Node) (Node, error) {
 func (e *element) replaceChildInternal(newChild Node, oldChild Node) (Node, error) {
 
 	// Remove from current parent if exists - done internally to avoid deadlock
-	if newChild.ParentNode() != nil {
-		oldParent := newChild.ParentNode()
+	oldParent := newChild.ParentNode()
+	if oldParent != nil {
 		oc := getInternalNode(newChild)
 
 		// Update sibling links
@@ -3586,7 +5120,7 @@ func (e *element) replaceChildInternal(newChild Node, oldChild Node) (Node, erro
 			}
 			// Update old parent's live NodeList if it exists
 			if op.childNodes != nil && op.childNodes.update != nil {
-				op.childNodes.update()
+				op.childNodes.dirty = true
 			}
 		}
 
@@ -3625,7 +5159,12 @@ func (e *element) replaceChildInternal(newChild Node, oldChild Node) (Node, erro
 
 	// Update live NodeList if it exists
 	if e.childNodes != nil && e.childNodes.update != nil {
-		e.childNodes.update()
+		e.childNodes.dirty = true
+	}
+	if doc := e.OwnerDocument(); doc != nil {
+		if d, ok := doc.(*document); ok {
+			d.notifyMutation(e, oldParent)
+		}
 	}
 	return oldChild, nil
 }
@@ -3667,40 +5206,50 @@ func (e *element) RemoveChild(oldChild Node) (Node, error) {
 
 	// Update live NodeList if it exists
 	if e.childNodes != nil && e.childNodes.update != nil {
-		e.childNodes.update()
+		e.childNodes.dirty = true
 	}
 	if doc := e.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
-			d.notifyMutation()
+			d.releaseSharedIds(oldChild)
+			d.notifyMutation(e)
 		}
 	}
 	return oldChild, nil
 }
 
 func (e *element) CloneNode(deep bool) Node {
-	clone := &element{
-		node: node{
-			nodeType:      e.nodeType,
-			nodeName:      e.nodeName,
-			nodeValue:     e.nodeValue,
-			ownerDocument: e.ownerDocument,
-			namespaceURI:  e.namespaceURI,
-			prefix:        e.prefix,
-			localName:     e.localName,
-		},
-	}
+	clone := &element{node: e.cloneBase()}
 
 	if e.attributes != nil {
 		clone.attributes = NewNamedNodeMap()
-		for _, key := range e.attributes.order {
-			attr := e.attributes.items[key]
-			clonedAttr := attr.CloneNode(true)
-			clone.attributes.SetNamedItem(clonedAttr)
+		for _, a := range e.attributes.nodes {
+			clonedAttr := a.CloneNode(true)
+			if impl, ok := clonedAttr.(*attr); ok {
+				impl.ownerElement = clone
+			}
+			clone.attributes.nodes = append(clone.attributes.nodes, clonedAttr)
 		}
 	}
 
 	if deep {
-		for child := e.firstChild; child != nil; child = child.NextSibling() {
+		e.cloneChildren(clone)
+	}
+	return clone
+}
+
+// CloneWithChildren clones the element and its attributes. When deep is true
+// this is the same as CloneNode(true); otherwise only the children for which
+// keep returns true are deep-cloned into the copy.
+func (e *element) CloneWithChildren(deep bool, keep func(Node) bool) Element {
+	if deep {
+		return e.CloneNode(true).(Element)
+	}
+	clone := e.CloneNode(false).(*element)
+	if keep == nil {
+		return clone
+	}
+	for child := e.firstChild; child != nil; child = child.NextSibling() {
+		if keep(child) {
 			clone.AppendChild(child.CloneNode(true))
 		}
 	}
@@ -3740,7 +5289,10 @@ func (e *element) removeChildInternal(oldChild Node) error {
 
 	// Update live NodeList if it exists
 	if e.childNodes != nil && e.childNodes.update != nil {
-		e.childNodes.update()
+		e.childNodes.dirty = true
+	}
+	if d, ok := e.ownerDocument.(*document); ok {
+		d.releaseSharedIds(oldChild)
 	}
 	return nil
 }
@@ -3798,7 +5350,7 @@ func (e *element) SetAttribute(name, value DOMString) error {
 	if doc := e.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
 			d.updateIdMappingForElement(e, name, oldValue, value)
-			d.notifyMutation()
+			d.notifyMutation(e)
 		}
 	}
 	return nil
@@ -3824,7 +5376,7 @@ func (e *element) RemoveAttribute(name DOMString) error {
 	if doc := e.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
 			d.updateIdMappingForElement(e, name, oldValue, "")
-			d.notifyMutation()
+			d.notifyMutation(e)
 		}
 	}
 	return nil
@@ -3876,7 +5428,7 @@ func (e *element) SetAttributeNode(newAttr Attr) (Attr, error) {
 	if doc := e.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
 			d.updateIdMappingForElement(e, newAttr.NodeName(), oldValue, newAttr.NodeValue())
-			d.notifyMutation()
+			d.notifyMutation(e)
 		}
 	}
 	if oldNode != nil {
@@ -3907,7 +5459,7 @@ func (e *element) RemoveAttributeNode(oldAttr Attr) (Attr, error) {
 
 	if doc := e.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
-			d.notifyMutation()
+			d.notifyMutation(e)
 		}
 	}
 	return removedNode.(Attr), nil
@@ -3919,17 +5471,20 @@ func (e *element) GetElementsByTagName(name DOMString) NodeList {
 		// Should not happen in a well-formed document
 		return &nodeList{items: []Node{}}
 	}
+	if p := doc.provider(); p != nil {
+		if nl := p.GetElementsByTagName(e, name); nl != nil {
+			return nl
+		}
+	}
 	if doc != nil {
 		doc.mu.RLock()
 		defer doc.mu.RUnlock()
 	}
 	nl := &nodeList{
-		root: e,
-		filter: func(n Node) bool {
-			return n.NodeType() == ELEMENT_NODE && (name == "*" || n.NodeName() == name)
-		},
-		live: true,
-		doc:  doc,
+		root:   e,
+		filter: doc.tagNameFilter(name),
+		live:   true,
+		doc:    doc,
 	}
 	nl.update = func() {
 		nodes := []Node{}
@@ -3951,7 +5506,7 @@ func (e *element) GetElementsByTagName(name DOMString) NodeList {
 		}
 		nl.items = nodes
 	}
-	nl.update() // initial population
+	nl.dirty = true // populated on first read
 	if doc.activeNodeLists == nil {
 		doc.activeNodeLists = []*nodeList{}
 	}
@@ -3959,6 +5514,30 @@ func (e *element) GetElementsByTagName(name DOMString) NodeList {
 	return nl
 }
 
+// GetElementsByTagNames returns a live list of e's descendant elements
+// whose name matches any of names, in document order. Names are matched as
+// GetElementsByTagName matches them.
+func (e *element) GetElementsByTagNames(names ...DOMString) NodeList {
+	doc, ok := e.ownerDocument.(*document)
+	if !ok {
+		return &nodeList{items: []Node{}}
+	}
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+	filters := make([]func(Node) bool, len(names))
+	for i, name := range names {
+		filters[i] = doc.tagNameFilter(name)
+	}
+	return doc.newElementList(e, func(n Node) bool {
+		for _, filter := range filters {
+			if filter(n) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
 func (e *element) GetAttributeNS(namespaceURI, localName DOMString) DOMString {
 	if doc := e.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
@@ -3974,7 +5553,22 @@ func (e *element) GetAttributeNS(namespaceURI, localName DOMString) DOMString {
 	return ""
 }
 
+// SetAttributeNS sets the value of the attribute with the given namespace
+// and local name, creating it with qualifiedName if it does not exist. The
+// name is checked as in Document.CreateAttributeNS.
 func (e *element) SetAttributeNS(namespaceURI, qualifiedName, value DOMString) error {
+	if !IsValidName(qualifiedName) {
+		return NewDOMException("InvalidCharacterError", "Invalid character in attribute qualified name")
+	}
+	if err := validateQualifiedNameNS(namespaceURI, qualifiedName); err != nil {
+		return err
+	}
+	return e.setAttributeNS(namespaceURI, qualifiedName, value)
+}
+
+// setAttributeNS implements SetAttributeNS without checking the name, for
+// the Decoder
+func (e *element) setAttributeNS(namespaceURI, qualifiedName, value DOMString) error {
 	if doc := e.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
 			d.mu.Lock()
@@ -3991,9 +5585,8 @@ func (e *element) SetAttributeNS(namespaceURI, qualifiedName, value DOMString) e
 		existingAttr.SetNodeValue(value)
 	} else {
 		oldValue = ""
-		newAttr, _ := e.ownerDocument.CreateAttributeNS(namespaceURI, qualifiedName)
-		a := newAttr.(*attr)
-		a.ownerElement = e
+		newAttr := newAttrNS(e.ownerDocument, namespaceURI, qualifiedName)
+		newAttr.ownerElement = e
 		newAttr.SetValue(value)
 		e.attributes.SetNamedItemNS(newAttr)
 	}
@@ -4002,7 +5595,7 @@ func (e *element) SetAttributeNS(namespaceURI, qualifiedName, value DOMString) e
 	if doc := e.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
 			d.updateIdMappingForElement(e, localName, oldValue, value)
-			d.notifyMutation()
+			d.notifyMutation(e)
 		}
 	}
 	return nil
@@ -4020,7 +5613,7 @@ func (e *element) RemoveAttributeNS(namespaceURI, localName DOMString) error {
 	}
 	if doc := e.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
-			d.notifyMutation()
+			d.notifyMutation(e)
 		}
 	}
 	return nil
@@ -4059,7 +5652,7 @@ func (e *element) SetAttributeNodeNS(newAttr Attr) (Attr, error) {
 	a.ownerElement = e
 	if doc := e.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
-			d.notifyMutation()
+			d.notifyMutation(e)
 		}
 	}
 	if oldNode != nil {
@@ -4074,6 +5667,11 @@ func (e *element) GetElementsByTagNameNS(namespaceURI, localName DOMString) Node
 		// Should not happen in a well-formed document
 		return &nodeList{items: []Node{}}
 	}
+	if p := doc.provider(); p != nil {
+		if nl := p.GetElementsByTagNameNS(e, namespaceURI, localName); nl != nil {
+			return nl
+		}
+	}
 	if doc != nil {
 		doc.mu.RLock()
 		defer doc.mu.RUnlock()
@@ -4108,7 +5706,7 @@ func (e *element) GetElementsByTagNameNS(namespaceURI, localName DOMString) Node
 		}
 		nl.items = nodes
 	}
-	nl.update() // initial population
+	nl.dirty = true // populated on first read
 	if doc.activeNodeLists == nil {
 		doc.activeNodeLists = []*nodeList{}
 	}
@@ -4147,6 +5745,160 @@ func (e *element) HasAttributeNS(namespaceURI, localName DOMString) bool {
 	return e.hasAttributeNSInternal(namespaceURI, localName)
 }
 
+// NonNamespaceAttributes returns the element's attributes, in order,
+// skipping namespace declarations (see IsNamespaceDeclaration).
+func (e *element) NonNamespaceAttributes() []Attr {
+	if doc := e.OwnerDocument(); doc != nil {
+		if d, ok := doc.(*document); ok {
+			d.mu.RLock()
+			defer d.mu.RUnlock()
+		}
+	}
+	var attrs []Attr
+	if e.attributes == nil {
+		return attrs
+	}
+	for _, node := range e.attributes.nodes {
+		if a, ok := node.(Attr); ok && !IsNamespaceDeclaration(a) {
+			attrs = append(attrs, a)
+		}
+	}
+	return attrs
+}
+
+// GetAttributeNames returns the qualified names of e's attributes, including
+// namespace declarations, in attribute order.
+func (e *element) GetAttributeNames() []DOMString {
+	if doc := e.OwnerDocument(); doc != nil {
+		if d, ok := doc.(*document); ok {
+			d.mu.RLock()
+			defer d.mu.RUnlock()
+		}
+	}
+	names := []DOMString{}
+	if e.attributes == nil {
+		return names
+	}
+	for _, node := range e.attributes.nodes {
+		names = append(names, node.NodeName())
+	}
+	return names
+}
+
+// CanonicalAttributeString returns e's attributes as they appear in the
+// Canonical XML form of its start tag, separated by single spaces: namespace
+// declarations first, ordered by prefix with the default namespace leading,
+// then the other attributes ordered by namespace URI and local name. Values
+// use canonical escaping, so the result does not depend on insertion order
+// or on how the values were written in the source.
+func (e *element) CanonicalAttributeString() string {
+	bindings := inScopeNamespaces(e)
+	if d, ok := e.ownerDocument.(*document); ok {
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+	}
+	if e.attributes == nil {
+		return ""
+	}
+
+	type canonicalAttr struct {
+		ns, local, name, value string
+	}
+	var decls, attrs []canonicalAttr
+	for _, n := range e.attributes.nodes {
+		a, ok := n.(*attr)
+		if !ok {
+			continue
+		}
+		local := string(localNameOrName(a))
+		if IsNamespaceDeclaration(a) {
+			if a.nodeName == "xmlns" {
+				decls = append(decls, canonicalAttr{name: "xmlns", value: string(a.nodeValue)})
+			} else {
+				decls = append(decls, canonicalAttr{local: local, name: "xmlns:" + local, value: string(a.nodeValue)})
+			}
+			continue
+		}
+		name := string(a.nodeName)
+		if a.prefix == "" && a.namespaceURI != "" {
+			if prefix := prefixFor(bindings, string(a.namespaceURI)); prefix != "" {
+				name = prefix + ":" + local
+			}
+		}
+		attrs = append(attrs, canonicalAttr{ns: string(a.namespaceURI), local: local, name: name, value: string(a.nodeValue)})
+	}
+	sort.SliceStable(decls, func(i, j int) bool { return decls[i].local < decls[j].local })
+	sort.SliceStable(attrs, func(i, j int) bool {
+		if attrs[i].ns != attrs[j].ns {
+			return attrs[i].ns < attrs[j].ns
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	var b strings.Builder
+	for _, a := range append(decls, attrs...) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(a.name)
+		b.WriteString(`="`)
+		b.WriteString(canonicalAttrEscaper.Replace(a.value))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// NamespaceDeclarations returns the prefix to namespace URI bindings made
+// by e's own xmlns and xmlns:* attributes, with "" standing for the default
+// namespace. Bindings inherited from ancestors are not included.
+func (e *element) NamespaceDeclarations() map[DOMString]DOMString {
+	if d, ok := e.ownerDocument.(*document); ok {
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+	}
+	decls := make(map[DOMString]DOMString)
+	if e.attributes == nil {
+		return decls
+	}
+	for _, n := range e.attributes.nodes {
+		a, ok := n.(*attr)
+		if !ok || !IsNamespaceDeclaration(a) {
+			continue
+		}
+		if a.nodeName == "xmlns" {
+			decls[""] = a.nodeValue
+		} else {
+			decls[DOMString(strings.TrimPrefix(string(a.nodeName), "xmlns:"))] = a.nodeValue
+		}
+	}
+	return decls
+}
+
+// canonicalAttrEscaper escapes attribute values as Canonical XML does
+var canonicalAttrEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	`"`, "&quot;",
+	"\t", "&#x9;",
+	"\n", "&#xA;",
+	"\r", "&#xD;",
+)
+
+// prefixFor returns the alphabetically first non-empty prefix bound to uri
+// in bindings, or "" if there is none
+func prefixFor(bindings map[string]string, uri string) string {
+	if uri == xmlNamespaceURI {
+		return "xml"
+	}
+	best := ""
+	for prefix, bound := range bindings {
+		if prefix != "" && bound == uri && (best == "" || prefix < best) {
+			best = prefix
+		}
+	}
+	return best
+}
+
 // Element manipulation methods from Living Standard
 
 func (e *element) ToggleAttribute(name DOMString, force ...bool) bool {
@@ -4182,7 +5934,7 @@ func (e *element) ToggleAttribute(name DOMString, force ...bool) bool {
 		e.attributes.SetNamedItem(newAttr)
 
 		// Update ID index if this is an ID attribute
-		if doc := e.OwnerDocument(); doc != nil && name == "id" {
+		if doc := e.OwnerDocument(); doc != nil {
 			if d, ok := doc.(*document); ok {
 				d.updateIdMappingForElement(e, name, "", "")
 			}
@@ -4193,7 +5945,7 @@ func (e *element) ToggleAttribute(name DOMString, force ...bool) bool {
 		if e.attributes != nil {
 			if attr := e.attributes.GetNamedItem(name); attr != nil {
 				// Update ID index if this is an ID attribute
-				if doc := e.OwnerDocument(); doc != nil && name == "id" {
+				if doc := e.OwnerDocument(); doc != nil {
 					if d, ok := doc.(*document); ok {
 						d.updateIdMappingForElement(e, name, attr.NodeValue(), "")
 					}
@@ -4213,7 +5965,7 @@ func (e *element) Remove() {
 	}
 }
 
-func (e *element) ReplaceWith(nodes ...Node) error {
+func (e *element) ReplaceWith(nodes ...any) error {
 	parent := e.ParentNode()
 	if parent == nil {
 		return nil // No parent, nothing to do
@@ -4230,17 +5982,21 @@ func (e *element) ReplaceWith(nodes ...Node) error {
 		return NewDOMException("InvalidStateError", "Element has no owner document")
 	}
 
+	children, err := argNodes(doc, nodes)
+	if err != nil {
+		return err
+	}
 	frag := doc.CreateDocumentFragment()
-	for _, node := range nodes {
+	for _, node := range children {
 		frag.AppendChild(node)
 	}
 
 	// Replace this element with the fragment
-	_, err := parent.ReplaceChild(frag, e)
+	_, err = parent.ReplaceChild(frag, e)
 	return err
 }
 
-func (e *element) Before(nodes ...Node) error {
+func (e *element) Before(nodes ...any) error {
 	parent := e.ParentNode()
 	if parent == nil {
 		return nil // No parent, nothing to do
@@ -4256,94 +6012,660 @@ func (e *element) Before(nodes ...Node) error {
 		return NewDOMException("InvalidStateError", "Element has no owner document")
 	}
 
+	children, err := argNodes(doc, nodes)
+	if err != nil {
+		return err
+	}
 	frag := doc.CreateDocumentFragment()
-	for _, node := range nodes {
+	for _, node := range children {
 		frag.AppendChild(node)
 	}
 
 	// Insert the fragment before this element
-	_, err := parent.InsertBefore(frag, e)
+	_, err = parent.InsertBefore(frag, e)
 	return err
 }
 
-func (e *element) After(nodes ...Node) error {
+func (e *element) After(nodes ...any) error {
 	parent := e.ParentNode()
 	if parent == nil {
 		return nil // No parent, nothing to do
 	}
-
-	if len(nodes) == 0 {
-		return nil
+
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	// Create a document fragment to hold the nodes
+	doc := e.OwnerDocument()
+	if doc == nil {
+		return NewDOMException("InvalidStateError", "Element has no owner document")
+	}
+
+	children, err := argNodes(doc, nodes)
+	if err != nil {
+		return err
+	}
+	frag := doc.CreateDocumentFragment()
+	for _, node := range children {
+		frag.AppendChild(node)
+	}
+
+	// Insert the fragment after this element
+	nextSibling := e.NextSibling()
+	if nextSibling != nil {
+		_, err := parent.InsertBefore(frag, nextSibling)
+		return err
+	} else {
+		_, err := parent.AppendChild(frag)
+		return err
+	}
+}
+
+func (e *element) Prepend(nodes ...any) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	// Create a document fragment to hold the nodes
+	doc := e.OwnerDocument()
+	if doc == nil {
+		return NewDOMException("InvalidStateError", "Element has no owner document")
+	}
+
+	children, err := argNodes(doc, nodes)
+	if err != nil {
+		return err
+	}
+	frag := doc.CreateDocumentFragment()
+	for _, node := range children {
+		frag.AppendChild(node)
+	}
+
+	// Insert at the beginning
+	firstChild := e.FirstChild()
+	if firstChild != nil {
+		_, err := e.InsertBefore(frag, firstChild)
+		return err
+	} else {
+		_, err := e.AppendChild(frag)
+		return err
+	}
+}
+
+func (e *element) Append(nodes ...any) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	// Create a document fragment to hold the nodes
+	doc := e.OwnerDocument()
+	if doc == nil {
+		return NewDOMException("InvalidStateError", "Element has no owner document")
+	}
+
+	children, err := argNodes(doc, nodes)
+	if err != nil {
+		return err
+	}
+	frag := doc.CreateDocumentFragment()
+	for _, node := range children {
+		frag.AppendChild(node)
+	}
+
+	// Append to the end
+	_, err = e.AppendChild(frag)
+	return err
+}
+
+// ReplaceChildren replaces e's children with nodes, in order. A
+// DocumentFragment among them contributes its children. All nodes are
+// checked before anything changes, so an error leaves e as it was.
+func (e *element) ReplaceChildren(nodes ...any) error {
+	children, err := argNodes(e.OwnerDocument(), nodes)
+	if err != nil {
+		return err
+	}
+	return replaceChildren(e, children)
+}
+
+// InsertAdjacentElement inserts element relative to e according to where,
+// which is one of "beforebegin", "afterbegin", "beforeend" or "afterend"
+// (matched case-insensitively). The positions outside e require a parent:
+// for a parentless element they are a no-op and return nil without error.
+func (e *element) InsertAdjacentElement(where DOMString, element Element) (Element, error) {
+	if element == nil {
+		return nil, NewDOMException("HierarchyRequestError", "Invalid node")
+	}
+
+	var err error
+	switch strings.ToLower(string(where)) {
+	case "beforebegin":
+		parent := e.ParentNode()
+		if parent == nil {
+			return nil, nil
+		}
+		if parent.NodeType() == DOCUMENT_NODE {
+			return nil, NewDOMException("HierarchyRequestError", "Document can have only one element child")
+		}
+		_, err = parent.InsertBefore(element, e)
+	case "afterbegin":
+		_, err = e.InsertBefore(element, e.FirstChild())
+	case "beforeend":
+		_, err = e.AppendChild(element)
+	case "afterend":
+		parent := e.ParentNode()
+		if parent == nil {
+			return nil, nil
+		}
+		if parent.NodeType() == DOCUMENT_NODE {
+			return nil, NewDOMException("HierarchyRequestError", "Document can have only one element child")
+		}
+		_, err = parent.InsertBefore(element, e.NextSibling())
+	default:
+		return nil, NewDOMException("SyntaxError", "Invalid insertAdjacent position")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return element, nil
+}
+
+// WrapChildren moves all of e's children, in order, to the end of wrapper
+// and makes wrapper e's only child. wrapper is detached from its current
+// parent first. The move happens under a single document lock and live
+// lists are refreshed once.
+func (e *element) WrapChildren(wrapper Element) error {
+	if wrapper == nil {
+		return NewDOMException("HierarchyRequestError", "Invalid node")
+	}
+	w, ok := wrapper.(*element)
+	if !ok {
+		return NewDOMException("HierarchyRequestError", "Wrapper must be an element")
+	}
+	if w.ownerDocument != e.ownerDocument {
+		return NewDOMException("WrongDocumentError", "")
+	}
+
+	d, _ := e.ownerDocument.(*document)
+	if d != nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+	}
+
+	// The wrapper must not be e, an ancestor of e, or inside e's subtree
+	for ancestor := Node(e); ancestor != nil; ancestor = ancestor.ParentNode() {
+		if ancestor == Node(w) {
+			return NewDOMException("HierarchyRequestError", "Cannot wrap an element's children in its ancestor")
+		}
+	}
+	for ancestor := w.parentNode; ancestor != nil; ancestor = ancestor.ParentNode() {
+		if ancestor == Node(e) {
+			return NewDOMException("HierarchyRequestError", "Wrapper cannot be a descendant of the element")
+		}
+	}
+
+	oldParent := w.parentNode
+	if oldParent != nil {
+		if oldParent.NodeType() == DOCUMENT_NODE {
+			return NewDOMException("HierarchyRequestError", "Cannot move the document element")
+		}
+		parentImpl := getInternalNode(oldParent)
+		parentImpl.unlinkChild(w)
+		if parentImpl.childNodes != nil && parentImpl.childNodes.update != nil {
+			parentImpl.childNodes.dirty = true
+		}
+	}
+
+	// Splice e's children onto the end of the wrapper's child chain
+	if first := e.firstChild; first != nil {
+		for child := first; child != nil; child = child.NextSibling() {
+			getInternalNode(child).parentNode = w
+		}
+		if w.lastChild != nil {
+			getInternalNode(w.lastChild).nextSibling = first
+			getInternalNode(first).previousSibling = w.lastChild
+		} else {
+			w.firstChild = first
+		}
+		w.lastChild = e.lastChild
+	}
+
+	e.firstChild = w
+	e.lastChild = w
+	w.parentNode = e
+	w.previousSibling = nil
+	w.nextSibling = nil
+
+	if w.childNodes != nil && w.childNodes.update != nil {
+		w.childNodes.dirty = true
+	}
+	if e.childNodes != nil && e.childNodes.update != nil {
+		e.childNodes.dirty = true
+	}
+	if d != nil {
+		d.notifyMutation(e, oldParent)
+	}
+	return nil
+}
+
+// Unwrap replaces e with its children, in order, and leaves e detached
+// and empty. It is the inverse of WrapChildren. An element without a
+// parent is left unchanged; the document element cannot be unwrapped.
+func (e *element) Unwrap() error {
+	d, _ := e.ownerDocument.(*document)
+	if d != nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+	}
+
+	parent := e.parentNode
+	if parent == nil {
+		return nil
+	}
+	if parent.NodeType() == DOCUMENT_NODE {
+		return NewDOMException("HierarchyRequestError", "Cannot unwrap the document element")
+	}
+	parentImpl := getInternalNode(parent)
+
+	// Splice e's children into the parent's chain in place of e
+	prev, next := e.previousSibling, e.nextSibling
+	first, last := e.firstChild, e.lastChild
+	if first == nil {
+		parentImpl.unlinkChild(e)
+	} else {
+		for child := first; child != nil; child = child.NextSibling() {
+			getInternalNode(child).parentNode = parent
+		}
+		getInternalNode(first).previousSibling = prev
+		getInternalNode(last).nextSibling = next
+		if prev != nil {
+			getInternalNode(prev).nextSibling = first
+		} else {
+			parentImpl.firstChild = first
+		}
+		if next != nil {
+			getInternalNode(next).previousSibling = last
+		} else {
+			parentImpl.lastChild = last
+		}
+		e.firstChild = nil
+		e.lastChild = nil
+		e.parentNode = nil
+		e.previousSibling = nil
+		e.nextSibling = nil
+	}
+
+	if d != nil && e.attributes != nil {
+		if id := e.attributes.GetNamedItem(d.idAttribute()); id != nil {
+			d.releaseId(e, id.NodeValue())
+		}
+	}
+
+	if e.childNodes != nil && e.childNodes.update != nil {
+		e.childNodes.dirty = true
+	}
+	if parentImpl.childNodes != nil && parentImpl.childNodes.update != nil {
+		parentImpl.childNodes.dirty = true
+	}
+	if d != nil {
+		d.notifyMutation(parent)
+	}
+	return nil
+}
+
+// MergeWithPrevious moves e's children, in order, to the end of its
+// previous element sibling and removes e. The sibling must have the same
+// namespace and tag name and exactly the same attributes; otherwise
+// InvalidModificationError is returned. NotFoundError is returned when e
+// has no previous element sibling. Nodes between the two elements, such as
+// whitespace, are left in place.
+func (e *element) MergeWithPrevious() error {
+	d, _ := e.ownerDocument.(*document)
+	if d != nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+	}
+
+	var prev *element
+	for sibling := e.previousSibling; sibling != nil && prev == nil; sibling = sibling.PreviousSibling() {
+		prev, _ = sibling.(*element)
+	}
+	if prev == nil {
+		return NewDOMException("NotFoundError", "No previous element sibling to merge with")
+	}
+	if prev.namespaceURI != e.namespaceURI || prev.nodeName != e.nodeName || !sameAttributes(prev.attributes, e.attributes) {
+		return NewDOMException("InvalidModificationError", "Previous element sibling is not compatible")
+	}
+
+	parentImpl := getInternalNode(e.parentNode)
+	parentImpl.unlinkChild(e)
+
+	// Splice e's children onto the end of prev's child chain
+	if first := e.firstChild; first != nil {
+		for child := first; child != nil; child = child.NextSibling() {
+			getInternalNode(child).parentNode = prev
+		}
+		if prev.lastChild != nil {
+			getInternalNode(prev.lastChild).nextSibling = first
+			getInternalNode(first).previousSibling = prev.lastChild
+		} else {
+			prev.firstChild = first
+		}
+		prev.lastChild = e.lastChild
+		e.firstChild = nil
+		e.lastChild = nil
+	}
+
+	// prev carries the same id, if any, so it is left as its sole holder
+	if d != nil {
+		if id := e.attributes.GetNamedItem(d.idAttribute()); id != nil {
+			d.releaseId(e, id.NodeValue())
+		}
+	}
+
+	for _, list := range []*nodeList{e.childNodes, prev.childNodes, parentImpl.childNodes} {
+		if list != nil && list.update != nil {
+			list.dirty = true
+		}
+	}
+	if d != nil {
+		d.notifyMutation(prev.parentNode)
+	}
+	return nil
+}
+
+// SetOuterXML replaces e in its parent with the nodes parsed from markup.
+// The markup is read as element content in the parent's namespace context.
+// Malformed markup returns SyntaxError and leaves the tree unchanged. An
+// element without a parent, or the document element, cannot be replaced and
+// returns NoModificationAllowedError.
+func (e *element) SetOuterXML(markup DOMString) error {
+	parent := e.ParentNode()
+	if parent == nil {
+		return NewDOMException("NoModificationAllowedError", "Element has no parent")
+	}
+	if parent.NodeType() == DOCUMENT_NODE {
+		return NewDOMException("NoModificationAllowedError", "Cannot replace the document element")
+	}
+
+	doc := e.OwnerDocument()
+	nodes, err := parseFragment(doc, parent, markup)
+	if err != nil {
+		return err
+	}
+	frag := doc.CreateDocumentFragment()
+	for _, n := range nodes {
+		if _, err := frag.AppendChild(n); err != nil {
+			return err
+		}
+	}
+	if _, err := parent.InsertBefore(frag, e); err != nil {
+		return err
+	}
+	if _, err := parent.RemoveChild(e); err != nil {
+		return err
+	}
+	return nil
+}
+
+// NormalizedTextContent returns e's text content with leading and trailing
+// whitespace removed and every inner run of whitespace collapsed to a
+// single space, as the XPath normalize-space function does.
+func (e *element) NormalizedTextContent() DOMString {
+	return DOMString(strings.Join(strings.Fields(string(e.TextContent())), " "))
+}
+
+// HasMixedContent reports whether e has both element children and text or
+// CDATA children that are not just whitespace. Element-only content with
+// whitespace between the elements, and text-only content, are not mixed.
+func (e *element) HasMixedContent() bool {
+	if d, ok := e.ownerDocument.(*document); ok {
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+	}
+	hasElement, hasText := false, false
+	for child := e.firstChild; child != nil; child = getInternalNode(child).nextSibling {
+		switch child.NodeType() {
+		case ELEMENT_NODE:
+			hasElement = true
+		case TEXT_NODE, CDATA_SECTION_NODE:
+			if strings.Trim(string(getInternalNode(child).nodeValue), " \t\r\n") != "" {
+				hasText = true
+			}
+		}
+	}
+	return hasElement && hasText
+}
+
+// ContentPart is one part of an element's content as returned by
+// Element.ContentParts: either a child element or a run of character data.
+type ContentPart struct {
+	// Element is the child element, or nil for a run of character data
+	Element Element
+	// Text is the character data of the run, with the text of adjacent
+	// Text and CDATASection nodes and of entity references joined
+	Text DOMString
+}
+
+// ContentParts returns e's content in document order as a list of child
+// elements and runs of character data. Each run joins adjacent Text and
+// CDATASection nodes and the text of entity references between them.
+// Entity references are read as if expanded, so elements in their
+// replacement text are listed in place and end the run before them.
+// Comments and processing instructions are left out and do not end a run.
+func (e *element) ContentParts() []ContentPart {
+	if d, ok := e.ownerDocument.(*document); ok {
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+	}
+	var parts []ContentPart
+	var run strings.Builder
+	inRun := false
+	var collect func(first Node)
+	collect = func(first Node) {
+		for child := first; child != nil; child = getInternalNode(child).nextSibling {
+			switch child.NodeType() {
+			case ELEMENT_NODE:
+				if inRun {
+					parts = append(parts, ContentPart{Text: DOMString(run.String())})
+					run.Reset()
+					inRun = false
+				}
+				parts = append(parts, ContentPart{Element: child.(Element)})
+			case TEXT_NODE, CDATA_SECTION_NODE:
+				run.WriteString(string(getInternalNode(child).nodeValue))
+				inRun = true
+			case ENTITY_REFERENCE_NODE:
+				collect(getInternalNode(child).firstChild)
+			}
+		}
+	}
+	collect(e.firstChild)
+	if inRun {
+		parts = append(parts, ContentPart{Text: DOMString(run.String())})
+	}
+	return parts
+}
+
+// EffectiveAttribute returns the value of the attribute name with specified
+// true when it is set on e. Otherwise it returns the default declared with
+// Document.SetAttributeDefault for e's tag name, or "", with specified
+// false.
+func (e *element) EffectiveAttribute(name DOMString) (value DOMString, specified bool) {
+	d, _ := e.OwnerDocument().(*document)
+	if d != nil {
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+	}
+	if e.attributes != nil {
+		if attr := e.attributes.GetNamedItem(name); attr != nil {
+			return attr.NodeValue(), true
+		}
+	}
+	if d != nil {
+		value = d.attrDefaults[e.nodeName][name]
 	}
+	return value, false
+}
 
-	// Create a document fragment to hold the nodes
-	doc := e.OwnerDocument()
-	if doc == nil {
-		return NewDOMException("InvalidStateError", "Element has no owner document")
+// InnerXML serializes e's children, without e's own tags, as an Encoder
+// would write them inside e. Namespaces in scope at e are not declared
+// again, so the result is the markup SetOuterXML or a parser would read
+// back as e's content.
+func (e *element) InnerXML() (string, error) {
+	ctx := make(map[DOMString]DOMString)
+	for prefix, uri := range inScopeNamespaces(e) {
+		ctx[DOMString(prefix)] = DOMString(uri)
 	}
-
-	frag := doc.CreateDocumentFragment()
-	for _, node := range nodes {
-		frag.AppendChild(node)
+	if _, ok := ctx[e.prefix]; !ok {
+		ctx[e.prefix] = e.namespaceURI
 	}
 
-	// Insert the fragment after this element
-	nextSibling := e.NextSibling()
-	if nextSibling != nil {
-		_, err := parent.InsertBefore(frag, nextSibling)
-		return err
-	} else {
-		_, err := parent.AppendChild(frag)
-		return err
+	var buf strings.Builder
+	enc := NewEncoder(&buf)
+	enc.SetIndent("", "")
+	enc.SetNamespaceContext(ctx)
+	for child := e.firstChild; child != nil; child = child.NextSibling() {
+		if err := enc.Encode(child); err != nil {
+			return "", err
+		}
 	}
+	return buf.String(), nil
 }
 
-func (e *element) Prepend(nodes ...Node) error {
-	if len(nodes) == 0 {
-		return nil
+// OuterXML serializes e with its own tags, attributes and subtree, without
+// an XML declaration. Every namespace used in it is declared within it, so
+// UnmarshalDOM reads the result back as an equivalent element.
+func (e *element) OuterXML() (string, error) {
+	var buf strings.Builder
+	enc := NewEncoder(&buf)
+	enc.SetIndent("", "")
+	if err := enc.Encode(e); err != nil {
+		return "", err
 	}
+	return buf.String(), nil
+}
 
-	// Create a document fragment to hold the nodes
+// SetInnerXML replaces e's children with the nodes parsed from markup,
+// which is read as element content in e's namespace context. The ids of
+// the removed elements are dropped from the document's index and those of
+// the new ones added. Malformed markup returns SyntaxError and leaves e
+// unchanged.
+func (e *element) SetInnerXML(markup DOMString) error {
 	doc := e.OwnerDocument()
-	if doc == nil {
-		return NewDOMException("InvalidStateError", "Element has no owner document")
+	nodes, err := parseFragment(doc, e, markup)
+	if err != nil {
+		return err
 	}
 
-	frag := doc.CreateDocumentFragment()
-	for _, node := range nodes {
-		frag.AppendChild(node)
+	d, _ := doc.(*document)
+	for child := e.FirstChild(); child != nil; child = e.FirstChild() {
+		if _, err := e.RemoveChild(child); err != nil {
+			return err
+		}
+		if d != nil {
+			d.mu.Lock()
+			d.unindexIds(child)
+			d.mu.Unlock()
+		}
 	}
-
-	// Insert at the beginning
-	firstChild := e.FirstChild()
-	if firstChild != nil {
-		_, err := e.InsertBefore(frag, firstChild)
-		return err
-	} else {
-		_, err := e.AppendChild(frag)
-		return err
+	for _, n := range nodes {
+		if _, err := e.AppendChild(n); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (e *element) Append(nodes ...Node) error {
-	if len(nodes) == 0 {
+// ChangeNamespace moves e and every element and attribute below it that is
+// in oldNS to newNS, keeping prefixes and local names. Namespace
+// declarations binding oldNS are changed to bind newNS. The reserved XML
+// and XMLNS namespaces cannot be changed or moved into, and nodes with a
+// prefix cannot be moved to no namespace; these return NamespaceError
+// before anything is changed. Unprefixed attributes are in no namespace
+// whatever their element's namespace, so moving elements out of no
+// namespace leaves them there.
+func (e *element) ChangeNamespace(oldNS, newNS DOMString) error {
+	for _, uri := range []DOMString{oldNS, newNS} {
+		if uri == xmlNamespaceURI || uri == xmlnsNamespaceURI {
+			return NewDOMException("NamespaceError", "Cannot change a reserved namespace")
+		}
+	}
+	if oldNS == newNS {
 		return nil
 	}
 
-	// Create a document fragment to hold the nodes
-	doc := e.OwnerDocument()
-	if doc == nil {
-		return NewDOMException("InvalidStateError", "Element has no owner document")
+	d, _ := e.ownerDocument.(*document)
+	if d != nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
 	}
 
-	frag := doc.CreateDocumentFragment()
-	for _, node := range nodes {
-		frag.AppendChild(node)
+	var elems []*element
+	var attrs []*attr
+	var decls []*attr
+	var err error
+	collect := func(el *element) {
+		if el.namespaceURI == oldNS {
+			elems = append(elems, el)
+			if newNS == "" && el.prefix != "" {
+				err = NewDOMException("NamespaceError", "A prefixed element must have a namespace")
+			}
+		}
+		if el.attributes == nil {
+			return
+		}
+		for _, n := range el.attributes.nodes {
+			a, ok := n.(*attr)
+			switch {
+			case !ok:
+			case IsNamespaceDeclaration(a):
+				if a.nodeValue == oldNS {
+					decls = append(decls, a)
+				}
+			case a.namespaceURI == oldNS && oldNS != "":
+				attrs = append(attrs, a)
+				if newNS == "" && a.prefix != "" {
+					err = NewDOMException("NamespaceError", "A prefixed attribute must have a namespace")
+				}
+			}
+		}
+	}
+	collect(e)
+	collectElements(e, collect)
+	if err != nil {
+		return err
 	}
 
-	// Append to the end
-	_, err := e.AppendChild(frag)
-	return err
+	for _, el := range elems {
+		el.namespaceURI = newNS
+	}
+	for _, a := range attrs {
+		a.namespaceURI = newNS
+	}
+	for _, a := range decls {
+		a.nodeValue = newNS
+	}
+	if d != nil {
+		d.notifyMutation(e)
+	}
+	return nil
+}
+
+// sameAttributes reports whether a and b hold the same attributes with the
+// same values, in any order
+func sameAttributes(a, b *namedNodeMap) bool {
+	if a.Length() != b.Length() {
+		return false
+	}
+	for _, attr := range a.nodes {
+		i := b.indexOfNS(attr.NamespaceURI(), attr.LocalName())
+		if i < 0 || b.nodes[i].NodeValue() != attr.NodeValue() {
+			return false
+		}
+	}
+	return true
 }
 
 // Element DOM properties from Living Standard
@@ -4391,6 +6713,48 @@ func (e *element) Children() ElementList {
 	return el
 }
 
+// ChildrenByNamespace returns a live collection of the direct child elements
+// whose namespace URI equals namespaceURI. The wildcard "*" matches any namespace.
+func (e *element) ChildrenByNamespace(namespaceURI DOMString) ElementList {
+	doc, ok := e.ownerDocument.(*document)
+	if !ok {
+		// Should not happen in a well-formed document
+		return &elementList{items: []Element{}}
+	}
+	if doc != nil {
+		doc.mu.RLock()
+		defer doc.mu.RUnlock()
+	}
+
+	el := &elementList{
+		root: e,
+		filter: func(n Node) bool {
+			return n.NodeType() == ELEMENT_NODE &&
+				(namespaceURI == "*" || n.NamespaceURI() == namespaceURI)
+		},
+		converter: func(n Node) (Element, bool) {
+			elem, ok := n.(Element)
+			return elem, ok
+		},
+		live: true,
+		doc:  doc,
+	}
+	el.update = func() {
+		items := []Element{}
+		for child := e.firstChild; child != nil; child = child.NextSibling() {
+			if el.filter(child) {
+				if elem, ok := el.converter(child); ok {
+					items = append(items, elem)
+				}
+			}
+		}
+		el.items = items
+	}
+	el.dirty = true // populated on first read
+	doc.activeElemLists = append(doc.activeElemLists, el)
+	return el
+}
+
 func (e *element) FirstElementChild() Element {
 	child := e.FirstChild()
 	for child != nil {
@@ -4417,32 +6781,6 @@ func (e *element) LastElementChild() Element {
 	return nil
 }
 
-func (e *element) PreviousElementSibling() Element {
-	sibling := e.PreviousSibling()
-	for sibling != nil {
-		if sibling.NodeType() == ELEMENT_NODE {
-			if elem, ok := sibling.(Element); ok {
-				return elem
-			}
-		}
-		sibling = sibling.PreviousSibling()
-	}
-	return nil
-}
-
-func (e *element) NextElementSibling() Element {
-	sibling := e.NextSibling()
-	for sibling != nil {
-		if sibling.NodeType() == ELEMENT_NODE {
-			if elem, ok := sibling.(Element); ok {
-				return elem
-			}
-		}
-		sibling = sibling.NextSibling()
-	}
-	return nil
-}
-
 func (e *element) ChildElementCount() uint32 {
 	count := uint32(0)
 	child := e.FirstChild()
@@ -4513,6 +6851,30 @@ func (df *documentFragment) Position() (line, column int, offset int64) {
 type attr struct {
 	node
 	ownerElement Element
+
+	// rawValue is the value as written in the source document. It is only
+	// used while the attribute still holds rawValueFor, the value it
+	// decoded to.
+	rawValue    DOMString
+	rawValueFor DOMString
+}
+
+// setAttrRawValue records raw as the source text of a's current value
+func setAttrRawValue(a Attr, raw DOMString) {
+	if impl, ok := a.(*attr); ok {
+		impl.rawValue = raw
+		impl.rawValueFor = impl.nodeValue
+	}
+}
+
+// attrSerializedValue returns the value of a escaped for output between
+// double quotes, reusing the source text when it is still current
+func attrSerializedValue(a Attr) string {
+	if impl, ok := a.(*attr); ok && impl.rawValue != "" && impl.rawValueFor == impl.nodeValue &&
+		!strings.ContainsAny(string(impl.rawValue), `"<`) {
+		return string(impl.rawValue)
+	}
+	return EscapeAttrValue(string(a.Value()))
 }
 
 func (a *attr) Name() DOMString {
@@ -4533,6 +6895,16 @@ func (a *attr) SetValue(value DOMString) {
 	}
 }
 
+// CloneNode returns a copy of a that belongs to no element, keeping the
+// source text of its value
+func (a *attr) CloneNode(deep bool) Node {
+	return &attr{
+		node:        a.cloneBase(),
+		rawValue:    a.rawValue,
+		rawValueFor: a.rawValueFor,
+	}
+}
+
 func (a *attr) OwnerElement() Element {
 	if a.ownerElement != nil {
 		return a.ownerElement
@@ -4540,6 +6912,14 @@ func (a *attr) OwnerElement() Element {
 	return nil
 }
 
+// Specified reports whether the attribute was given a value rather than
+// defaulted from the document type. Defaults are never added as attribute
+// nodes; Element.EffectiveAttribute falls back to them instead. So every
+// Attr, attached or not, is specified.
+func (a *attr) Specified() bool {
+	return true
+}
+
 // ===========================================================================
 // CharacterData Implementation
 // ===========================================================================
@@ -4554,6 +6934,9 @@ func (cd *characterData) Data() DOMString {
 }
 
 func (cd *characterData) SetData(data DOMString) error {
+	if cd.readOnly {
+		return errReadOnly()
+	}
 	if doc := cd.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
 			d.mu.Lock()
@@ -4587,6 +6970,9 @@ func (cd *characterData) SubstringData(offset, count uint) (DOMString, error) {
 }
 
 func (cd *characterData) AppendData(arg DOMString) error {
+	if cd.readOnly {
+		return errReadOnly()
+	}
 	if doc := cd.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
 			d.mu.Lock()
@@ -4598,6 +6984,9 @@ func (cd *characterData) AppendData(arg DOMString) error {
 }
 
 func (cd *characterData) InsertData(offset uint, arg DOMString) error {
+	if cd.readOnly {
+		return errReadOnly()
+	}
 	if doc := cd.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
 			d.mu.Lock()
@@ -4613,6 +7002,9 @@ func (cd *characterData) InsertData(offset uint, arg DOMString) error {
 }
 
 func (cd *characterData) DeleteData(offset, count uint) error {
+	if cd.readOnly {
+		return errReadOnly()
+	}
 	if doc := cd.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
 			d.mu.Lock()
@@ -4640,7 +7032,7 @@ func (cd *characterData) ReplaceData(offset, count uint, arg DOMString) error {
 
 // CharacterData manipulation methods from Living Standard
 
-func (cd *characterData) Before(nodes ...Node) error {
+func (cd *characterData) Before(nodes ...any) error {
 	parent := cd.ParentNode()
 	if parent == nil {
 		return nil // No parent, nothing to do
@@ -4650,8 +7042,13 @@ func (cd *characterData) Before(nodes ...Node) error {
 		return nil
 	}
 
+	children, err := argNodes(cd.OwnerDocument(), nodes)
+	if err != nil {
+		return err
+	}
+
 	// Insert each node directly before this node
-	for _, node := range nodes {
+	for _, node := range children {
 		_, err := parent.InsertBefore(node, cd)
 		if err != nil {
 			return err
@@ -4661,7 +7058,7 @@ func (cd *characterData) Before(nodes ...Node) error {
 	return nil
 }
 
-func (cd *characterData) After(nodes ...Node) error {
+func (cd *characterData) After(nodes ...any) error {
 	parent := cd.ParentNode()
 	if parent == nil {
 		return nil // No parent, nothing to do
@@ -4677,8 +7074,12 @@ func (cd *characterData) After(nodes ...Node) error {
 		return NewDOMException("InvalidStateError", "CharacterData has no owner document")
 	}
 
+	children, err := argNodes(doc, nodes)
+	if err != nil {
+		return err
+	}
 	frag := doc.CreateDocumentFragment()
-	for _, node := range nodes {
+	for _, node := range children {
 		frag.AppendChild(node)
 	}
 
@@ -4693,7 +7094,7 @@ func (cd *characterData) After(nodes ...Node) error {
 	}
 }
 
-func (cd *characterData) ReplaceWith(nodes ...Node) error {
+func (cd *characterData) ReplaceWith(nodes ...any) error {
 	parent := cd.ParentNode()
 	if parent == nil {
 		return nil // No parent, nothing to do
@@ -4710,13 +7111,17 @@ func (cd *characterData) ReplaceWith(nodes ...Node) error {
 		return NewDOMException("InvalidStateError", "CharacterData has no owner document")
 	}
 
+	children, err := argNodes(doc, nodes)
+	if err != nil {
+		return err
+	}
 	frag := doc.CreateDocumentFragment()
-	for _, node := range nodes {
+	for _, node := range children {
 		frag.AppendChild(node)
 	}
 
 	// Replace this node with the fragment
-	_, err := parent.ReplaceChild(frag, cd)
+	_, err = parent.ReplaceChild(frag, cd)
 	return err
 }
 
@@ -4739,7 +7144,93 @@ type text struct {
 	characterData
 }
 
+func (t *text) TextNodes(includeCDATA bool) []Text {
+	return []Text{t}
+}
+
+func (t *text) CloneNode(deep bool) Node {
+	return &text{characterData{t.cloneBase()}}
+}
+
+// WholeText returns the data of t joined with that of the Text and
+// CDATASection siblings adjacent to it on either side, in document order.
+// The tree is not changed.
+func (t *text) WholeText() DOMString {
+	if d, ok := t.ownerDocument.(*document); ok {
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+	}
+	isText := func(n Node) bool {
+		return n != nil && (n.NodeType() == TEXT_NODE || n.NodeType() == CDATA_SECTION_NODE)
+	}
+	var first Node = t
+	for prev := t.previousSibling; isText(prev); prev = getInternalNode(prev).previousSibling {
+		first = prev
+	}
+	var b strings.Builder
+	for n := first; isText(n); n = getInternalNode(n).nextSibling {
+		b.WriteString(string(getInternalNode(n).nodeValue))
+	}
+	return DOMString(b.String())
+}
+
+// ReplaceWholeText replaces t and the Text and CDATASection siblings
+// adjacent to it, the nodes WholeText reads, by t alone holding content,
+// and returns t. An empty content removes them all and returns nil.
+// NoModificationAllowedError is returned, and nothing is changed, if any of
+// those nodes is read-only.
+func (t *text) ReplaceWholeText(content DOMString) (Text, error) {
+	return t.replaceWholeText(t, content)
+}
+
+// replaceWholeText implements ReplaceWholeText for self, the node that
+// embeds t
+func (t *text) replaceWholeText(self Text, content DOMString) (Text, error) {
+	d, _ := t.ownerDocument.(*document)
+	if d != nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+	}
+	isText := func(n Node) bool {
+		return n != nil && (n.NodeType() == TEXT_NODE || n.NodeType() == CDATA_SECTION_NODE)
+	}
+	var first Node = self
+	for prev := t.previousSibling; isText(prev); prev = getInternalNode(prev).previousSibling {
+		first = prev
+	}
+	var run []Node
+	for n := first; isText(n); n = getInternalNode(n).nextSibling {
+		if getInternalNode(n).readOnly {
+			return nil, errReadOnly()
+		}
+		run = append(run, n)
+	}
+
+	parent := getInternalNode(t.parentNode)
+	for _, n := range run {
+		if parent != nil && (content == "" || !isSameNode(n, self)) {
+			parent.unlinkChild(n)
+		}
+	}
+	if parent != nil && parent.childNodes != nil && parent.childNodes.update != nil {
+		parent.childNodes.dirty = true
+	}
+	if content != "" {
+		t.nodeValue = content
+	}
+	if d != nil {
+		d.notifyMutation(t.parentNode)
+	}
+	if content == "" {
+		return nil, nil
+	}
+	return self, nil
+}
+
 func (t *text) SplitText(offset uint) (Text, error) {
+	if t.readOnly {
+		return nil, errReadOnly()
+	}
 	if doc := t.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
 			d.mu.Lock()
@@ -4778,7 +7269,7 @@ func (t *text) SplitText(offset uint) (Text, error) {
 			}
 			// Update parent's live NodeList if it exists
 			if p.childNodes != nil && p.childNodes.update != nil {
-				p.childNodes.update()
+				p.childNodes.dirty = true
 			}
 		}
 	}
@@ -4795,11 +7286,30 @@ type comment struct {
 	characterData
 }
 
+func (c *comment) CloneNode(deep bool) Node {
+	return &comment{characterData{c.cloneBase()}}
+}
+
 // cdataSection represents a CDATA section
 type cdataSection struct {
 	text
 }
 
+func (cd *cdataSection) CloneNode(deep bool) Node {
+	return &cdataSection{text{characterData{cd.cloneBase()}}}
+}
+
+func (cd *cdataSection) ReplaceWholeText(content DOMString) (Text, error) {
+	return cd.text.replaceWholeText(cd, content)
+}
+
+func (cd *cdataSection) TextNodes(includeCDATA bool) []Text {
+	if !includeCDATA {
+		return nil
+	}
+	return []Text{cd}
+}
+
 // documentType represents a document type node
 type documentType struct {
 	node
@@ -4811,6 +7321,57 @@ type documentType struct {
 	internalSubset DOMString
 }
 
+func (dt *documentType) CloneNode(deep bool) Node {
+	return dt.cloneFor(dt.ownerDocument)
+}
+
+// cloneFor copies dt, with its entities and notations, for use in owner
+func (dt *documentType) cloneFor(owner Document) *documentType {
+	clone := &documentType{
+		node:           dt.cloneBase(),
+		name:           dt.name,
+		entities:       NewNamedNodeMap(),
+		notations:      NewNamedNodeMap(),
+		publicId:       dt.publicId,
+		systemId:       dt.systemId,
+		internalSubset: dt.internalSubset,
+	}
+	clone.ownerDocument = owner
+	if dt.entities != nil {
+		for _, n := range dt.entities.nodes {
+			ent, ok := n.(*entity)
+			if !ok {
+				continue
+			}
+			copied := &entity{
+				node:         ent.cloneBase(),
+				publicId:     ent.publicId,
+				systemId:     ent.systemId,
+				notationName: ent.notationName,
+			}
+			for child := ent.firstChild; child != nil; child = child.NextSibling() {
+				copied.linkLastChild(copied, child.CloneNode(true))
+			}
+			setOwnerDocument(copied, owner)
+			markReadOnly(copied)
+			clone.entities.nodes = append(clone.entities.nodes, copied)
+		}
+	}
+	if dt.notations != nil {
+		for _, n := range dt.notations.nodes {
+			nt, ok := n.(*notation)
+			if !ok {
+				continue
+			}
+			copied := &notation{node: nt.cloneBase(), publicId: nt.publicId, systemId: nt.systemId}
+			copied.ownerDocument = owner
+			copied.readOnly = true
+			clone.notations.nodes = append(clone.notations.nodes, copied)
+		}
+	}
+	return clone
+}
+
 func (dt *documentType) Name() DOMString {
 	return dt.name
 }
@@ -4870,11 +7431,45 @@ func (e *entity) NotationName() DOMString {
 	return e.notationName
 }
 
-// entityReference represents an entity reference node
+// entityReference represents an entity reference node. Its children are
+// the read-only expansion of the referenced entity and cannot be changed.
 type entityReference struct {
 	node
 }
 
+// CloneNode copies the reference; a deep copy includes its read-only
+// replacement text
+func (er *entityReference) CloneNode(deep bool) Node {
+	clone := &entityReference{node: er.cloneBase()}
+	if deep {
+		for child := er.firstChild; child != nil; child = child.NextSibling() {
+			copied := child.CloneNode(true)
+			markReadOnly(copied)
+			clone.linkLastChild(clone, copied)
+		}
+	}
+	return clone
+}
+
+func (er *entityReference) InsertBefore(newChild Node, refChild Node) (Node, error) {
+	return nil, errReadOnly()
+}
+
+func (er *entityReference) AppendChild(newChild Node) (Node, error) {
+	return nil, errReadOnly()
+}
+
+func (er *entityReference) RemoveChild(oldChild Node) (Node, error) {
+	return nil, errReadOnly()
+}
+
+func (er *entityReference) ReplaceChild(newChild Node, oldChild Node) (Node, error) {
+	return nil, errReadOnly()
+}
+
+// SetTextContent does nothing: the expansion is read-only
+func (er *entityReference) SetTextContent(value DOMString) {}
+
 // processingInstruction represents a processing instruction node
 type processingInstruction struct {
 	node
@@ -4890,6 +7485,14 @@ func (pi *processingInstruction) Data() DOMString {
 	return pi.data
 }
 
+func (pi *processingInstruction) CloneNode(deep bool) Node {
+	return &processingInstruction{
+		node:   pi.cloneBase(),
+		target: pi.target,
+		data:   pi.data,
+	}
+}
+
 func (pi *processingInstruction) SetData(data DOMString) error {
 	if doc := pi.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
@@ -5011,8 +7614,8 @@ func (pi *processingInstruction) ReplaceChild(newChild Node, oldChild Node) (Nod
 // replaceChildInternal handles the actual replacement without DocumentFragment expansion
 func (pi *processingInstruction) replaceChildInternal(newChild Node, oldChild Node) (Node, error) {
 	// Remove from current parent if exists - done internally to avoid deadlock
-	if newChild.ParentNode() != nil {
-		oldParent := newChild.ParentNode()
+	oldParent := newChild.ParentNode()
+	if oldParent != nil {
 		oc := getInternalNode(newChild)
 
 		// Update sibling links
@@ -5037,7 +7640,7 @@ func (pi *processingInstruction) replaceChildInternal(newChild Node, oldChild No
 			}
 			// Update old parent's live NodeList if it exists
 			if op.childNodes != nil && op.childNodes.update != nil {
-				op.childNodes.update()
+				op.childNodes.dirty = true
 			}
 		}
 
@@ -5076,11 +7679,11 @@ func (pi *processingInstruction) replaceChildInternal(newChild Node, oldChild No
 
 	// Update live NodeList if it exists
 	if pi.childNodes != nil && pi.childNodes.update != nil {
-		pi.childNodes.update()
+		pi.childNodes.dirty = true
 	}
 	if doc := pi.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
-			d.notifyMutation()
+			d.notifyMutation(pi, oldParent)
 		}
 	}
 	return oldChild, nil
@@ -5122,11 +7725,11 @@ func (pi *processingInstruction) RemoveChild(oldChild Node) (Node, error) {
 
 	// Update live NodeList if it exists
 	if pi.childNodes != nil && pi.childNodes.update != nil {
-		pi.childNodes.update()
+		pi.childNodes.dirty = true
 	}
 	if doc := pi.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
-			d.notifyMutation()
+			d.notifyMutation(pi)
 		}
 	}
 	return oldChild, nil
@@ -5137,6 +7740,14 @@ type documentFragment struct {
 	node
 }
 
+func (df *documentFragment) CloneNode(deep bool) Node {
+	clone := &documentFragment{node: df.cloneBase()}
+	if deep {
+		df.cloneChildren(clone)
+	}
+	return clone
+}
+
 func (df *documentFragment) InsertBefore(newChild Node, refChild Node) (Node, error) {
 	if doc := df.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
@@ -5257,8 +7868,8 @@ func (df *documentFragment) ReplaceChild(newChild Node, oldChild Node) (Node, er
 // replaceChildInternal handles the actual replacement without DocumentFragment expansion
 func (df *documentFragment) replaceChildInternal(newChild Node, oldChild Node) (Node, error) {
 	// Remove from current parent if exists - done internally to avoid deadlock
-	if newChild.ParentNode() != nil {
-		oldParent := newChild.ParentNode()
+	oldParent := newChild.ParentNode()
+	if oldParent != nil {
 		oc := getInternalNode(newChild)
 
 		// Update sibling links
@@ -5283,7 +7894,7 @@ func (df *documentFragment) replaceChildInternal(newChild Node, oldChild Node) (
 			}
 			// Update old parent's live NodeList if it exists
 			if op.childNodes != nil && op.childNodes.update != nil {
-				op.childNodes.update()
+				op.childNodes.dirty = true
 			}
 		}
 
@@ -5322,11 +7933,11 @@ func (df *documentFragment) replaceChildInternal(newChild Node, oldChild Node) (
 
 	// Update live NodeList if it exists
 	if df.childNodes != nil && df.childNodes.update != nil {
-		df.childNodes.update()
+		df.childNodes.dirty = true
 	}
 	if doc := df.OwnerDocument(); doc != nil {
 		if d, ok := doc.(*document); ok {
-			d.notifyMutation()
+			d.notifyMutation(df, oldParent)
 		}
 	}
 	return oldChild, nil
@@ -5363,7 +7974,7 @@ func (df *documentFragment) removeChildInternal(oldChild Node) (Node, error) {
 
 	// Update live NodeList if it exists
 	if df.childNodes != nil && df.childNodes.update != nil {
-		df.childNodes.update()
+		df.childNodes.dirty = true
 	}
 	return oldChild, nil
 }
@@ -5423,6 +8034,9 @@ func (di *domImplementation) CreateDocument(namespaceURI, qualifiedName DOMStrin
 	}
 
 	if qualifiedName != "" {
+		if err := validateQualifiedNameNS(namespaceURI, qualifiedName); err != nil {
+			return nil, err
+		}
 		elem, err := doc.CreateElementNS(namespaceURI, qualifiedName)
 		if err != nil {
 			return nil, err
@@ -5495,6 +8109,22 @@ func isSameNode(a, b Node) bool {
 	return a == b
 }
 
+// IsNamespaceDeclaration reports whether attr is a namespace declaration,
+// i.e. an xmlns or xmlns:prefix attribute. Declarations read by the Decoder
+// carry the namespace URI "xmlns"; those created through the API may use the
+// XMLNS namespace URI or simply an xmlns-prefixed name.
+func IsNamespaceDeclaration(attr Attr) bool {
+	if attr == nil {
+		return false
+	}
+	switch attr.NamespaceURI() {
+	case "xmlns", xmlnsNamespaceURI:
+		return true
+	}
+	name := attr.NodeName()
+	return name == "xmlns" || strings.HasPrefix(string(name), "xmlns:")
+}
+
 // IsValidName checks if a string is a valid XML Name.
 // See https://www.w3.org/TR/xml/#NT-Name
 func IsValidName(name DOMString) bool {
@@ -5544,6 +8174,29 @@ func isNameChar(r rune) bool {
 		(r >= 0x203F && r <= 0x2040)
 }
 
+// validateQualifiedNameNS checks that qualifiedName is a well-formed QName
+// and that its prefix is consistent with namespaceURI: a prefix needs a
+// namespace, and the xml prefix is reserved for the XML namespace.
+func validateQualifiedNameNS(namespaceURI, qualifiedName DOMString) error {
+	name := string(qualifiedName)
+	if strings.Count(name, ":") > 1 || strings.HasPrefix(name, ":") || strings.HasSuffix(name, ":") {
+		return NewDOMException("InvalidCharacterError", "Malformed qualified name")
+	}
+	// Both parts must be NCNames, so the local name cannot start with a
+	// character that is only allowed later in a name
+	prefix, localName := parseQualifiedName(qualifiedName)
+	if !IsValidName(localName) {
+		return NewDOMException("InvalidCharacterError", "Invalid local name in qualified name")
+	}
+	if prefix != "" && namespaceURI == "" {
+		return NewDOMException("NamespaceError", "A prefix requires a namespace URI")
+	}
+	if prefix == "xml" && namespaceURI != xmlNamespaceURI {
+		return NewDOMException("NamespaceError", "The xml prefix is reserved")
+	}
+	return nil
+}
+
 // parseQualifiedName parses a qualified name into prefix and local name
 func parseQualifiedName(qualifiedName DOMString) (prefix, localName DOMString) {
 	parts := strings.SplitN(string(qualifiedName), ":", 2)
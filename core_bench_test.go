@@ -535,3 +535,60 @@ func BenchmarkGetRootNode(b *testing.B) {
 		_ = deepest.GetRootNode()
 	}
 }
+
+// BenchmarkNormalizeDeep benchmarks normalization of a deeply nested tree
+func BenchmarkNormalizeDeep(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		doc := createDeepDOM(b, 1000)
+		for n := doc.DocumentElement(); n != nil; n = n.FirstElementChild() {
+			n.Prepend(doc.CreateTextNode("a"), doc.CreateTextNode("b"))
+		}
+		b.StartTimer()
+
+		doc.NormalizeDocument()
+	}
+}
+
+// BenchmarkAppendWithLiveLists appends 10k children to a document that has
+// live lists open, which every mutation has to keep current.
+func BenchmarkAppendWithLiveLists(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		doc := createTestDocument()
+		root := doc.DocumentElement()
+		for _, name := range []xmldom.DOMString{"child", "other", "*"} {
+			doc.GetElementsByTagName(name)
+		}
+		for j := 0; j < 10000; j++ {
+			elem, _ := doc.CreateElement("child")
+			root.AppendChild(elem)
+		}
+	}
+}
+
+// BenchmarkLiveListMutationOutsideRoot reads a live list over one section
+// of a 50k-element document after each append to another section, which
+// leaves the list clean.
+func BenchmarkLiveListMutationOutsideRoot(b *testing.B) {
+	doc := createTestDocument()
+	root := doc.DocumentElement()
+	read, _ := doc.CreateElement("read")
+	write, _ := doc.CreateElement("write")
+	root.AppendChild(read)
+	root.AppendChild(write)
+	for i := 0; i < 50000; i++ {
+		elem, _ := doc.CreateElement("child")
+		read.AppendChild(elem)
+	}
+	list := read.GetElementsByTagName("child")
+	list.Length()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		elem, _ := doc.CreateElement("child")
+		write.AppendChild(elem)
+		if list.Length() != 50000 {
+			b.Fatalf("Length() = %d, want 50000", list.Length())
+		}
+	}
+}
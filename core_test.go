@@ -3,6 +3,7 @@ package xmldom_test
 import (
 	"bytes"
 	"encoding/xml"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -2314,6 +2315,104 @@ func TestRoundTripDOM(t *testing.T) {
 	}
 }
 
+// TestRoundTripNamespaces checks that Marshal declares the namespaces of
+// nodes created with CreateElementNS and SetAttributeNS
+func TestRoundTripNamespaces(t *testing.T) {
+	doc, err := xmldom.NewDOMImplementation().CreateDocument("urn:root", "r:root", nil)
+	if err != nil {
+		t.Fatalf("CreateDocument() failed: %v", err)
+	}
+	root := doc.DocumentElement()
+	child, _ := doc.CreateElementNS("urn:child", "c:child")
+	child.SetAttributeNS("urn:attr", "a:key", "1")
+	root.AppendChild(child)
+	plain, _ := doc.CreateElementNS("urn:root", "r:plain")
+	child.AppendChild(plain)
+
+	data, err := xmldom.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	want := `<?xml version="1.0"?><r:root xmlns:r="urn:root"><c:child xmlns:c="urn:child" xmlns:a="urn:attr" a:key="1"><r:plain></r:plain></c:child></r:root>`
+	if string(data) != want {
+		t.Errorf("Marshal() = %s, want %s", data, want)
+	}
+
+	back, err := xmldom.UnmarshalDOM(data)
+	if err != nil {
+		t.Fatalf("UnmarshalDOM() failed: %v", err)
+	}
+	backRoot := back.DocumentElement()
+	backChild := backRoot.FirstChild().(xmldom.Element)
+	if backRoot.NamespaceURI() != "urn:root" || backChild.NamespaceURI() != "urn:child" ||
+		backChild.FirstChild().NamespaceURI() != "urn:root" {
+		t.Errorf("element namespaces were lost in %s", data)
+	}
+	if backChild.GetAttributeNS("urn:attr", "key") != "1" {
+		t.Errorf("attribute namespace was lost in %s", data)
+	}
+}
+
+func TestMarshalIndent(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(`<root><list><item>one</item><item>two</item></list><empty/></root>`))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM() failed: %v", err)
+	}
+	data, err := xmldom.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		t.Fatalf("MarshalIndent() failed: %v", err)
+	}
+	want := `<?xml version="1.0"?>
+<root>
+  <list>
+    <item>one</item>
+    <item>two</item>
+  </list>
+  <empty></empty>
+</root>`
+	if string(data) != want {
+		t.Errorf("MarshalIndent(doc) =\n%s\nwant\n%s", data, want)
+	}
+
+	data, err = xmldom.MarshalIndent(doc.DocumentElement().FirstChild(), "> ", "\t")
+	if err != nil {
+		t.Fatalf("MarshalIndent() failed: %v", err)
+	}
+	want = "> <list>\n> \t<item>one</item>\n> \t<item>two</item>\n> </list>"
+	if string(data) != want {
+		t.Errorf("MarshalIndent(element) = %q, want %q", data, want)
+	}
+
+	// Without indentation the output is Marshal's
+	typed, err := xmldom.UnmarshalDOM([]byte(`<!DOCTYPE root><root><a x="&quot;"/></root>`))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM() failed: %v", err)
+	}
+	compact, err := xmldom.Marshal(typed)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	data, err = xmldom.MarshalIndent(typed, "", "")
+	if err != nil {
+		t.Fatalf("MarshalIndent() failed: %v", err)
+	}
+	if string(data) != string(compact) {
+		t.Errorf("MarshalIndent(doc, \"\", \"\") = %s, want Marshal's %s", data, compact)
+	}
+
+	type book struct {
+		XMLName xml.Name `xml:"book"`
+		Title   string   `xml:"title"`
+	}
+	data, err = xmldom.MarshalIndent(book{Title: "Go"}, "", "  ")
+	if err != nil {
+		t.Fatalf("MarshalIndent() failed: %v", err)
+	}
+	if want := "<book>\n  <title>Go</title>\n</book>"; string(data) != want {
+		t.Errorf("MarshalIndent(struct) = %q, want %q", data, want)
+	}
+}
+
 // countNonWhitespaceChildrenHelper counts children that are not whitespace-only text nodes
 func countNonWhitespaceChildrenHelper(elem xmldom.Element) int {
 	count := 0
@@ -3516,9 +3615,7 @@ func TestUnmarshalMethods(t *testing.T) {
 // ============================================================================
 
 // TestEncoderOperations tests encoder methods with 0% coverage
-// Skip for now due to encoder implementation details
 func TestEncoderOperations(t *testing.T) {
-	t.Skip("Encoder test skipped due to implementation details")
 	doc := createTestDoc(t)
 	root, _ := doc.CreateElement("root")
 	doc.AppendChild(root)
@@ -4840,9 +4937,31 @@ func TestRangeToString(t *testing.T) {
 
 	r := doc.CreateRange()
 	result := r.ToString()
-	// ToString is simplified implementation, should return empty string
+	// A collapsed range selects nothing
 	if result != "" {
-		t.Errorf("ToString should return empty string for simplified implementation, got '%s'", result)
+		t.Errorf("ToString should return empty string for a collapsed range, got '%s'", result)
+	}
+
+	doc = mustParse(t, `<p>Hello <b>bold<!--note--></b> and <i>italic</i> world</p>`)
+	p := doc.DocumentElement()
+	hello := p.FirstChild()
+	world := p.LastChild()
+
+	r = doc.CreateRange()
+	r.SetStart(hello, 1)
+	r.SetEnd(hello, 4)
+	if got := r.ToString(); got != "ell" {
+		t.Errorf("single text node: got %q, want %q", got, "ell")
+	}
+
+	r.SetEnd(world, 3)
+	if got := r.ToString(); got != "ello bold and italic wo" {
+		t.Errorf("across nodes: got %q, want %q", got, "ello bold and italic wo")
+	}
+
+	r.SelectNodeContents(p)
+	if got := r.ToString(); got != "Hello bold and italic world" {
+		t.Errorf("whole element: got %q, comments should be skipped", got)
 	}
 }
 
@@ -5151,3 +5270,2259 @@ func TestEntityNotationNodes(t *testing.T) {
 	// Note: Testing actual Entity and Notation node creation would require
 	// more complex setup as they're typically created through DTD parsing
 }
+
+// TestChildrenByNamespace tests the live namespace-filtered child element collection
+func TestChildrenByNamespace(t *testing.T) {
+	doc := createTestDoc(t)
+	root, _ := doc.CreateElementNS("urn:a", "a:root")
+	doc.AppendChild(root)
+
+	a1, _ := doc.CreateElementNS("urn:a", "a:item")
+	b1, _ := doc.CreateElementNS("urn:b", "b:item")
+	a2, _ := doc.CreateElementNS("urn:a", "a:other")
+	nested, _ := doc.CreateElementNS("urn:a", "a:nested")
+	root.AppendChild(a1)
+	root.AppendChild(doc.CreateTextNode("text"))
+	root.AppendChild(b1)
+	root.AppendChild(a2)
+	b1.AppendChild(nested)
+
+	inA := root.ChildrenByNamespace("urn:a")
+	if inA.Length() != 2 {
+		t.Fatalf("ChildrenByNamespace(urn:a) should return 2 elements, got %d", inA.Length())
+	}
+	if inA.Item(0) != a1 || inA.Item(1) != a2 {
+		t.Errorf("ChildrenByNamespace(urn:a) returned wrong elements")
+	}
+
+	inB := root.ChildrenByNamespace("urn:b")
+	if inB.Length() != 1 || inB.Item(0) != b1 {
+		t.Errorf("ChildrenByNamespace(urn:b) should return only b:item")
+	}
+
+	if all := root.ChildrenByNamespace("*"); all.Length() != 3 {
+		t.Errorf("ChildrenByNamespace(*) should return 3 elements, got %d", all.Length())
+	}
+
+	// The collection is live
+	a3, _ := doc.CreateElementNS("urn:a", "a:late")
+	root.AppendChild(a3)
+	if inA.Length() != 3 || inA.Item(2) != a3 {
+		t.Errorf("ChildrenByNamespace should reflect appended child, got length %d", inA.Length())
+	}
+	root.RemoveChild(a1)
+	if inA.Length() != 2 || inA.Item(0) != a2 {
+		t.Errorf("ChildrenByNamespace should reflect removed child, got length %d", inA.Length())
+	}
+}
+
+// TestNormalizeDeepTree verifies that normalization handles deeply nested trees
+// and merges text in place
+func TestNormalizeDeepTree(t *testing.T) {
+	doc := createTestDoc(t)
+	root, _ := doc.CreateElement("root")
+	doc.AppendChild(root)
+
+	const depth = 5000
+	parent := root
+	var firstTexts []xmldom.Text
+	for i := 0; i < depth; i++ {
+		elem, _ := doc.CreateElement("level")
+		first := doc.CreateTextNode("a")
+		parent.AppendChild(first)
+		parent.AppendChild(doc.CreateTextNode(""))
+		parent.AppendChild(doc.CreateTextNode("b"))
+		parent.AppendChild(elem)
+		parent.AppendChild(doc.CreateTextNode(""))
+		firstTexts = append(firstTexts, first)
+		parent = elem
+	}
+
+	doc.NormalizeDocument()
+
+	level := xmldom.Node(root)
+	for i := 0; i < depth; i++ {
+		if level.ChildNodes().Length() != 2 {
+			t.Fatalf("level %d: expected 2 children after normalization, got %d", i, level.ChildNodes().Length())
+		}
+		text, ok := level.FirstChild().(xmldom.Text)
+		if !ok || text.Data() != "ab" {
+			t.Fatalf("level %d: expected merged text 'ab', got %v", i, level.FirstChild())
+		}
+		if text != firstTexts[i] {
+			t.Fatalf("level %d: merged text should reuse the first text node", i)
+		}
+		if text.ParentNode() != level {
+			t.Fatalf("level %d: merged text has wrong parent", i)
+		}
+		level = level.LastChild()
+	}
+}
+
+// TestNewStaticNodeList tests building a NodeList from a slice of nodes
+func TestNewStaticNodeList(t *testing.T) {
+	doc := createTestDoc(t)
+	root, _ := doc.CreateElement("root")
+	doc.AppendChild(root)
+	a, _ := doc.CreateElement("a")
+	b := doc.CreateTextNode("b")
+	root.AppendChild(a)
+	root.AppendChild(b)
+
+	nodes := []xmldom.Node{a, b}
+	list := xmldom.NewStaticNodeList(nodes)
+	if list.Length() != 2 {
+		t.Fatalf("Length should be 2, got %d", list.Length())
+	}
+	if list.Item(0) != a || list.Item(1) != b {
+		t.Errorf("Item returned unexpected nodes")
+	}
+	if list.Item(2) != nil {
+		t.Errorf("Item out of range should return nil")
+	}
+
+	// The list is a snapshot of the slice and ignores later changes
+	nodes[0] = b
+	root.RemoveChild(a)
+	if list.Length() != 2 || list.Item(0) != a {
+		t.Errorf("Static NodeList should not track mutations")
+	}
+
+	if empty := xmldom.NewStaticNodeList(nil); empty.Length() != 0 {
+		t.Errorf("Empty static NodeList should have length 0")
+	}
+}
+
+// TestInsertAdjacentElement tests each insertion position, including on an orphan element
+func TestInsertAdjacentElement(t *testing.T) {
+	doc := createTestDoc(t)
+	newElem := func(name string) xmldom.Element {
+		e, _ := doc.CreateElement(xmldom.DOMString(name))
+		return e
+	}
+
+	t.Run("orphan", func(t *testing.T) {
+		orphan := newElem("orphan")
+		orphan.AppendChild(newElem("existing"))
+
+		for _, where := range []xmldom.DOMString{"beforebegin", "afterend"} {
+			got, err := orphan.InsertAdjacentElement(where, newElem("x"))
+			if err != nil || got != nil {
+				t.Errorf("%s on orphan should return nil, nil; got %v, %v", where, got, err)
+			}
+		}
+
+		first := newElem("first")
+		if got, err := orphan.InsertAdjacentElement("afterbegin", first); err != nil || got != first {
+			t.Fatalf("afterbegin on orphan failed: %v", err)
+		}
+		last := newElem("last")
+		if got, err := orphan.InsertAdjacentElement("beforeend", last); err != nil || got != last {
+			t.Fatalf("beforeend on orphan failed: %v", err)
+		}
+		if orphan.FirstChild() != first || orphan.LastChild() != last || orphan.ChildNodes().Length() != 3 {
+			t.Errorf("orphan children not inserted at expected positions")
+		}
+	})
+
+	t.Run("attached", func(t *testing.T) {
+		parent := newElem("parent")
+		target := newElem("target")
+		parent.AppendChild(target)
+
+		before := newElem("before")
+		after := newElem("after")
+		if _, err := target.InsertAdjacentElement("BeforeBegin", before); err != nil {
+			t.Fatalf("beforebegin failed: %v", err)
+		}
+		if _, err := target.InsertAdjacentElement("afterend", after); err != nil {
+			t.Fatalf("afterend failed: %v", err)
+		}
+		if parent.FirstChild() != before || before.NextSibling() != target || target.NextSibling() != after {
+			t.Errorf("siblings not inserted around target")
+		}
+	})
+
+	t.Run("document element", func(t *testing.T) {
+		d := createTestDoc(t)
+		root, _ := d.CreateElement("root")
+		d.AppendChild(root)
+		other, _ := d.CreateElement("other")
+		if _, err := root.InsertAdjacentElement("afterend", other); err == nil {
+			t.Errorf("afterend on the document element should fail")
+		}
+		if got, err := root.InsertAdjacentElement("beforeend", other); err != nil || got != other {
+			t.Errorf("beforeend on the document element should succeed: %v", err)
+		}
+	})
+
+	t.Run("invalid position", func(t *testing.T) {
+		if _, err := newElem("e").InsertAdjacentElement("middle", newElem("x")); err == nil {
+			t.Errorf("invalid position should return an error")
+		}
+	})
+}
+
+// TestNonNamespaceAttributes tests that namespace declarations are skipped
+func TestNonNamespaceAttributes(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(`<root xmlns="urn:default" a="1" xmlns:foo="urn:foo" foo:b="2"/>`))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+	attrs := doc.DocumentElement().NonNamespaceAttributes()
+	if len(attrs) != 2 {
+		t.Fatalf("expected 2 ordinary attributes, got %d", len(attrs))
+	}
+	if attrs[0].LocalName() != "a" || attrs[0].Value() != "1" {
+		t.Errorf("first attribute should be a=1, got %s=%s", attrs[0].LocalName(), attrs[0].Value())
+	}
+	if attrs[1].LocalName() != "b" || attrs[1].Value() != "2" {
+		t.Errorf("second attribute should be b=2, got %s=%s", attrs[1].LocalName(), attrs[1].Value())
+	}
+
+	// Declarations created through the API are recognised too
+	built := createTestDoc(t)
+	elem, _ := built.CreateElement("e")
+	elem.SetAttribute("xmlns", "urn:x")
+	elem.SetAttribute("xmlns:p", "urn:p")
+	elem.SetAttributeNS("http://www.w3.org/2000/xmlns/", "xmlns:q", "urn:q")
+	elem.SetAttribute("plain", "v")
+	if attrs := elem.NonNamespaceAttributes(); len(attrs) != 1 || attrs[0].Name() != "plain" {
+		t.Errorf("expected only 'plain', got %d attributes", len(attrs))
+	}
+}
+
+func TestCloneWithChildren(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(`<root id="r">head<a><x/>inner</a>middle<b/>tail</root>`))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+	root := doc.DocumentElement()
+
+	clone := root.CloneWithChildren(false, func(n xmldom.Node) bool {
+		return n.NodeType() == xmldom.ELEMENT_NODE
+	})
+	if clone.GetAttribute("id") != "r" {
+		t.Errorf("attributes should be cloned, got id=%q", clone.GetAttribute("id"))
+	}
+	if clone.ChildNodes().Length() != 2 {
+		t.Fatalf("expected 2 kept children, got %d", clone.ChildNodes().Length())
+	}
+	a := clone.FirstElementChild()
+	if a == nil || a.TagName() != "a" || a.NextElementSibling().TagName() != "b" {
+		t.Fatalf("expected children <a> and <b>")
+	}
+	// Kept children are deep clones, so their own text survives
+	if a.TextContent() != "inner" || a.FirstElementChild() == nil {
+		t.Errorf("kept child should be deep-cloned, got %q", a.TextContent())
+	}
+	if a.IsSameNode(root.FirstElementChild()) {
+		t.Errorf("kept child should be a copy, not the original")
+	}
+
+	if full := root.CloneWithChildren(true, nil); full.ChildNodes().Length() != 5 {
+		t.Errorf("deep CloneWithChildren should keep all 5 children, got %d", full.ChildNodes().Length())
+	}
+	if bare := root.CloneWithChildren(false, nil); bare.HasChildNodes() {
+		t.Errorf("nil keep should clone no children")
+	}
+}
+
+func TestGetElementsByTagNameMatchMode(t *testing.T) {
+	doc := createTestDoc(t)
+	root, _ := doc.CreateElement("root")
+	doc.AppendChild(root)
+	nsItem, _ := doc.CreateElementNS("urn:p", "p:item")
+	plainItem, _ := doc.CreateElement("item")
+	root.AppendChild(nsItem)
+	root.AppendChild(plainItem)
+
+	// Spec behaviour: qualified name only
+	if n := doc.GetElementsByTagName("item").Length(); n != 1 {
+		t.Errorf("qualified matching: expected 1 <item>, got %d", n)
+	}
+	if n := doc.GetElementsByTagName("p:item").Length(); n != 1 {
+		t.Errorf("qualified matching: expected 1 <p:item>, got %d", n)
+	}
+
+	doc.SetTagNameMatch(xmldom.TagNameMatchLocalName)
+	if n := doc.GetElementsByTagName("item").Length(); n != 2 {
+		t.Errorf("local-name matching: expected 2 items, got %d", n)
+	}
+	if n := root.GetElementsByTagName("item").Length(); n != 2 {
+		t.Errorf("local-name matching on element: expected 2 items, got %d", n)
+	}
+	if n := doc.GetElementsByTagName("p:item").Length(); n != 1 {
+		t.Errorf("local-name matching: qualified lookup should still find 1, got %d", n)
+	}
+}
+
+func TestDepthAndSubtreeNodeCount(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(`<root><a><b>text</b><!--c--></a><d/></root>`))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+	root := doc.DocumentElement()
+	a := root.FirstElementChild()
+	b := a.FirstElementChild()
+
+	depths := []struct {
+		name string
+		node xmldom.Node
+		want int
+	}{
+		{"document", doc, 0},
+		{"root", root, 1},
+		{"a", a, 2},
+		{"b", b, 3},
+		{"text", b.FirstChild(), 4},
+	}
+	for _, tt := range depths {
+		if got := tt.node.Depth(); got != tt.want {
+			t.Errorf("%s.Depth() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// root, a, b, text, comment, d
+	if got := root.SubtreeNodeCount(); got != 6 {
+		t.Errorf("root.SubtreeNodeCount() = %d, want 6", got)
+	}
+	if got := doc.SubtreeNodeCount(); got != 7 {
+		t.Errorf("doc.SubtreeNodeCount() = %d, want 7", got)
+	}
+	if got := b.FirstChild().SubtreeNodeCount(); got != 1 {
+		t.Errorf("text.SubtreeNodeCount() = %d, want 1", got)
+	}
+
+	// Detached nodes count depth to their local root
+	root.RemoveChild(a)
+	if got := b.Depth(); got != 1 {
+		t.Errorf("detached b.Depth() = %d, want 1", got)
+	}
+	if got := a.Depth(); got != 0 {
+		t.Errorf("detached a.Depth() = %d, want 0", got)
+	}
+}
+
+func TestResolveURI(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(`<root xmlns:xlink="http://www.w3.org/1999/xlink">` +
+		`<link xlink:href="a/b.xml"/>` +
+		`<section xml:base="docs/"><link xlink:href="../c.xml"/></section>` +
+		`</root>`))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+	doc.SetDocumentURI("http://example.com/base/index.xml")
+
+	links := doc.GetElementsByTagName("link")
+	first := links.Item(0).(xmldom.Element)
+	got, err := first.ResolveURI(first.GetAttributeNS("http://www.w3.org/1999/xlink", "href"))
+	if err != nil {
+		t.Fatalf("ResolveURI failed: %v", err)
+	}
+	if got != "http://example.com/base/a/b.xml" {
+		t.Errorf("resolved against document URL = %q", got)
+	}
+
+	second := links.Item(1).(xmldom.Element)
+	if base := second.BaseURI(); base != "http://example.com/base/docs/" {
+		t.Errorf("BaseURI with xml:base = %q", base)
+	}
+	got, err = second.ResolveURI("../c.xml")
+	if err != nil {
+		t.Fatalf("ResolveURI failed: %v", err)
+	}
+	if got != "http://example.com/base/c.xml" {
+		t.Errorf("resolved against xml:base = %q", got)
+	}
+
+	if _, err := first.ResolveURI("http://[::1"); err == nil {
+		t.Errorf("expected error for malformed reference")
+	}
+}
+
+func TestWrapChildren(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(`<root><p>before<b>bold</b>after<!--note--></p></root>`))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+	p := doc.DocumentElement().FirstElementChild()
+	childNodes := p.ChildNodes()
+	bolds := doc.GetElementsByTagName("b")
+
+	wrapper, _ := doc.CreateElement("span")
+	if err := p.WrapChildren(wrapper); err != nil {
+		t.Fatalf("WrapChildren failed: %v", err)
+	}
+
+	if childNodes.Length() != 1 || childNodes.Item(0) != xmldom.Node(wrapper) {
+		t.Fatalf("expected wrapper as sole child, live list has %d items", childNodes.Length())
+	}
+	if wrapper.ParentNode() != xmldom.Node(p) {
+		t.Errorf("wrapper parent should be <p>")
+	}
+	if n := wrapper.ChildNodes().Length(); n != 4 {
+		t.Fatalf("expected 4 wrapped children, got %d", n)
+	}
+	var kinds []uint16
+	for c := wrapper.FirstChild(); c != nil; c = c.NextSibling() {
+		if c.ParentNode() != xmldom.Node(wrapper) {
+			t.Errorf("child %q not reparented", c.NodeName())
+		}
+		kinds = append(kinds, c.NodeType())
+	}
+	want := []uint16{xmldom.TEXT_NODE, xmldom.ELEMENT_NODE, xmldom.TEXT_NODE, xmldom.COMMENT_NODE}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Errorf("child %d has type %d, want %d", i, kinds[i], want[i])
+		}
+	}
+	if wrapper.LastChild().PreviousSibling().NodeValue() != "after" {
+		t.Errorf("sibling links not preserved")
+	}
+	if bolds.Length() != 1 {
+		t.Errorf("live tag list should still find <b>, got %d", bolds.Length())
+	}
+
+	if err := wrapper.WrapChildren(p); err == nil {
+		t.Errorf("wrapping in an ancestor should fail")
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(`<root><a/><span id="s">one<b/>two</span><c/></root>`))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+	root := doc.DocumentElement()
+	span := doc.GetElementById("s")
+	rootChildren := root.ChildNodes()
+
+	if err := span.Unwrap(); err != nil {
+		t.Fatalf("Unwrap failed: %v", err)
+	}
+
+	var got []string
+	for c := root.FirstChild(); c != nil; c = c.NextSibling() {
+		if c.ParentNode() != xmldom.Node(root) {
+			t.Errorf("%q not reparented to root", c.NodeName())
+		}
+		if c.NodeType() == xmldom.TEXT_NODE {
+			got = append(got, string(c.NodeValue()))
+		} else {
+			got = append(got, string(c.NodeName()))
+		}
+	}
+	want := []string{"a", "one", "b", "two", "c"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("children after unwrap = %v, want %v", got, want)
+	}
+	if root.LastChild().PreviousSibling().NodeValue() != "two" {
+		t.Errorf("backward sibling links broken")
+	}
+	if rootChildren.Length() != 5 {
+		t.Errorf("live childNodes has %d items, want 5", rootChildren.Length())
+	}
+	if span.ParentNode() != nil || span.HasChildNodes() {
+		t.Errorf("unwrapped element should be detached and empty")
+	}
+	if doc.GetElementById("s") != nil {
+		t.Errorf("unwrapped element should be removed from the id index")
+	}
+
+	// Parentless elements are left alone
+	if err := span.Unwrap(); err != nil {
+		t.Errorf("Unwrap on a detached element should be a no-op, got %v", err)
+	}
+}
+
+func TestElementSiblingsFromNonElements(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(`<p><a/>text<!--c--><b/>tail</p>`))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+	p := doc.DocumentElement()
+	text := p.FirstChild().NextSibling()
+	if text.NodeType() != xmldom.TEXT_NODE {
+		t.Fatalf("expected text node, got %s", text.NodeName())
+	}
+
+	if prev := text.PreviousElementSibling(); prev == nil || prev.TagName() != "a" {
+		t.Errorf("text.PreviousElementSibling() should be <a>")
+	}
+	if next := text.NextElementSibling(); next == nil || next.TagName() != "b" {
+		t.Errorf("text.NextElementSibling() should be <b>, skipping the comment")
+	}
+	comment := text.NextSibling()
+	if next := comment.NextElementSibling(); next == nil || next.TagName() != "b" {
+		t.Errorf("comment.NextElementSibling() should be <b>")
+	}
+	tail := p.LastChild()
+	if tail.NextElementSibling() != nil {
+		t.Errorf("last text node should have no next element sibling")
+	}
+	if prev := tail.PreviousElementSibling(); prev == nil || prev.TagName() != "b" {
+		t.Errorf("tail.PreviousElementSibling() should be <b>")
+	}
+}
+
+func TestCreateDocumentQualifiedName(t *testing.T) {
+	impl := xmldom.NewDOMImplementation()
+	doc, err := impl.CreateDocument("http://ns", "p:root", nil)
+	if err != nil {
+		t.Fatalf("CreateDocument failed: %v", err)
+	}
+	root := doc.DocumentElement()
+	if root.LocalName() != "root" || root.Prefix() != "p" || root.NamespaceURI() != "http://ns" {
+		t.Errorf("root = {%q %q %q}, want local root, prefix p, namespace http://ns",
+			root.LocalName(), root.Prefix(), root.NamespaceURI())
+	}
+	if root.TagName() != "p:root" {
+		t.Errorf("TagName() = %q, want p:root", root.TagName())
+	}
+
+	invalid := []struct{ ns, name, code string }{
+		{"", "p:root", "NamespaceError"},
+		{"http://ns", "xml:root", "NamespaceError"},
+		{"http://ns", "p:", "InvalidCharacterError"},
+		{"http://ns", "a:b:c", "InvalidCharacterError"},
+	}
+	for _, tt := range invalid {
+		_, err := impl.CreateDocument(xmldom.DOMString(tt.ns), xmldom.DOMString(tt.name), nil)
+		if domErr, ok := err.(*xmldom.DOMException); !ok || !strings.HasPrefix(domErr.Error(), tt.code) {
+			t.Errorf("CreateDocument(%q, %q) error = %v, want %s", tt.ns, tt.name, err, tt.code)
+		}
+	}
+}
+
+func TestRemoveNamedItemNSSameQualifiedName(t *testing.T) {
+	doc := createTestDoc(t)
+	elem, _ := doc.CreateElement("e")
+	elem.SetAttributeNS("urn:a", "p:x", "from-a")
+	elem.SetAttributeNS("urn:b", "p:x", "from-b")
+	elem.SetAttribute("plain", "v")
+
+	attrs := elem.Attributes()
+	if attrs.Length() != 3 {
+		t.Fatalf("expected 3 attributes, got %d", attrs.Length())
+	}
+
+	removed, err := attrs.RemoveNamedItemNS("urn:b", "x")
+	if err != nil {
+		t.Fatalf("RemoveNamedItemNS failed: %v", err)
+	}
+	if removed.NodeValue() != "from-b" {
+		t.Errorf("removed the wrong attribute: %q", removed.NodeValue())
+	}
+	if attrs.Length() != 2 {
+		t.Fatalf("expected 2 attributes after removal, got %d", attrs.Length())
+	}
+	if got := elem.GetAttributeNS("urn:a", "x"); got != "from-a" {
+		t.Errorf("attribute in urn:a should remain, got %q", got)
+	}
+	if attrs.Item(0).NodeValue() != "from-a" || attrs.Item(1).NodeName() != "plain" {
+		t.Errorf("remaining attributes out of order")
+	}
+
+	if _, err := attrs.RemoveNamedItemNS("urn:b", "x"); err == nil || !strings.HasPrefix(err.Error(), "NotFoundError") {
+		t.Errorf("second removal should return NotFoundError, got %v", err)
+	}
+}
+
+func TestCheckReferentialIntegrity(t *testing.T) {
+	doc := mustParse(t, `<scxml initial="idle">`+
+		`<state id="idle"><transition event="go" target="running"/></state>`+
+		`<state id="running"><transition event="stop" target="idle missing"/></state>`+
+		`</scxml>`)
+
+	errs := doc.CheckReferentialIntegrity(map[xmldom.DOMString][]xmldom.DOMString{
+		"scxml":      {"initial"},
+		"transition": {"target"},
+	})
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 dangling reference, got %d: %v", len(errs), errs)
+	}
+	ref, ok := errs[0].(*xmldom.DanglingReferenceError)
+	if !ok {
+		t.Fatalf("expected *DanglingReferenceError, got %T", errs[0])
+	}
+	if ref.Ref != "missing" || ref.Attribute != "target" {
+		t.Errorf("unexpected reference %q in %q", ref.Ref, ref.Attribute)
+	}
+	if ref.Path != "/scxml/state[2]/transition" {
+		t.Errorf("unexpected path %q", ref.Path)
+	}
+	if !strings.Contains(errs[0].Error(), `"missing"`) {
+		t.Errorf("error should name the missing id: %v", errs[0])
+	}
+}
+
+func TestFindDuplicateIds(t *testing.T) {
+	doc := mustParse(t, `<root><a id="x"/><b id="y"/><c id="x"/></root>`)
+
+	dups := doc.FindDuplicateIds()
+	if len(dups) != 1 {
+		t.Fatalf("expected 1 duplicated id, got %d", len(dups))
+	}
+	elems := dups["x"]
+	if len(elems) != 2 || elems[0].TagName() != "a" || elems[1].TagName() != "c" {
+		t.Fatalf("unexpected holders of id x: %v", elems)
+	}
+
+	if got := doc.GetElementById("x"); got == nil || got.TagName() != "a" {
+		t.Errorf("GetElementById should return the first element in document order, got %v", got)
+	}
+	if got := doc.GetElementById("y"); got == nil || got.TagName() != "b" {
+		t.Errorf("GetElementById(y) = %v", got)
+	}
+
+	// Removing the first holder falls back to the remaining one
+	doc.DocumentElement().RemoveChild(elems[0])
+	if got := doc.GetElementById("x"); got == nil || got.TagName() != "c" {
+		t.Errorf("after removal GetElementById should return <c>, got %v", got)
+	}
+}
+
+func TestRangeDeleteContents(t *testing.T) {
+	t.Run("single text node", func(t *testing.T) {
+		doc := mustParse(t, `<root>Hello World</root>`)
+		text := doc.DocumentElement().FirstChild()
+
+		r := doc.CreateRange()
+		r.SetStart(text, 5)
+		r.SetEnd(text, 11)
+		if err := r.DeleteContents(); err != nil {
+			t.Fatalf("DeleteContents failed: %v", err)
+		}
+		if got := text.NodeValue(); got != "Hello" {
+			t.Errorf("text = %q, want %q", got, "Hello")
+		}
+		if !r.Collapsed() || r.StartContainer() != text || r.StartOffset() != 5 {
+			t.Errorf("range should collapse to (text, 5)")
+		}
+	})
+
+	t.Run("across containers", func(t *testing.T) {
+		doc := mustParse(t, `<root><p>Hello <b>bold</b> world</p><q>tail text</q></root>`)
+		root := doc.DocumentElement()
+		start := root.FirstChild().FirstChild()
+		end := root.LastChild().FirstChild()
+
+		r := doc.CreateRange()
+		r.SetStart(start, 2)
+		r.SetEnd(end, 5)
+		if err := r.DeleteContents(); err != nil {
+			t.Fatalf("DeleteContents failed: %v", err)
+		}
+
+		out, err := xmldom.Marshal(root)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if want := `<root><p>He</p><q>text</q></root>`; string(out) != want {
+			t.Errorf("got %s, want %s", out, want)
+		}
+		if !r.Collapsed() || r.StartContainer() != root || r.StartOffset() != 1 {
+			t.Errorf("range should collapse to (root, 1), got (%v, %d)", r.StartContainer(), r.StartOffset())
+		}
+	})
+
+	t.Run("partially selected elements", func(t *testing.T) {
+		doc := mustParse(t, `<root><p>Hello <b>bold</b> world</p><i/><q><s>tail</s> text</q></root>`)
+		root := doc.DocumentElement()
+		removed := root.ChildNodes().Item(1)
+
+		r := doc.CreateRange()
+		r.SetStart(root.FirstChild().ChildNodes().Item(1).FirstChild(), 2)
+		r.SetEnd(root.LastChild().FirstChild().FirstChild(), 2)
+		if err := r.DeleteContents(); err != nil {
+			t.Fatalf("DeleteContents failed: %v", err)
+		}
+
+		out, _ := xmldom.Marshal(root)
+		if want := `<root><p>Hello <b>bo</b></p><q><s>il</s> text</q></root>`; string(out) != want {
+			t.Errorf("got %s, want %s", out, want)
+		}
+		if removed.ParentNode() != nil {
+			t.Errorf("deleted nodes should be detached, not left in a fragment")
+		}
+	})
+
+	t.Run("collapsed range", func(t *testing.T) {
+		doc := mustParse(t, `<root><a/></root>`)
+		r := doc.CreateRange()
+		r.SetStart(doc.DocumentElement(), 0)
+		r.SetEnd(doc.DocumentElement(), 0)
+		if err := r.DeleteContents(); err != nil {
+			t.Fatalf("DeleteContents failed: %v", err)
+		}
+		if !doc.DocumentElement().HasChildNodes() {
+			t.Errorf("collapsed range must not remove anything")
+		}
+	})
+}
+
+func TestRangeExtractContents(t *testing.T) {
+	t.Run("single text node", func(t *testing.T) {
+		doc := mustParse(t, `<root>Hello World</root>`)
+		root := doc.DocumentElement()
+
+		r := doc.CreateRange()
+		r.SetStart(root.FirstChild(), 0)
+		r.SetEnd(root.FirstChild(), 6)
+		frag, err := r.ExtractContents()
+		if err != nil {
+			t.Fatalf("ExtractContents failed: %v", err)
+		}
+		if got := frag.TextContent(); got != "Hello " {
+			t.Errorf("fragment text = %q", got)
+		}
+		if got := root.TextContent(); got != "World" {
+			t.Errorf("remaining text = %q", got)
+		}
+		if !r.Collapsed() {
+			t.Errorf("range should be collapsed")
+		}
+	})
+
+	t.Run("across containers", func(t *testing.T) {
+		doc := mustParse(t, `<root><p>Hello <b>bold</b> world</p><i/><q>tail text</q></root>`)
+		root := doc.DocumentElement()
+		start := root.FirstChild().FirstChild()
+		end := root.LastChild().FirstChild()
+
+		r := doc.CreateRange()
+		r.SetStart(start, 2)
+		r.SetEnd(end, 5)
+		frag, err := r.ExtractContents()
+		if err != nil {
+			t.Fatalf("ExtractContents failed: %v", err)
+		}
+
+		out, _ := xmldom.Marshal(root)
+		if want := `<root><p>He</p><q>text</q></root>`; string(out) != want {
+			t.Errorf("tree = %s, want %s", out, want)
+		}
+
+		var got strings.Builder
+		for child := frag.FirstChild(); child != nil; child = child.NextSibling() {
+			s, _ := xmldom.Marshal(child)
+			got.Write(s)
+		}
+		if want := `<p>llo <b>bold</b> world</p><i></i><q>tail </q>`; got.String() != want {
+			t.Errorf("fragment = %s, want %s", got.String(), want)
+		}
+		if frag.FirstChild().PreviousSibling() != nil || frag.LastChild().NextSibling() != nil {
+			t.Errorf("fragment sibling links are broken")
+		}
+		if root.FirstChild().NodeName() != "p" || root.LastChild().NodeName() != "q" {
+			t.Errorf("firstChild/lastChild not fixed up")
+		}
+		if !r.Collapsed() || r.StartContainer() != root || r.StartOffset() != 1 {
+			t.Errorf("range should collapse to (root, 1)")
+		}
+	})
+}
+
+func TestMergeWithPrevious(t *testing.T) {
+	doc := mustParse(t, `<root><ul class="x"><li>a</li><li>b</li></ul> <ul class="x"><li>c</li></ul><ol/><ul/></root>`)
+	root := doc.DocumentElement()
+	items := root.GetElementsByTagName("li")
+
+	second := root.ChildNodes().Item(2).(xmldom.Element)
+	if err := second.MergeWithPrevious(); err != nil {
+		t.Fatalf("MergeWithPrevious failed: %v", err)
+	}
+	if second.ParentNode() != nil || second.HasChildNodes() {
+		t.Errorf("merged element should be detached and empty")
+	}
+	first := root.FirstElementChild()
+	if got := first.TextContent(); got != "abc" {
+		t.Errorf("merged children = %q, want %q", got, "abc")
+	}
+	if first.LastChild().ParentNode() != first {
+		t.Errorf("moved children should be reparented")
+	}
+	if items.Length() != 3 {
+		t.Errorf("live list length = %d, want 3", items.Length())
+	}
+	if root.ChildElementCount() != 3 {
+		t.Errorf("root should have 3 element children, got %d", root.ChildElementCount())
+	}
+
+	// <ul/> follows <ol/>, so it cannot merge
+	last := root.LastElementChild()
+	if err := last.MergeWithPrevious(); err == nil || !strings.HasPrefix(err.Error(), "InvalidModificationError") {
+		t.Errorf("expected InvalidModificationError, got %v", err)
+	}
+	if err := first.MergeWithPrevious(); err == nil || !strings.HasPrefix(err.Error(), "NotFoundError") {
+		t.Errorf("expected NotFoundError, got %v", err)
+	}
+}
+
+func TestSetOuterXML(t *testing.T) {
+	doc := mustParse(t, `<root xmlns:s="urn:s"><a/><old/><z/></root>`)
+	root := doc.DocumentElement()
+	old := root.ChildNodes().Item(1).(xmldom.Element)
+
+	if err := old.SetOuterXML(`<b id="nb">one</b><s:c>two</s:c>`); err != nil {
+		t.Fatalf("SetOuterXML failed: %v", err)
+	}
+	if old.ParentNode() != nil {
+		t.Errorf("replaced element should be detached")
+	}
+
+	var names []string
+	for child := root.FirstChild(); child != nil; child = child.NextSibling() {
+		names = append(names, string(child.LocalName()))
+	}
+	if got := strings.Join(names, ","); got != "a,b,c,z" {
+		t.Fatalf("children = %s, want a,b,c,z", got)
+	}
+	c := root.ChildNodes().Item(2)
+	if c.NamespaceURI() != "urn:s" {
+		t.Errorf("prefix from the parent's context not resolved, namespace = %q", c.NamespaceURI())
+	}
+	if c.OwnerDocument() != doc || c.ParentNode() != root {
+		t.Errorf("parsed nodes should belong to the document")
+	}
+	if got := doc.GetElementById("nb"); got == nil || got.TextContent() != "one" {
+		t.Errorf("id of inserted element should be indexed")
+	}
+
+	// Malformed markup leaves the tree unchanged
+	a := root.FirstElementChild()
+	if err := a.SetOuterXML(`<x><y></x>`); err == nil || !strings.HasPrefix(err.Error(), "SyntaxError") {
+		t.Errorf("expected SyntaxError, got %v", err)
+	}
+	if root.FirstChild() != a || root.ChildNodes().Length() != 4 {
+		t.Errorf("tree changed after malformed markup")
+	}
+
+	detached, _ := doc.CreateElement("d")
+	if err := detached.SetOuterXML(`<e/>`); err == nil || !strings.HasPrefix(err.Error(), "NoModificationAllowedError") {
+		t.Errorf("expected NoModificationAllowedError for a parentless element, got %v", err)
+	}
+}
+
+func TestRangeInsertNode(t *testing.T) {
+	t.Run("inside text", func(t *testing.T) {
+		doc := mustParse(t, `<root>HelloWorld</root>`)
+		root := doc.DocumentElement()
+		text := root.FirstChild()
+
+		r := doc.CreateRange()
+		r.SetStart(text, 5)
+		r.SetEnd(text, 5)
+		b, _ := doc.CreateElement("b")
+		if err := r.InsertNode(b); err != nil {
+			t.Fatalf("InsertNode failed: %v", err)
+		}
+		out, _ := xmldom.Marshal(root)
+		if want := `<root>Hello<b></b>World</root>`; string(out) != want {
+			t.Errorf("got %s, want %s", out, want)
+		}
+		if r.StartContainer() != text || r.StartOffset() != 5 {
+			t.Errorf("start boundary should stay before the inserted node")
+		}
+
+		// A second insertion lands before the first
+		i, _ := doc.CreateElement("i")
+		if err := r.InsertNode(i); err != nil {
+			t.Fatalf("InsertNode failed: %v", err)
+		}
+		out, _ = xmldom.Marshal(root)
+		if want := `<root>Hello<i></i><b></b>World</root>`; string(out) != want {
+			t.Errorf("got %s, want %s", out, want)
+		}
+	})
+
+	t.Run("element container with fragment", func(t *testing.T) {
+		doc := mustParse(t, `<root><a/><z/></root>`)
+		root := doc.DocumentElement()
+
+		frag := doc.CreateDocumentFragment()
+		for _, name := range []string{"x", "y"} {
+			e, _ := doc.CreateElement(xmldom.DOMString(name))
+			frag.AppendChild(e)
+		}
+		r := doc.CreateRange()
+		r.SetStart(root, 1)
+		r.SetEnd(root, 1)
+		if err := r.InsertNode(frag); err != nil {
+			t.Fatalf("InsertNode failed: %v", err)
+		}
+		out, _ := xmldom.Marshal(root)
+		if want := `<root><a></a><x></x><y></y><z></z></root>`; string(out) != want {
+			t.Errorf("got %s, want %s", out, want)
+		}
+		if r.StartOffset() != 1 || r.EndContainer() != root || r.EndOffset() != 3 {
+			t.Errorf("range should span the inserted nodes, got [%d, %d]", r.StartOffset(), r.EndOffset())
+		}
+	})
+
+	t.Run("non-collapsed range", func(t *testing.T) {
+		doc := mustParse(t, `<root><a/><b/></root>`)
+		root := doc.DocumentElement()
+
+		r := doc.CreateRange()
+		r.SetStart(root, 0)
+		r.SetEnd(root, 2)
+		x, _ := doc.CreateElement("x")
+		if err := r.InsertNode(x); err != nil {
+			t.Fatalf("InsertNode failed: %v", err)
+		}
+		if r.StartOffset() != 0 || r.EndOffset() != 3 {
+			t.Errorf("range should still select every original child, got [%d, %d]", r.StartOffset(), r.EndOffset())
+		}
+		if root.FirstChild() != x {
+			t.Errorf("node should be inserted at the start boundary")
+		}
+
+		// A fragment shifts the end by the number of its children
+		frag := doc.CreateDocumentFragment()
+		for _, name := range []string{"y", "z"} {
+			e, _ := doc.CreateElement(xmldom.DOMString(name))
+			frag.AppendChild(e)
+		}
+		if err := r.InsertNode(frag); err != nil {
+			t.Fatalf("InsertNode failed: %v", err)
+		}
+		if r.EndContainer() != root || r.EndOffset() != 5 {
+			t.Errorf("end offset = %d, want 5", r.EndOffset())
+		}
+	})
+
+	t.Run("non-collapsed range split inside text", func(t *testing.T) {
+		doc := mustParse(t, `<root>HelloWorld<e/></root>`)
+		root := doc.DocumentElement()
+
+		r := doc.CreateRange()
+		r.SetStart(root.FirstChild(), 5)
+		r.SetEnd(root, 2)
+		b, _ := doc.CreateElement("b")
+		if err := r.InsertNode(b); err != nil {
+			t.Fatalf("InsertNode failed: %v", err)
+		}
+		out, _ := xmldom.Marshal(root)
+		if want := `<root>Hello<b></b>World<e></e></root>`; string(out) != want {
+			t.Errorf("got %s, want %s", out, want)
+		}
+		if r.EndContainer() != root || r.EndOffset() != 4 {
+			t.Errorf("end should still follow <e/>, got offset %d", r.EndOffset())
+		}
+	})
+
+	t.Run("comment container", func(t *testing.T) {
+		doc := mustParse(t, `<root><!--c--></root>`)
+		r := doc.CreateRange()
+		r.SetStart(doc.DocumentElement().FirstChild(), 1)
+		e, _ := doc.CreateElement("e")
+		if err := r.InsertNode(e); err == nil || !strings.HasPrefix(err.Error(), "HierarchyRequestError") {
+			t.Errorf("expected HierarchyRequestError, got %v", err)
+		}
+	})
+}
+
+func TestRangeSurroundContents(t *testing.T) {
+	doc := mustParse(t, `<root>Hello <b>bold</b> world</root>`)
+	root := doc.DocumentElement()
+
+	r := doc.CreateRange()
+	r.SetStart(root.FirstChild(), 2)
+	r.SetEnd(root.LastChild(), 3)
+	em, _ := doc.CreateElement("em")
+	if err := r.SurroundContents(em); err != nil {
+		t.Fatalf("SurroundContents failed: %v", err)
+	}
+	out, _ := xmldom.Marshal(root)
+	if want := `<root>He<em>llo <b>bold</b> wo</em>rld</root>`; string(out) != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+	if r.StartContainer() != root || r.StartOffset() != 1 || r.EndOffset() != 2 {
+		t.Errorf("range should select the new parent")
+	}
+
+	// A boundary inside <b> splits a non-Text node
+	b := em.ChildNodes().Item(1)
+	r.SetStart(b.FirstChild(), 1)
+	r.SetEnd(em.LastChild(), 1)
+	strong, _ := doc.CreateElement("strong")
+	if err := r.SurroundContents(strong); err == nil || !strings.HasPrefix(err.Error(), "InvalidStateError") {
+		t.Errorf("expected InvalidStateError, got %v", err)
+	}
+}
+
+func TestTextNodes(t *testing.T) {
+	doc := mustParse(t, `<p>one<b>two<i>three</i></b><!--skip-->four</p>`)
+	p := doc.DocumentElement()
+	cdata, _ := doc.CreateCDATASection("five")
+	p.AppendChild(cdata)
+
+	collect := func(texts []xmldom.Text) string {
+		var parts []string
+		for _, text := range texts {
+			parts = append(parts, string(text.Data()))
+		}
+		return strings.Join(parts, ",")
+	}
+
+	if got := collect(p.TextNodes(false)); got != "one,two,three,four" {
+		t.Errorf("TextNodes(false) = %s", got)
+	}
+	if got := collect(p.TextNodes(true)); got != "one,two,three,four,five" {
+		t.Errorf("TextNodes(true) = %s", got)
+	}
+	if got := collect(p.FirstChild().TextNodes(false)); got != "one" {
+		t.Errorf("a Text node should return itself, got %s", got)
+	}
+	if got := cdata.TextNodes(false); len(got) != 0 {
+		t.Errorf("CDATA section should be excluded, got %d nodes", len(got))
+	}
+}
+
+func TestSetPrefixXMLNamespace(t *testing.T) {
+	doc := createTestDoc(t)
+	elem, err := doc.CreateElementNS("http://www.w3.org/XML/1998/namespace", "xml:lang")
+	if err != nil {
+		t.Fatalf("CreateElementNS failed: %v", err)
+	}
+	if err := elem.SetPrefix("xml"); err != nil {
+		t.Errorf("SetPrefix(\"xml\") failed: %v", err)
+	}
+	if elem.Prefix() != "xml" || elem.LocalName() != "lang" {
+		t.Errorf("unexpected name %q:%q", elem.Prefix(), elem.LocalName())
+	}
+
+	other, _ := doc.CreateElementNS("urn:other", "p:lang")
+	if err := other.SetPrefix("xml"); err == nil || !strings.HasPrefix(err.Error(), "NamespaceError") {
+		t.Errorf("expected NamespaceError outside the XML namespace, got %v", err)
+	}
+}
+
+func TestAdoptNodeUnsupportedTypes(t *testing.T) {
+	doc := createTestDoc(t)
+	other := createTestDoc(t)
+
+	if _, err := doc.AdoptNode(other); err == nil || !strings.HasPrefix(err.Error(), "NotSupportedError") {
+		t.Errorf("adopting a Document: expected NotSupportedError, got %v", err)
+	}
+	doctype, _ := xmldom.NewDOMImplementation().CreateDocumentType("html", "", "")
+	if _, err := doc.AdoptNode(doctype); err == nil || !strings.HasPrefix(err.Error(), "NotSupportedError") {
+		t.Errorf("adopting a DocumentType: expected NotSupportedError, got %v", err)
+	}
+
+	elem, _ := other.CreateElement("e")
+	elem.SetAttribute("a", "1")
+	attr := elem.GetAttributeNode("a")
+	for _, n := range []xmldom.Node{elem, other.CreateTextNode("t"), other.CreateComment("c"), other.CreateDocumentFragment(), attr} {
+		adopted, err := doc.AdoptNode(n)
+		if err != nil {
+			t.Errorf("adopting %s failed: %v", n.NodeName(), err)
+			continue
+		}
+		if adopted.OwnerDocument() != doc {
+			t.Errorf("adopted %s should belong to the new document", n.NodeName())
+		}
+	}
+	if elem.HasAttribute("a") || attr.OwnerElement() != nil {
+		t.Errorf("an adopted attribute should be removed from its owner element")
+	}
+}
+
+func TestRenameNodeQualifiedName(t *testing.T) {
+	doc := createTestDoc(t)
+	elem, _ := doc.CreateElement("old")
+
+	renamed, err := doc.RenameNode(elem, "urn:p", "p:foo")
+	if err != nil {
+		t.Fatalf("RenameNode failed: %v", err)
+	}
+	if renamed.NodeName() != "p:foo" || renamed.Prefix() != "p" || renamed.LocalName() != "foo" || renamed.NamespaceURI() != "urn:p" {
+		t.Errorf("got name %q, prefix %q, local name %q, namespace %q",
+			renamed.NodeName(), renamed.Prefix(), renamed.LocalName(), renamed.NamespaceURI())
+	}
+
+	elem.SetAttribute("a", "1")
+	attr := elem.GetAttributeNode("a")
+	if _, err := doc.RenameNode(attr, "urn:q", "q:b"); err != nil {
+		t.Fatalf("RenameNode(attr) failed: %v", err)
+	}
+	if attr.Prefix() != "q" || attr.LocalName() != "b" {
+		t.Errorf("attribute prefix %q, local name %q", attr.Prefix(), attr.LocalName())
+	}
+
+	for _, tc := range []struct {
+		namespaceURI, qualifiedName xmldom.DOMString
+		code                        string
+	}{
+		{"urn:p", "1bad", "InvalidCharacterError"},
+		{"", "p:foo", "NamespaceError"},
+		{"urn:p", "xml:foo", "NamespaceError"},
+		{"http://www.w3.org/2000/xmlns/", "foo", "NamespaceError"},
+	} {
+		if _, err := doc.RenameNode(elem, tc.namespaceURI, tc.qualifiedName); err == nil || !strings.HasPrefix(err.Error(), tc.code) {
+			t.Errorf("RenameNode(%q, %q): expected %s, got %v", tc.namespaceURI, tc.qualifiedName, tc.code, err)
+		}
+	}
+}
+
+func TestEachAttribute(t *testing.T) {
+	doc := mustParse(t, `<root id="r"><a id="x" href="#y"/><b><c id="y"/></b></root>`)
+
+	var ids []string
+	doc.EachAttribute(func(owner xmldom.Element, attr xmldom.Attr) {
+		if attr.Name() == "id" {
+			ids = append(ids, string(owner.TagName())+"="+string(attr.Value()))
+		}
+	})
+	if got := strings.Join(ids, ","); got != "root=r,a=x,c=y" {
+		t.Errorf("ids = %s, want root=r,a=x,c=y", got)
+	}
+
+	// Starting from an element includes its own attributes, and fn may
+	// modify the tree
+	b := doc.DocumentElement().LastChild()
+	count := 0
+	b.EachAttribute(func(owner xmldom.Element, attr xmldom.Attr) {
+		count++
+		owner.SetAttribute("seen", "yes")
+	})
+	if count != 1 {
+		t.Errorf("expected 1 attribute under <b>, got %d", count)
+	}
+}
+
+func TestRenameNodeUpdatesListsAndIds(t *testing.T) {
+	doc := mustParse(t, `<root><a id="first"/><b/></root>`)
+	bs := doc.GetElementsByTagName("b")
+	if bs.Length() != 1 {
+		t.Fatalf("expected 1 <b>, got %d", bs.Length())
+	}
+
+	a := doc.DocumentElement().FirstChild()
+	if _, err := doc.RenameNode(a, "", "b"); err != nil {
+		t.Fatalf("RenameNode failed: %v", err)
+	}
+	if bs.Length() != 2 {
+		t.Errorf("live list for the new name should grow to 2, got %d", bs.Length())
+	}
+	if got := doc.GetElementById("first"); got == nil || got.TagName() != "b" {
+		t.Errorf("renamed element should still be found by id")
+	}
+
+	// Renaming the id attribute itself moves the element in the id index
+	attr := a.(xmldom.Element).GetAttributeNode("id")
+	if _, err := doc.RenameNode(attr, "", "key"); err != nil {
+		t.Fatalf("RenameNode(attr) failed: %v", err)
+	}
+	if doc.GetElementById("first") != nil {
+		t.Errorf("element should no longer be found by its former id")
+	}
+	if _, err := doc.RenameNode(attr, "", "id"); err != nil {
+		t.Fatalf("RenameNode(attr) failed: %v", err)
+	}
+	if doc.GetElementById("first") == nil {
+		t.Errorf("element should be found again once the attribute is named id")
+	}
+}
+
+func TestStructuralHash(t *testing.T) {
+	first := mustParse(t, `<root><item a="1" b="2">text<!--note--></item></root>`)
+	second := mustParse(t, `<root><item b="2" a="1">text<!--note--></item></root>`)
+
+	h1 := first.DocumentElement().StructuralHash()
+	h2 := second.DocumentElement().StructuralHash()
+	if !first.DocumentElement().IsEqualNode(second.DocumentElement()) {
+		t.Fatalf("test documents should be equal")
+	}
+	if h1 != h2 {
+		t.Errorf("equal subtrees should hash the same: %x != %x", h1, h2)
+	}
+	if h := first.DocumentElement().StructuralHash(); h != h1 {
+		t.Errorf("hash should be stable: %x != %x", h, h1)
+	}
+
+	item := second.DocumentElement().FirstChild().(xmldom.Element)
+	if err := item.SetAttribute("b", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if h := second.DocumentElement().StructuralHash(); h == h1 {
+		t.Errorf("changing an attribute should change the hash")
+	}
+
+	if err := item.SetAttribute("b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	item.FirstChild().SetTextContent("other")
+	if h := second.DocumentElement().StructuralHash(); h == h1 {
+		t.Errorf("changing text should change the hash")
+	}
+}
+
+func TestAdoptNodeMovesIds(t *testing.T) {
+	src := mustParse(t, `<root><section id="s1"><para id="p1">text</para></section></root>`)
+	dst := mustParse(t, `<other/>`)
+
+	section := src.GetElementById("s1")
+	if section == nil {
+		t.Fatal("section not found in source")
+	}
+	adopted, err := dst.AdoptNode(section)
+	if err != nil {
+		t.Fatalf("AdoptNode failed: %v", err)
+	}
+	if _, err := dst.DocumentElement().AppendChild(adopted); err != nil {
+		t.Fatalf("AppendChild failed: %v", err)
+	}
+
+	for _, id := range []xmldom.DOMString{"s1", "p1"} {
+		if src.GetElementById(id) != nil {
+			t.Errorf("source document should no longer find %q", id)
+		}
+		if got := dst.GetElementById(id); got == nil {
+			t.Errorf("destination document should find %q", id)
+		} else if got.OwnerDocument() != dst {
+			t.Errorf("element %q should be owned by the destination", id)
+		}
+	}
+}
+
+func TestNormalizedTextContent(t *testing.T) {
+	doc := mustParse(t, "<p>\n    Hello,\n    <b>  big </b>\n\tworld!  \n</p>")
+	p := doc.DocumentElement()
+
+	if got, want := p.NormalizedTextContent(), xmldom.DOMString("Hello, big world!"); got != want {
+		t.Errorf("NormalizedTextContent() = %q, want %q", got, want)
+	}
+	if raw := p.TextContent(); raw == p.NormalizedTextContent() {
+		t.Errorf("TextContent should keep the original whitespace, got %q", raw)
+	}
+}
+
+func TestImportNodeIndexesIds(t *testing.T) {
+	src := mustParse(t, `<root><section id="s1"><para id="p1">text</para></section></root>`)
+	dst := mustParse(t, `<other/>`)
+
+	imported, err := dst.ImportNode(src.GetElementById("s1"), true)
+	if err != nil {
+		t.Fatalf("ImportNode failed: %v", err)
+	}
+	if _, err := dst.DocumentElement().AppendChild(imported); err != nil {
+		t.Fatalf("AppendChild failed: %v", err)
+	}
+
+	for _, id := range []xmldom.DOMString{"s1", "p1"} {
+		got := dst.GetElementById(id)
+		if got == nil {
+			t.Errorf("destination document should find %q", id)
+			continue
+		}
+		if got.OwnerDocument() != dst {
+			t.Errorf("element %q should be the imported copy", id)
+		}
+		if src.GetElementById(id) == nil {
+			t.Errorf("source document should still find %q", id)
+		}
+	}
+}
+
+func TestRangeIntersects(t *testing.T) {
+	doc := mustParse(t, `<p>0123456789</p>`)
+	text := doc.DocumentElement().FirstChild()
+	span := func(start, end uint32) xmldom.Range {
+		r := doc.CreateRange()
+		r.SetStart(text, start)
+		r.SetEnd(text, end)
+		return r
+	}
+
+	tests := []struct {
+		name       string
+		a, b       xmldom.Range
+		intersects bool
+	}{
+		{"overlapping", span(1, 5), span(3, 8), true},
+		{"contained", span(1, 8), span(3, 4), true},
+		{"identical", span(2, 6), span(2, 6), true},
+		{"adjacent", span(1, 4), span(4, 8), false},
+		{"disjoint", span(1, 3), span(5, 8), false},
+		{"collapsed inside", span(1, 5), span(3, 3), true},
+		{"collapsed at boundary", span(1, 5), span(5, 5), true},
+		{"collapsed outside", span(1, 5), span(7, 7), false},
+		{"collapsed at same point", span(4, 4), span(4, 4), true},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Intersects(tt.b); got != tt.intersects {
+			t.Errorf("%s: a.Intersects(b) = %v, want %v", tt.name, got, tt.intersects)
+		}
+		if got := tt.b.Intersects(tt.a); got != tt.intersects {
+			t.Errorf("%s: b.Intersects(a) = %v, want %v", tt.name, got, tt.intersects)
+		}
+	}
+
+	if span(1, 5).Intersects(nil) {
+		t.Errorf("a nil range should not intersect")
+	}
+}
+
+func TestCloneNodeKeepsConcreteType(t *testing.T) {
+	doc := mustParse(t, `<root a="1">text<!--note--><![CDATA[raw]]><?target some data?><child/></root>`)
+	root := doc.DocumentElement()
+
+	elemClone, ok := root.CloneNode(true).(xmldom.Element)
+	if !ok {
+		t.Fatalf("element clone is %T, want an Element", root.CloneNode(true))
+	}
+	if attr := elemClone.GetAttributeNode("a"); attr == nil || attr.OwnerElement() != elemClone {
+		t.Errorf("cloned attribute should belong to the cloned element")
+	}
+
+	var textNode xmldom.Node
+	for child := elemClone.FirstChild(); child != nil; child = child.NextSibling() {
+		switch child.NodeType() {
+		case xmldom.TEXT_NODE:
+			textNode = child
+		case xmldom.COMMENT_NODE:
+			if _, ok := child.(xmldom.Comment); !ok {
+				t.Errorf("comment clone is %T, want a Comment", child)
+			}
+		case xmldom.CDATA_SECTION_NODE:
+			if _, ok := child.(xmldom.CDATASection); !ok {
+				t.Errorf("CDATA clone is %T, want a CDATASection", child)
+			}
+		case xmldom.PROCESSING_INSTRUCTION_NODE:
+			pi, ok := child.(xmldom.ProcessingInstruction)
+			if !ok {
+				t.Errorf("processing instruction clone is %T, want a ProcessingInstruction", child)
+			} else if pi.Target() != "target" || pi.Data() != "some data" {
+				t.Errorf("processing instruction clone = %q %q, want target and data copied", pi.Target(), pi.Data())
+			}
+		case xmldom.ELEMENT_NODE:
+			if _, ok := child.(xmldom.Element); !ok {
+				t.Errorf("child element clone is %T, want an Element", child)
+			}
+		}
+	}
+
+	text, ok := textNode.(xmldom.Text)
+	if !ok {
+		t.Fatalf("text clone is %T, want a Text", textNode)
+	}
+	if _, err := text.SplitText(2); err != nil {
+		t.Errorf("SplitText on a cloned text node failed: %v", err)
+	}
+
+	attrClone, ok := root.GetAttributeNode("a").CloneNode(false).(xmldom.Attr)
+	if !ok {
+		t.Errorf("attribute clone should be an Attr")
+	} else if attrClone.Value() != "1" || attrClone.OwnerElement() != nil {
+		t.Errorf("attribute clone = %q owned by %v, want a detached copy", attrClone.Value(), attrClone.OwnerElement())
+	}
+
+	frag := doc.CreateDocumentFragment()
+	frag.AppendChild(doc.CreateTextNode("x"))
+	fragClone, ok := frag.CloneNode(true).(xmldom.DocumentFragment)
+	if !ok {
+		t.Fatalf("fragment clone should be a DocumentFragment")
+	}
+	if fragClone.FirstChild() == nil || fragClone.FirstChild().NodeValue() != "x" {
+		t.Errorf("fragment clone should copy its children")
+	}
+}
+
+func TestDocumentCloneNode(t *testing.T) {
+	doc := mustParse(t, `<!DOCTYPE doc [<!ENTITY co "Acme">]><doc><item id="i1">&co;</item><item id="i2"/></doc>`)
+
+	clone, ok := doc.CloneNode(true).(xmldom.Document)
+	if !ok {
+		t.Fatalf("clone is %T, want a Document", doc.CloneNode(true))
+	}
+	if clone == doc {
+		t.Fatal("clone should be a new document")
+	}
+	if dt := clone.Doctype(); dt == nil || dt.Name() != "doc" || dt.Entities().GetNamedItem("co") == nil {
+		t.Errorf("doctype with its entities should be copied")
+	} else if dt == doc.Doctype() || dt.OwnerDocument() != clone {
+		t.Errorf("doctype should be a copy owned by the clone")
+	}
+
+	root := clone.DocumentElement()
+	if root == nil || !root.IsEqualNode(doc.DocumentElement()) {
+		t.Fatalf("document element should be an equal copy")
+	}
+	if root == doc.DocumentElement() || root.OwnerDocument() != clone {
+		t.Errorf("document element should be a copy owned by the clone")
+	}
+
+	item := clone.GetElementById("i1")
+	if item == nil || item.OwnerDocument() != clone {
+		t.Fatalf("clone should index the ids of its own elements")
+	}
+
+	// Edits to the clone leave the original alone
+	item.SetAttribute("id", "changed")
+	if doc.GetElementById("i1") == nil || doc.GetElementById("changed") != nil {
+		t.Errorf("changing the clone should not affect the original id index")
+	}
+	if clone.GetElementById("changed") == nil {
+		t.Errorf("clone id index should follow its own edits")
+	}
+
+	shallow, ok := doc.CloneNode(false).(xmldom.Document)
+	if !ok || shallow.FirstChild() != nil || shallow.DocumentElement() != nil {
+		t.Errorf("a shallow clone should be an empty document")
+	}
+}
+
+func TestInnerXML(t *testing.T) {
+	doc := mustParse(t, `<r xmlns="urn:d" xmlns:x="urn:x"><a>1 &lt; 2 <x:b x:k="v">t</x:b><!--note--><c xmlns="urn:other"/></a></r>`)
+	a := doc.DocumentElement().FirstChild().(xmldom.Element)
+	cdata, _ := doc.CreateCDATASection("<raw>")
+	a.AppendChild(cdata)
+	pi, _ := doc.CreateProcessingInstruction("target", "data")
+	a.AppendChild(pi)
+
+	got, err := a.InnerXML()
+	if err != nil {
+		t.Fatalf("InnerXML failed: %v", err)
+	}
+	want := `1 &lt; 2 <x:b x:k="v">t</x:b><!--note--><c xmlns="urn:other"></c><![CDATA[<raw>]]><?target data?>`
+	if got != want {
+		t.Errorf("InnerXML() = %s, want %s", got, want)
+	}
+
+	empty, _ := doc.CreateElement("empty")
+	if got, err := empty.InnerXML(); err != nil || got != "" {
+		t.Errorf("InnerXML() of an empty element = %q, %v, want empty", got, err)
+	}
+}
+
+func TestSetInnerXML(t *testing.T) {
+	doc := mustParse(t, `<root xmlns:x="urn:x"><box><old id="old">gone</old></box></root>`)
+	box := doc.DocumentElement().FirstChild().(xmldom.Element)
+
+	if err := box.SetInnerXML(`text <item id="new"><x:sub/></item><!--c-->`); err != nil {
+		t.Fatalf("SetInnerXML failed: %v", err)
+	}
+	if got, _ := box.InnerXML(); got != `text <item id="new"><x:sub></x:sub></item><!--c-->` {
+		t.Errorf("InnerXML() after SetInnerXML = %s", got)
+	}
+	item := doc.GetElementById("new")
+	if item == nil || item.OwnerDocument() != doc || item.ParentNode() != xmldom.Node(box) {
+		t.Fatalf("new element should be indexed and owned by the document")
+	}
+	if sub := item.FirstChild(); sub.NamespaceURI() != "urn:x" {
+		t.Errorf("prefix in scope at the element should resolve, got namespace %q", sub.NamespaceURI())
+	}
+	if doc.GetElementById("old") != nil {
+		t.Errorf("removed element should no longer be found by id")
+	}
+
+	err := box.SetInnerXML(`<unclosed>`)
+	if err == nil || !strings.HasPrefix(err.Error(), "SyntaxError") {
+		t.Errorf("malformed markup should return SyntaxError, got %v", err)
+	}
+	if got, _ := box.InnerXML(); got != `text <item id="new"><x:sub></x:sub></item><!--c-->` {
+		t.Errorf("element should be unchanged after a parse error, got %s", got)
+	}
+}
+
+func TestChangeNamespace(t *testing.T) {
+	doc := mustParse(t, `<root><p:doc xmlns:p="urn:v1"><p:item p:ref="1"><p:leaf/><keep/></p:item></p:doc></root>`)
+	top := doc.DocumentElement().FirstChild().(xmldom.Element)
+
+	if n := doc.GetElementsByTagNameNS("urn:v1", "*").Length(); n != 3 {
+		t.Fatalf("expected 3 elements in urn:v1 before the change, got %d", n)
+	}
+	v2 := doc.GetElementsByTagNameNS("urn:v2", "*")
+
+	if err := top.ChangeNamespace("urn:v1", "urn:v2"); err != nil {
+		t.Fatalf("ChangeNamespace failed: %v", err)
+	}
+	if n := doc.GetElementsByTagNameNS("urn:v1", "*").Length(); n != 0 {
+		t.Errorf("no elements should remain in urn:v1, got %d", n)
+	}
+	if v2.Length() != 3 {
+		t.Errorf("live list for urn:v2 should hold 3 elements, got %d", v2.Length())
+	}
+	item := doc.GetElementsByTagNameNS("urn:v2", "item").Item(0).(xmldom.Element)
+	if item.GetAttributeNS("urn:v2", "ref") != "1" {
+		t.Errorf("attribute should move to urn:v2")
+	}
+	if item.LocalName() != "item" || item.NamespaceURI() != "urn:v2" {
+		t.Errorf("local name should be kept, got %q in %q", item.LocalName(), item.NamespaceURI())
+	}
+	if keep := item.LastChild(); keep.NamespaceURI() != "" {
+		t.Errorf("elements in other namespaces should be left alone, got %q", keep.NamespaceURI())
+	}
+
+	if err := top.ChangeNamespace("urn:v2", "http://www.w3.org/2000/xmlns/"); err == nil || !strings.HasPrefix(err.Error(), "NamespaceError") {
+		t.Errorf("moving into the xmlns namespace should fail with NamespaceError, got %v", err)
+	}
+
+	plain := mustParse(t, `<r a="1"><c b="2"/></r>`)
+	if err := plain.DocumentElement().ChangeNamespace("", "urn:n"); err != nil {
+		t.Fatalf("ChangeNamespace out of no namespace failed: %v", err)
+	}
+	for _, e := range []xmldom.Element{plain.DocumentElement(), plain.DocumentElement().FirstChild().(xmldom.Element)} {
+		if e.NamespaceURI() != "urn:n" {
+			t.Errorf("<%s> should move to urn:n, got %q", e.TagName(), e.NamespaceURI())
+		}
+		attr := e.Attributes().Item(0)
+		if attr.NamespaceURI() != "" {
+			t.Errorf("unprefixed attribute %s should stay in no namespace, got %q", attr.NodeName(), attr.NamespaceURI())
+		}
+	}
+}
+
+func TestOuterXML(t *testing.T) {
+	doc := createTestDoc(t)
+	item, _ := doc.CreateElementNS("urn:x", "x:item")
+	item.SetAttributeNS("urn:x", "x:k", "v")
+	item.SetAttribute("plain", "p&q")
+	item.AppendChild(doc.CreateTextNode("text"))
+	child, _ := doc.CreateElementNS("urn:d", "child")
+	item.AppendChild(child)
+	item.AppendChild(doc.CreateComment("c"))
+
+	got, err := item.OuterXML()
+	if err != nil {
+		t.Fatalf("OuterXML failed: %v", err)
+	}
+	want := `<x:item xmlns:x="urn:x" x:k="v" plain="p&amp;q">text<child xmlns="urn:d"></child><!--c--></x:item>`
+	if got != want {
+		t.Errorf("OuterXML() = %s, want %s", got, want)
+	}
+
+	reparsed, err := xmldom.UnmarshalDOM([]byte(got))
+	if err != nil {
+		t.Fatalf("OuterXML output does not parse: %v", err)
+	}
+	back := reparsed.DocumentElement()
+	if back.NamespaceURI() != "urn:x" || back.GetAttributeNS("urn:x", "k") != "v" || back.GetAttribute("plain") != "p&q" {
+		t.Errorf("round trip lost names or values: %s", got)
+	}
+	if c := back.FirstChild().NextSibling(); c.NamespaceURI() != "urn:d" || c.LocalName() != "child" {
+		t.Errorf("round trip lost the child's namespace: %s", got)
+	}
+
+	// An element taken from a parsed document declares the namespaces its
+	// ancestors did
+	parsed := mustParse(t, `<root xmlns="urn:d" xmlns:x="urn:x"><x:item x:k="v"><child/></x:item></root>`)
+	out, err := parsed.DocumentElement().FirstChild().(xmldom.Element).OuterXML()
+	if err != nil {
+		t.Fatalf("OuterXML failed: %v", err)
+	}
+	if strings.HasPrefix(out, "<?xml") {
+		t.Errorf("OuterXML should not write an XML declaration: %s", out)
+	}
+	again, err := xmldom.UnmarshalDOM([]byte(out))
+	if err != nil {
+		t.Fatalf("OuterXML output %s does not parse: %v", out, err)
+	}
+	back = again.DocumentElement()
+	if back.NamespaceURI() != "urn:x" || back.GetAttributeNS("urn:x", "k") != "v" || back.FirstChild().NamespaceURI() != "urn:d" {
+		t.Errorf("round trip of %s lost namespaces", out)
+	}
+}
+
+func TestEffectiveAttribute(t *testing.T) {
+	doc := mustParse(t, `<root><item/><item kind="special"/></root>`)
+	doc.SetAttributeDefault("item", "kind", "plain")
+
+	defaulted := doc.DocumentElement().FirstChild().(xmldom.Element)
+	explicit := defaulted.NextSibling().(xmldom.Element)
+
+	if value, specified := defaulted.EffectiveAttribute("kind"); value != "plain" || specified {
+		t.Errorf("defaulted attribute = %q, %v; want %q, false", value, specified, "plain")
+	}
+	if value, specified := explicit.EffectiveAttribute("kind"); value != "special" || !specified {
+		t.Errorf("explicit attribute = %q, %v; want %q, true", value, specified, "special")
+	}
+	if value, specified := defaulted.EffectiveAttribute("other"); value != "" || specified {
+		t.Errorf("attribute without default = %q, %v; want empty, false", value, specified)
+	}
+	if defaulted.HasAttribute("kind") {
+		t.Errorf("a default should not be added to the element")
+	}
+	if value, ok := doc.AttributeDefault("item", "kind"); value != "plain" || !ok {
+		t.Errorf("AttributeDefault = %q, %v; want %q, true", value, ok, "plain")
+	}
+}
+
+func TestHasMixedContent(t *testing.T) {
+	doc := mustParse(t, `<root>
+  <elements>
+    <a/>
+    <b/>
+  </elements>
+  <text>just text</text>
+  <mixed>Hello <b>big</b> world</mixed>
+  <cdata><b/><![CDATA[data]]></cdata>
+  <empty/>
+</root>`)
+
+	tests := map[string]bool{
+		"elements": false,
+		"text":     false,
+		"mixed":    true,
+		"cdata":    true,
+		"empty":    false,
+	}
+	for name, want := range tests {
+		e := doc.GetElementsByTagName(xmldom.DOMString(name)).Item(0).(xmldom.Element)
+		if got := e.HasMixedContent(); got != want {
+			t.Errorf("<%s>.HasMixedContent() = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestContentParts(t *testing.T) {
+	describe := func(parts []xmldom.ContentPart) string {
+		var out []string
+		for _, part := range parts {
+			if part.Element != nil {
+				out = append(out, "<"+string(part.Element.NodeName())+">")
+			} else {
+				out = append(out, strconv.Quote(string(part.Text)))
+			}
+		}
+		return strings.Join(out, " ")
+	}
+
+	doc := mustParse(t, `<p>a<b>x</b>c</p>`)
+	if got, want := describe(doc.DocumentElement().ContentParts()), `"a" <b> "c"`; got != want {
+		t.Errorf("ContentParts() = %s, want %s", got, want)
+	}
+
+	// Runs are coalesced across node boundaries and skip comments
+	p := doc.DocumentElement()
+	p.InsertBefore(doc.CreateTextNode("1"), p.FirstChild())
+	cdata, _ := doc.CreateCDATASection("2")
+	p.AppendChild(doc.CreateComment("note"))
+	i, _ := doc.CreateElement("i")
+	p.AppendChild(cdata)
+	p.AppendChild(i)
+	if got, want := describe(p.ContentParts()), `"1a" <b> "c2" <i>`; got != want {
+		t.Errorf("ContentParts() = %s, want %s", got, want)
+	}
+
+	empty, _ := doc.CreateElement("empty")
+	if parts := empty.ContentParts(); len(parts) != 0 {
+		t.Errorf("ContentParts() of an empty element = %s, want none", describe(parts))
+	}
+}
+
+func TestSetIdAttributeName(t *testing.T) {
+	doc := mustParse(t, `<config><entry key="alpha" id="one"/><group><entry key="beta"/></group></config>`)
+
+	doc.SetIdAttributeName("key")
+	alpha := doc.GetElementById("alpha")
+	if alpha == nil || alpha.GetAttribute("id") != "one" {
+		t.Fatalf("GetElementById(alpha) = %v, want the first entry", alpha)
+	}
+	if beta := doc.GetElementById("beta"); beta == nil || beta.ParentNode().NodeName() != "group" {
+		t.Errorf("GetElementById(beta) = %v, want the nested entry", beta)
+	}
+	if doc.GetElementById("one") != nil {
+		t.Errorf("id attribute should no longer be indexed")
+	}
+
+	entry, _ := doc.CreateElement("entry")
+	entry.SetAttribute("key", "gamma")
+	doc.DocumentElement().AppendChild(entry)
+	if doc.GetElementById("gamma") != entry {
+		t.Errorf("newly keyed element should be found by its key")
+	}
+	alpha.SetAttribute("key", "delta")
+	if doc.GetElementById("alpha") != nil || doc.GetElementById("delta") != alpha {
+		t.Errorf("changing a key should move the element in the index")
+	}
+
+	doc.SetIdAttributeName("")
+	if doc.GetElementById("one") != alpha || doc.GetElementById("delta") != nil {
+		t.Errorf("clearing the name should index id attributes again")
+	}
+
+	doc = mustParse(t, `<r><a key="alpha"/><b id="alpha"/></r>`)
+	doc.SetIdAttributeName("key")
+	got, err := doc.QuerySelector("#alpha")
+	if err != nil {
+		t.Fatalf("QuerySelector(#alpha) failed: %v", err)
+	}
+	if got == nil || got.TagName() != "a" {
+		t.Errorf("QuerySelector(#alpha) = %v, want <a>", got)
+	}
+}
+
+func TestGetAttributeNames(t *testing.T) {
+	doc := createTestDoc(t)
+	elem, _ := doc.CreateElement("e")
+	elem.SetAttribute("b", "1")
+	elem.SetAttributeNS("http://www.w3.org/2000/xmlns/", "xmlns:p", "urn:p")
+	elem.SetAttributeNS("urn:p", "p:a", "2")
+	elem.SetAttribute("c", "3")
+
+	got := elem.GetAttributeNames()
+	want := []xmldom.DOMString{"b", "xmlns:p", "p:a", "c"}
+	if len(got) != len(want) {
+		t.Fatalf("GetAttributeNames() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("GetAttributeNames()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	elem.RemoveAttribute("b")
+	if names := elem.GetAttributeNames(); len(names) != 3 || names[0] != "xmlns:p" {
+		t.Errorf("after removing b, GetAttributeNames() = %v", names)
+	}
+
+	empty, _ := doc.CreateElement("empty")
+	if names := empty.GetAttributeNames(); names == nil || len(names) != 0 {
+		t.Errorf("GetAttributeNames() on an element without attributes = %#v, want empty", names)
+	}
+}
+
+func TestGetElementsByName(t *testing.T) {
+	doc := mustParse(t, `<form><input name="email"/><group><input name="email" type="confirm"/><input name="phone"/></group></form>`)
+
+	list := doc.GetElementsByName("email")
+	if list.Length() != 2 {
+		t.Fatalf("GetElementsByName(email).Length() = %d, want 2", list.Length())
+	}
+	if second := list.Item(1).(xmldom.Element); second.GetAttribute("type") != "confirm" {
+		t.Errorf("second match should be the nested confirm input")
+	}
+
+	// The list tracks insertions and attribute changes
+	extra, _ := doc.CreateElement("select")
+	extra.SetAttribute("name", "email")
+	doc.DocumentElement().AppendChild(extra)
+	if list.Length() != 3 || list.Item(2) != xmldom.Node(extra) {
+		t.Errorf("after appending, Length() = %d, want 3 with the new element last", list.Length())
+	}
+	list.Item(0).(xmldom.Element).SetAttribute("name", "address")
+	if list.Length() != 2 {
+		t.Errorf("after renaming, Length() = %d, want 2", list.Length())
+	}
+
+	if doc.GetElementsByName("missing").Length() != 0 {
+		t.Errorf("GetElementsByName(missing) should be empty")
+	}
+}
+
+func TestGetElementsByTagNames(t *testing.T) {
+	doc := mustParse(t, `<scxml><state id="a"><final id="b"/><parallel id="c"><state id="d"/></parallel></state><transition/><final id="e"/></scxml>`)
+	root := doc.DocumentElement()
+
+	list := root.GetElementsByTagNames("state", "parallel", "final")
+	var ids []string
+	for i := uint(0); i < list.Length(); i++ {
+		ids = append(ids, string(list.Item(i).(xmldom.Element).GetAttribute("id")))
+	}
+	if got := strings.Join(ids, ","); got != "a,b,c,d,e" {
+		t.Errorf("GetElementsByTagNames() ids = %s, want a,b,c,d,e", got)
+	}
+
+	extra, _ := doc.CreateElement("parallel")
+	root.FirstChild().AppendChild(extra)
+	if list.Length() != 6 || list.Item(4) != xmldom.Node(extra) {
+		t.Errorf("new element should appear in document order, Length() = %d", list.Length())
+	}
+
+	if root.GetElementsByTagNames().Length() != 0 {
+		t.Errorf("no names should match nothing")
+	}
+}
+
+func TestCanonicalAttributeString(t *testing.T) {
+	doc := createTestDoc(t)
+	first, _ := doc.CreateElement("e")
+	first.SetAttribute("b", "x<y")
+	first.SetAttributeNS("urn:z", "z:a", "1")
+	first.SetAttribute("a", "tab\there")
+	first.SetAttributeNS("http://www.w3.org/2000/xmlns/", "xmlns:z", "urn:z")
+	first.SetAttribute("xmlns", "urn:d")
+
+	second, _ := doc.CreateElement("e")
+	second.SetAttribute("xmlns", "urn:d")
+	second.SetAttribute("a", "tab\there")
+	second.SetAttributeNS("http://www.w3.org/2000/xmlns/", "xmlns:z", "urn:z")
+	second.SetAttributeNS("urn:z", "z:a", "1")
+	second.SetAttribute("b", "x<y")
+
+	want := `xmlns="urn:d" xmlns:z="urn:z" a="tab&#x9;here" b="x&lt;y" z:a="1"`
+	if got := first.CanonicalAttributeString(); got != want {
+		t.Errorf("CanonicalAttributeString() = %s, want %s", got, want)
+	}
+	if first.CanonicalAttributeString() != second.CanonicalAttributeString() {
+		t.Errorf("insertion order should not change the canonical string")
+	}
+
+	// Decoded namespaced attributes are written with their in-scope prefix
+	parsed := mustParse(t, `<e xmlns="urn:d" xmlns:z="urn:z" b='x&lt;y' z:c="1" a="tab&#9;here"/>`)
+	want = `xmlns="urn:d" xmlns:z="urn:z" a="tab&#x9;here" b="x&lt;y" z:c="1"`
+	if got := parsed.DocumentElement().CanonicalAttributeString(); got != want {
+		t.Errorf("decoded CanonicalAttributeString() = %s, want %s", got, want)
+	}
+}
+
+func TestTextWholeText(t *testing.T) {
+	doc := createTestDoc(t)
+	p, _ := doc.CreateElement("p")
+	first := doc.CreateTextNode("Hello, ")
+	p.AppendChild(first)
+	cdata, _ := doc.CreateCDATASection("big ")
+	p.AppendChild(cdata)
+	p.AppendChild(doc.CreateTextNode("world"))
+	b, _ := doc.CreateElement("b")
+	p.AppendChild(b)
+	tail := doc.CreateTextNode("!")
+	p.AppendChild(tail)
+
+	second, err := first.SplitText(3)
+	if err != nil {
+		t.Fatalf("SplitText() failed: %v", err)
+	}
+	for _, node := range []xmldom.Text{first, second, cdata} {
+		if got := node.WholeText(); got != "Hello, big world" {
+			t.Errorf("WholeText() of %q = %q, want %q", node.Data(), got, "Hello, big world")
+		}
+	}
+	if got := tail.WholeText(); got != "!" {
+		t.Errorf("WholeText() after an element = %q, want %q", got, "!")
+	}
+	if p.ChildNodes().Length() != 6 {
+		t.Errorf("WholeText() should not change the tree, got %d children", p.ChildNodes().Length())
+	}
+}
+
+func TestTextReplaceWholeText(t *testing.T) {
+	doc := createTestDoc(t)
+	p, _ := doc.CreateElement("p")
+	b, _ := doc.CreateElement("b")
+	p.AppendChild(b)
+	first := doc.CreateTextNode("Hello, ")
+	p.AppendChild(first)
+	cdata, _ := doc.CreateCDATASection("big ")
+	p.AppendChild(cdata)
+	last := doc.CreateTextNode("world")
+	p.AppendChild(last)
+	i, _ := doc.CreateElement("i")
+	p.AppendChild(i)
+	children := p.ChildNodes()
+
+	got, err := cdata.ReplaceWholeText("Goodbye")
+	if err != nil {
+		t.Fatalf("ReplaceWholeText() failed: %v", err)
+	}
+	if got != xmldom.Text(cdata) {
+		t.Errorf("ReplaceWholeText() should return the node it was called on")
+	}
+	if children.Length() != 3 || children.Item(1) != xmldom.Node(cdata) {
+		t.Fatalf("children after replace = %d, want b, the CDATA section, i", children.Length())
+	}
+	if cdata.PreviousSibling() != xmldom.Node(b) || cdata.NextSibling() != xmldom.Node(i) || i.PreviousSibling() != xmldom.Node(cdata) {
+		t.Errorf("sibling links not updated")
+	}
+	if first.ParentNode() != nil || last.ParentNode() != nil {
+		t.Errorf("adjacent text nodes should be detached")
+	}
+	if p.TextContent() != "Goodbye" || cdata.WholeText() != "Goodbye" {
+		t.Errorf("TextContent() = %q, want %q", p.TextContent(), "Goodbye")
+	}
+
+	got, err = cdata.ReplaceWholeText("")
+	if err != nil || got != nil {
+		t.Fatalf("ReplaceWholeText(\"\") = %v, %v; want nil, nil", got, err)
+	}
+	if children.Length() != 2 || b.NextSibling() != xmldom.Node(i) {
+		t.Errorf("empty content should remove the whole run, got %d children", children.Length())
+	}
+}
+
+func TestReplaceChildren(t *testing.T) {
+	doc := mustParse(t, `<root><old id="o"/>text<keep id="k"/></root>`)
+	root := doc.DocumentElement()
+	keep := doc.GetElementById("k")
+
+	a, _ := doc.CreateElement("a")
+	frag := doc.CreateDocumentFragment()
+	b, _ := doc.CreateElement("b")
+	frag.AppendChild(b)
+	frag.AppendChild(doc.CreateTextNode("tail"))
+
+	if err := root.ReplaceChildren(a, keep, frag); err != nil {
+		t.Fatalf("ReplaceChildren() failed: %v", err)
+	}
+	var names []string
+	for child := root.FirstChild(); child != nil; child = child.NextSibling() {
+		names = append(names, string(child.NodeName()))
+	}
+	if got := strings.Join(names, ","); got != "a,keep,b,#text" {
+		t.Errorf("children = %s, want a,keep,b,#text", got)
+	}
+	if frag.FirstChild() != nil {
+		t.Errorf("fragment should be emptied")
+	}
+	if doc.GetElementById("o") != nil || doc.GetElementById("k") != keep {
+		t.Errorf("id index should drop removed elements and keep reinserted ones")
+	}
+
+	// A failing node leaves the children untouched
+	other := createTestDoc(t)
+	foreign, _ := other.CreateElement("foreign")
+	if err := root.ReplaceChildren(doc.CreateTextNode("x"), foreign); err == nil || !strings.HasPrefix(err.Error(), "WrongDocumentError") {
+		t.Errorf("foreign node: got %v, want WrongDocumentError", err)
+	}
+	if err := a.ReplaceChildren(root); err == nil || !strings.HasPrefix(err.Error(), "HierarchyRequestError") {
+		t.Errorf("ancestor: got %v, want HierarchyRequestError", err)
+	}
+	if root.ChildNodes().Length() != 4 {
+		t.Errorf("failed calls should not change the children, got %d", root.ChildNodes().Length())
+	}
+
+	if err := root.ReplaceChildren(); err != nil || root.HasChildNodes() {
+		t.Errorf("ReplaceChildren() with no nodes should clear, got %v", err)
+	}
+
+	// Documents accept one element and no text
+	second, _ := doc.CreateElement("second")
+	if err := doc.ReplaceChildren(a, second); err == nil {
+		t.Errorf("two document elements should be rejected")
+	}
+	if err := doc.ReplaceChildren(doc.CreateTextNode("x")); err == nil {
+		t.Errorf("text in a document should be rejected")
+	}
+	comment := doc.CreateComment("c")
+	if err := doc.ReplaceChildren(comment, second); err != nil {
+		t.Fatalf("document ReplaceChildren() failed: %v", err)
+	}
+	if doc.DocumentElement() != second || doc.FirstChild() != xmldom.Node(comment) {
+		t.Errorf("document element should be the new element")
+	}
+
+	// The document's own document type can be put back
+	typed := mustParse(t, `<!DOCTYPE r><!--c--><r/>`)
+	doctype, root2 := typed.Doctype(), typed.DocumentElement()
+	if err := typed.ReplaceChildren(doctype, root2); err != nil {
+		t.Fatalf("ReplaceChildren() with the document's doctype failed: %v", err)
+	}
+	if typed.Doctype() != doctype || typed.FirstChild() != xmldom.Node(root2) || typed.LastChild() != xmldom.Node(root2) {
+		t.Errorf("document should keep its doctype and hold the element only")
+	}
+	fresh, _ := xmldom.NewDOMImplementation().CreateDocumentType("r", "", "")
+	if err := typed.ReplaceChildren(fresh, root2); err != nil || typed.Doctype() != fresh {
+		t.Errorf("ReplaceChildren() with a new doctype = %v, doctype %v", err, typed.Doctype())
+	}
+}
+
+func TestNamespaceDeclarations(t *testing.T) {
+	doc := mustParse(t, `<root xmlns:outer="urn:outer"><item xmlns="urn:default" xmlns:p="urn:p" p:a="1" b="2"/></root>`)
+	item := doc.DocumentElement().FirstChild().(xmldom.Element)
+
+	got := item.NamespaceDeclarations()
+	want := map[xmldom.DOMString]xmldom.DOMString{"": "urn:default", "p": "urn:p"}
+	if len(got) != len(want) {
+		t.Fatalf("NamespaceDeclarations() = %v, want %v", got, want)
+	}
+	for prefix, uri := range want {
+		if got[prefix] != uri {
+			t.Errorf("NamespaceDeclarations()[%q] = %q, want %q", prefix, got[prefix], uri)
+		}
+	}
+
+	built, _ := doc.CreateElement("built")
+	built.SetAttributeNS("http://www.w3.org/2000/xmlns/", "xmlns:q", "urn:q")
+	if decls := built.NamespaceDeclarations(); len(decls) != 1 || decls["q"] != "urn:q" {
+		t.Errorf("NamespaceDeclarations() of a built element = %v", decls)
+	}
+}
+
+func TestRangeSurroundContentsWithinText(t *testing.T) {
+	doc := mustParse(t, `<p>The quick brown fox</p>`)
+	p := doc.DocumentElement()
+	text := p.FirstChild()
+
+	r := doc.CreateRange()
+	r.SetStart(text, 4)
+	r.SetEnd(text, 9)
+	em, _ := doc.CreateElement("em")
+	if err := r.SurroundContents(em); err != nil {
+		t.Fatalf("SurroundContents failed: %v", err)
+	}
+	out, _ := xmldom.Marshal(p)
+	if want := `<p>The <em>quick</em> brown fox</p>`; string(out) != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+	if p.FirstChild() != text || text.NodeValue() != "The " {
+		t.Errorf("text before the selection should stay in the original node")
+	}
+
+	// Across two adjacent text nodes
+	tail := em.NextSibling().(xmldom.Text)
+	second, err := tail.SplitText(4)
+	if err != nil {
+		t.Fatalf("SplitText failed: %v", err)
+	}
+	r.SetStart(tail, 1)
+	r.SetEnd(second, 2)
+	strong, _ := doc.CreateElement("strong")
+	if err := r.SurroundContents(strong); err != nil {
+		t.Fatalf("SurroundContents across text nodes failed: %v", err)
+	}
+	out, _ = xmldom.Marshal(p)
+	if want := `<p>The <em>quick</em> <strong>brown</strong> fox</p>`; string(out) != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+}
+
+func TestVariadicMethodsAcceptStrings(t *testing.T) {
+	doc := mustParse(t, `<root><child/></root>`)
+	root := doc.DocumentElement()
+	child := root.FirstChild().(xmldom.Element)
+	b, _ := doc.CreateElement("b")
+
+	if err := root.Append("hello ", b, xmldom.DOMString("!")); err != nil {
+		t.Fatalf("Append() failed: %v", err)
+	}
+	if err := root.Prepend(""); err != nil {
+		t.Fatalf("Prepend() failed: %v", err)
+	}
+	if err := child.Before("<before>"); err != nil {
+		t.Fatalf("Before() failed: %v", err)
+	}
+	if err := child.After("after"); err != nil {
+		t.Fatalf("After() failed: %v", err)
+	}
+
+	// The empty string still produces a Text node
+	if first := root.FirstChild(); first.NodeType() != xmldom.TEXT_NODE || first.NodeValue() != "" {
+		t.Errorf("Prepend(\"\") should insert an empty Text node, got %v", first)
+	}
+	out, _ := xmldom.Marshal(root)
+	if want := `<root>&lt;before&gt;<child></child>afterhello <b></b>!</root>`; string(out) != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+
+	// Strings work for CharacterData too, and other types are rejected
+	// without changing the tree
+	text := b.NextSibling().(xmldom.Text)
+	if err := text.ReplaceWith("?"); err != nil || root.LastChild().NodeValue() != "?" {
+		t.Errorf("ReplaceWith(\"?\") = %v, last child %q", err, root.LastChild().NodeValue())
+	}
+	count := root.ChildNodes().Length()
+	if err := root.Append("x", 42); err == nil || !strings.HasPrefix(err.Error(), "HierarchyRequestError") {
+		t.Errorf("Append(42) = %v, want HierarchyRequestError", err)
+	}
+	if root.ChildNodes().Length() != count {
+		t.Errorf("a rejected argument should not insert anything")
+	}
+}
+
+// itemIndex is a QueryProvider serving "item" lookups from a prebuilt list
+type itemIndex struct {
+	items xmldom.NodeList
+	calls int
+}
+
+func (p *itemIndex) GetElementsByTagName(root xmldom.Node, name xmldom.DOMString) xmldom.NodeList {
+	p.calls++
+	if name == "item" {
+		return p.items
+	}
+	return nil
+}
+
+func (p *itemIndex) GetElementsByTagNameNS(root xmldom.Node, namespaceURI, localName xmldom.DOMString) xmldom.NodeList {
+	return nil
+}
+
+func TestSetQueryProvider(t *testing.T) {
+	doc := mustParse(t, `<root><item n="1"/><group><item n="2"/></group><other/></root>`)
+	prebuilt := xmldom.NewStaticNodeList([]xmldom.Node{doc.DocumentElement().FirstChild()})
+	provider := &itemIndex{items: prebuilt}
+	doc.SetQueryProvider(provider)
+
+	if got := doc.GetElementsByTagName("item"); got != prebuilt {
+		t.Errorf("GetElementsByTagName(item) should return the provider's list")
+	}
+	if got := doc.DocumentElement().GetElementsByTagName("item"); got != prebuilt {
+		t.Errorf("Element.GetElementsByTagName(item) should return the provider's list")
+	}
+	if provider.calls != 2 {
+		t.Errorf("provider called %d times, want 2", provider.calls)
+	}
+
+	// A nil result falls back to the default live list
+	if got := doc.GetElementsByTagName("other"); got.Length() != 1 {
+		t.Errorf("fallback GetElementsByTagName(other).Length() = %d, want 1", got.Length())
+	}
+	if got := doc.GetElementsByTagNameNS("*", "item"); got.Length() != 2 {
+		t.Errorf("fallback GetElementsByTagNameNS(*, item).Length() = %d, want 2", got.Length())
+	}
+
+	doc.SetQueryProvider(nil)
+	if got := doc.GetElementsByTagName("item"); got.Length() != 2 {
+		t.Errorf("after removing the provider, Length() = %d, want 2", got.Length())
+	}
+}
+
+func TestMoveTo(t *testing.T) {
+	doc := mustParse(t, `<root><a><p id="p">one <b>two</b> three</p></a><c><x/><y/></c></root>`)
+	p := doc.GetElementById("p")
+	c := doc.GetElementsByTagName("c").Item(0).(xmldom.Element)
+	y := c.LastChild()
+
+	// A range from inside "one" to inside "three"
+	inner := doc.CreateRange()
+	inner.SetStart(p.FirstChild(), 2)
+	inner.SetEnd(p.LastChild(), 3)
+	before := inner.ToString()
+	// A range over the children of c
+	outer := doc.CreateRange()
+	outer.SetStart(c, 0)
+	outer.SetEnd(c, 2)
+
+	if err := p.MoveTo(c, y); err != nil {
+		t.Fatalf("MoveTo() failed: %v", err)
+	}
+	if p.ParentNode() != xmldom.Node(c) || p.NextSibling() != y {
+		t.Fatal("node was not moved before refChild")
+	}
+	if got := inner.ToString(); got != before {
+		t.Errorf("range inside the moved subtree = %q, want %q", got, before)
+	}
+	if outer.EndOffset() != 3 {
+		t.Errorf("end offset in the new parent = %d, want 3", outer.EndOffset())
+	}
+	if doc.GetElementById("p") != p {
+		t.Error("GetElementById() lost the moved element")
+	}
+	if a := doc.GetElementsByTagName("a").Item(0); a.HasChildNodes() {
+		t.Error("old parent still has children")
+	}
+
+	// Moving the node back out shifts the range in c back down
+	if err := p.MoveTo(doc.DocumentElement(), nil); err != nil {
+		t.Fatalf("MoveTo() failed: %v", err)
+	}
+	if outer.EndOffset() != 2 {
+		t.Errorf("end offset after moving out = %d, want 2", outer.EndOffset())
+	}
+
+	if err := p.MoveTo(p.FirstChild(), nil); err == nil || !strings.HasPrefix(err.Error(), "HierarchyRequestError") {
+		t.Errorf("moving into a child returned %v, want HierarchyRequestError", err)
+	}
+	if err := doc.MoveTo(p, nil); err == nil || !strings.HasPrefix(err.Error(), "HierarchyRequestError") {
+		t.Errorf("moving the document returned %v, want HierarchyRequestError", err)
+	}
+}
+
+func TestMoveAll(t *testing.T) {
+	doc := mustParse(t, `<root><from><item n="1"/><other/><item n="2"><item n="3"/></item></from><to><last/></to></root>`)
+	root := doc.DocumentElement()
+	from := root.FirstChild().(xmldom.Element)
+	to := root.LastChild().(xmldom.Element)
+
+	items := from.GetElementsByTagName("item")
+	if items.Length() != 3 {
+		t.Fatalf("Length() = %d, want 3", items.Length())
+	}
+	if err := xmldom.MoveAll(items, to, to.FirstChild()); err != nil {
+		t.Fatalf("MoveAll() failed: %v", err)
+	}
+
+	var got []string
+	for child := to.FirstChild(); child != nil; child = child.NextSibling() {
+		got = append(got, string(child.NodeName())+string(child.(xmldom.Element).GetAttribute("n")))
+	}
+	if got, want := strings.Join(got, " "), "item1 item2 item3 last"; got != want {
+		t.Errorf("children of target = %s, want %s", got, want)
+	}
+	if items.Length() != 0 {
+		t.Errorf("live list still has %d items", items.Length())
+	}
+	if from.ChildNodes().Length() != 1 {
+		t.Errorf("source has %d children left, want 1", from.ChildNodes().Length())
+	}
+}
+
+func TestQualifiedNameValidation(t *testing.T) {
+	doc := createTestDoc(t)
+	elem, _ := doc.CreateElementNS("urn:a", "a:elem")
+	doc.AppendChild(elem)
+	for _, name := range []xmldom.DOMString{"a:b:c", ":x", "x:", "a:1b"} {
+		if _, err := doc.CreateElementNS("urn:a", name); err == nil || !strings.HasPrefix(err.Error(), "InvalidCharacterError") {
+			t.Errorf("CreateElementNS(%q) returned %v, want InvalidCharacterError", name, err)
+		}
+		if _, err := doc.CreateAttributeNS("urn:a", name); err == nil || !strings.HasPrefix(err.Error(), "InvalidCharacterError") {
+			t.Errorf("CreateAttributeNS(%q) returned %v, want InvalidCharacterError", name, err)
+		}
+		if err := elem.SetAttributeNS("urn:a", name, "v"); err == nil || !strings.HasPrefix(err.Error(), "InvalidCharacterError") {
+			t.Errorf("SetAttributeNS(%q) returned %v, want InvalidCharacterError", name, err)
+		}
+		if _, err := doc.RenameNode(elem, "urn:a", name); err == nil || !strings.HasPrefix(err.Error(), "InvalidCharacterError") {
+			t.Errorf("RenameNode(%q) returned %v, want InvalidCharacterError", name, err)
+		}
+	}
+	if _, err := doc.CreateElementNS("", "p:x"); err == nil || !strings.HasPrefix(err.Error(), "NamespaceError") {
+		t.Errorf("CreateElementNS() with a prefix and no namespace returned %v, want NamespaceError", err)
+	}
+	if _, err := doc.CreateElementNS("urn:a", "p:x"); err != nil {
+		t.Errorf("CreateElementNS(p:x) failed: %v", err)
+	}
+	if elem.NodeName() != "a:elem" {
+		t.Errorf("failed renames changed the name to %q", elem.NodeName())
+	}
+}
+
+func TestCreateAttributeLifecycle(t *testing.T) {
+	doc := createTestDoc(t)
+	elem, _ := doc.CreateElement("item")
+	doc.AppendChild(elem)
+
+	a, err := doc.CreateAttribute("lang")
+	if err != nil {
+		t.Fatalf("CreateAttribute() failed: %v", err)
+	}
+	if a.OwnerElement() != nil {
+		t.Error("new attribute has an owner element")
+	}
+	if a.Value() != "" || a.NodeValue() != "" {
+		t.Errorf("new attribute value = %q, want empty", a.Value())
+	}
+	if !a.Specified() {
+		t.Error("new attribute is not specified")
+	}
+
+	if _, err := elem.SetAttributeNode(a); err != nil {
+		t.Fatalf("SetAttributeNode() failed: %v", err)
+	}
+	if a.OwnerElement() != elem {
+		t.Error("OwnerElement() is not the element after SetAttributeNode")
+	}
+	if !a.Specified() {
+		t.Error("attached attribute is not specified")
+	}
+	if !elem.HasAttribute("lang") || elem.GetAttribute("lang") != "" {
+		t.Errorf("HasAttribute() = %v, GetAttribute() = %q, want true and empty", elem.HasAttribute("lang"), elem.GetAttribute("lang"))
+	}
+	a.SetValue("en")
+	if got := elem.GetAttribute("lang"); got != "en" {
+		t.Errorf("GetAttribute() = %q after SetValue, want en", got)
+	}
+
+	if _, err := elem.RemoveAttributeNode(a); err != nil {
+		t.Fatalf("RemoveAttributeNode() failed: %v", err)
+	}
+	if a.OwnerElement() != nil {
+		t.Error("removed attribute still has an owner element")
+	}
+	if elem.HasAttribute("lang") {
+		t.Error("element still has the removed attribute")
+	}
+}
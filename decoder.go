@@ -5,7 +5,11 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	"maps"
+	"regexp"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"golang.org/x/text/encoding/ianaindex"
 )
@@ -48,42 +52,92 @@ func findStartTagEndOffset(data []byte, startOff int64) int64 {
 	return startOff
 }
 
-// findAttrNameOffset finds the absolute byte offset of the attribute local name within the start tag [startOff,endOff]
-func findAttrNameOffset(data []byte, startOff, endOff int64, local string) int64 {
-	if startOff < 0 { startOff = 0 }
-	if endOff > int64(len(data)) { endOff = int64(len(data)) }
+// attrNameOffsets returns the absolute byte offsets of the attribute names
+// in the start tag [startOff,endOff], in the order they are written, which
+// is the order encoding/xml reports them in. Attributes are matched by
+// position because encoding/xml replaces prefixes by namespace URIs, so the
+// qualified name as written is not known.
+func attrNameOffsets(data []byte, startOff, endOff int64) []int64 {
+	if startOff < 0 || endOff > int64(len(data)) || startOff >= endOff || data[startOff] != '<' {
+		return nil
+	}
 	seg := data[startOff:endOff]
-	inQuote := byte(0)
-	name := []byte(local)
-	for i := 0; i+len(name) <= len(seg); i++ {
-		b := seg[i]
-		if inQuote != 0 {
-			if b == inQuote { inQuote = 0 }
-			continue
+	i := 1
+	for i < len(seg) && !isXMLSpace(seg[i]) && seg[i] != '/' {
+		i++
+	}
+	var offsets []int64
+	for {
+		for i < len(seg) && isXMLSpace(seg[i]) {
+			i++
 		}
-		if b == '\'' || b == '"' {
-			inQuote = b
+		if i >= len(seg) || seg[i] == '/' {
+			return offsets
+		}
+		offsets = append(offsets, startOff+int64(i))
+		for i < len(seg) && !isXMLSpace(seg[i]) && seg[i] != '=' && seg[i] != '/' {
+			i++
+		}
+		for i < len(seg) && isXMLSpace(seg[i]) {
+			i++
+		}
+		if i >= len(seg) || seg[i] != '=' {
+			// A lenient decoder accepts attributes without a value
 			continue
 		}
-		if seg[i] == name[0] && bytes.HasPrefix(seg[i:], name) {
-			// check simple boundary conditions: prev is whitespace or ':' or '<' or start
-			prevOK := i == 0
-			if i > 0 {
-				p := seg[i-1]
-				prevOK = p == ' ' || p == '\t' || p == ':' || p == '<' || p == '\n' || p == '\r'
-			}
-			// next should be '=' or whitespace or ':' (prefix already consumed) or '>'
-			nextOK := true
-			if i+len(name) < len(seg) {
-				n := seg[i+len(name)]
-				nextOK = n == '=' || n == ' ' || n == '\t' || n == ':' || n == '>'
+		i++
+		for i < len(seg) && isXMLSpace(seg[i]) {
+			i++
+		}
+		if i < len(seg) && (seg[i] == '"' || seg[i] == '\'') {
+			end := bytes.IndexByte(seg[i+1:], seg[i])
+			if end < 0 {
+				return nil
 			}
-			if prevOK && nextOK {
-				return startOff + int64(i)
+			i += end + 2
+		} else {
+			// An unquoted value, again only in lenient mode
+			for i < len(seg) && !isXMLSpace(seg[i]) {
+				i++
 			}
 		}
 	}
-	return -1
+}
+
+// rawAttrValue returns the source text between the quotes of the attribute
+// whose name starts at nameOff, without any entity or whitespace processing.
+func rawAttrValue(data []byte, nameOff, endOff int64) (string, bool) {
+	if nameOff < 0 || endOff > int64(len(data)) {
+		return "", false
+	}
+	seg := data[nameOff:endOff]
+	i := 0
+	for i < len(seg) && !isXMLSpace(seg[i]) && seg[i] != '=' && seg[i] != '>' {
+		i++
+	}
+	for i < len(seg) && isXMLSpace(seg[i]) {
+		i++
+	}
+	if i >= len(seg) || seg[i] != '=' {
+		return "", false
+	}
+	i++
+	for i < len(seg) && isXMLSpace(seg[i]) {
+		i++
+	}
+	if i >= len(seg) || (seg[i] != '"' && seg[i] != '\'') {
+		return "", false
+	}
+	quote := seg[i]
+	end := bytes.IndexByte(seg[i+1:], quote)
+	if end < 0 {
+		return "", false
+	}
+	return string(seg[i+1 : i+1+end]), true
+}
+
+func isXMLSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
 }
 
 // Decoder is a struct that decodes a DOM tree from an XML input stream.
@@ -115,8 +169,37 @@ type Decoder struct {
 	// Position tracking
 	sourceText []byte  // Original source text for line/column calculation
 	lineStarts []int64 // Byte offsets where each line starts (1-based line numbering)
+
+	nodeFilter func(node Node) FilterAction
+
+	preserveAttrEntities bool
+	keepAttrWhitespace   bool
+
+	preserveEntityRefs bool
+	entityValues       map[string]string // internal general entities by name
+	entityMarker       rune              // wraps preserved entity references, 0 if none
+	entityOption       map[string]string // DecoderOptions.Entity, never modified
+
+	errs []error // errors recovered from in lenient mode
 }
 
+// FilterAction tells the Decoder what to do with a node passed to its node filter.
+type FilterAction int
+
+const (
+	// FilterAccept keeps the node.
+	FilterAccept FilterAction = iota
+	// FilterDrop discards the node and its subtree. The content of a dropped
+	// element is skipped without building any nodes.
+	FilterDrop
+	// FilterUnwrap discards an element but keeps its children, which are
+	// attached to the element's parent instead. For text nodes it behaves
+	// like FilterDrop. Unwrapping the document element fails with a
+	// ParsingError unless its content is a single element, optionally
+	// surrounded by whitespace, comments and processing instructions.
+	FilterUnwrap
+)
+
 // DecoderOptions allows specifying decoder options.
 type DecoderOptions struct {
 	// CharsetReader, if non-nil, is used to decode XML input from non-UTF-8 character sets.
@@ -156,7 +239,8 @@ func NewDecoderWithOptions(r io.Reader, opts *DecoderOptions) *Decoder {
 	}
 
 decoder := &Decoder{
-		d: d,
+		d:            d,
+		entityOption: d.Entity,
 	}
 
 	// Capture full source for position tracking by buffering the reader
@@ -171,6 +255,9 @@ decoder := &Decoder{
 		if data, err := io.ReadAll(r); err == nil {
 			decoder.sourceText = data
 			decoder.d = xml.NewDecoder(bytes.NewReader(data))
+			decoder.d.Strict = d.Strict
+			decoder.d.CharsetReader = d.CharsetReader
+			decoder.d.Entity = d.Entity
 		}
 	}
 	decoder.buildLineIndex()
@@ -184,6 +271,444 @@ func NewDecoder(r io.Reader) *Decoder {
 	return NewDecoderWithOptions(r, nil)
 }
 
+// Reset discards the state of the previous document and makes the decoder
+// read a new one from r, so a pool of decoders can be reused across many
+// small documents. The options the decoder was created with and the
+// settings made through its Set methods are kept; the buffers holding the
+// source text and its line index are reused.
+func (d *Decoder) Reset(r io.Reader) {
+	buf := bytes.NewBuffer(d.sourceText[:0])
+	_, err := buf.ReadFrom(r)
+	var src io.Reader = bytes.NewReader(buf.Bytes())
+	if err != nil {
+		// Let the xml.Decoder report the read error once it gets there
+		src = io.MultiReader(src, r)
+	}
+
+	prev := d.d
+	d.d = xml.NewDecoder(src)
+	d.d.Strict = prev.Strict
+	d.d.CharsetReader = prev.CharsetReader
+	d.d.Entity = d.entityOption
+
+	d.sourceText = buf.Bytes()
+	d.lineStarts = d.lineStarts[:0]
+	d.buildLineIndex()
+	d.bufferedToken = nil
+	d.entityValues = nil
+	d.entityMarker = 0
+	d.errs = nil
+}
+
+// SetNodeFilter installs a filter that is called for each element and text
+// node as it is parsed. Elements are passed once their start tag, including
+// all attributes, has been read and they have been attached to their parent,
+// but before any of their content is parsed, so a dropped element's subtree
+// is never built. Passing nil removes the filter.
+func (d *Decoder) SetNodeFilter(filter func(node Node) FilterAction) {
+	d.nodeFilter = filter
+}
+
+// SetPreserveAttributeEntities makes the decoder record the source text of
+// each attribute value, so that Marshal writes entity and character
+// references such as &#65; back out as they were written instead of the
+// characters they stand for. The recorded text is dropped once the
+// attribute's value is changed.
+func (d *Decoder) SetPreserveAttributeEntities(preserve bool) {
+	d.preserveAttrEntities = preserve
+}
+
+// SetNormalizeAttributeValues controls attribute-value normalization. When
+// normalize is true (the default, as the XML specification requires) each
+// tab, carriage return and line feed written literally in an attribute
+// value is read as a space, while the same characters written as character
+// references are kept. When false, tabs and line breaks are kept as they
+// are. Line breaks are still read as line feeds, since the XML
+// specification normalizes "\r\n" and "\r" in all input before parsing.
+func (d *Decoder) SetNormalizeAttributeValues(normalize bool) {
+	d.keepAttrWhitespace = !normalize
+}
+
+// normalizedAttrValue returns the normalized value of the attribute whose
+// name starts at nameOff, working from its source text so that character
+// references can be told apart from literal whitespace. value, the decoded
+// value, is normalized directly when the source text is unavailable.
+func (d *Decoder) normalizedAttrValue(value string, nameOff, endOff int64) string {
+	raw, ok := rawAttrValue(d.sourceText, nameOff, endOff)
+	if !ok || !utf8.ValidString(raw) {
+		return normalizeAttrSpace(value)
+	}
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '\r' && i+1 < len(raw) && raw[i+1] == '\n':
+			b.WriteByte(' ')
+			i++
+		case c == '\t' || c == '\n' || c == '\r':
+			b.WriteByte(' ')
+		case c == '&':
+			end := strings.IndexByte(raw[i:], ';')
+			if end < 0 {
+				return normalizeAttrSpace(value)
+			}
+			ref := raw[i+1 : i+end]
+			replacement, ok := d.attrReference(ref)
+			if !ok {
+				return normalizeAttrSpace(value)
+			}
+			b.WriteString(replacement)
+			i += end
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// attrReference returns the replacement text of the entity or character
+// reference ref, given without its & and ;, inside an attribute value
+func (d *Decoder) attrReference(ref string) (string, bool) {
+	if strings.HasPrefix(ref, "#") {
+		var r uint64
+		var err error
+		if strings.HasPrefix(ref, "#x") {
+			r, err = strconv.ParseUint(ref[2:], 16, 32)
+		} else {
+			r, err = strconv.ParseUint(ref[1:], 10, 32)
+		}
+		if err != nil {
+			return "", false
+		}
+		return string(rune(r)), true
+	}
+	switch ref {
+	case "lt":
+		return "<", true
+	case "gt":
+		return ">", true
+	case "amp":
+		return "&", true
+	case "apos":
+		return "'", true
+	case "quot":
+		return `"`, true
+	}
+	if value, ok := d.d.Entity[ref]; ok {
+		return normalizeAttrSpace(value), true
+	}
+	return "", false
+}
+
+// normalizeAttrSpace replaces tabs and line breaks in s by spaces, treating
+// a CR LF pair as a single break
+func normalizeAttrSpace(s string) string {
+	if !strings.ContainsAny(s, "\t\n\r") {
+		return s
+	}
+	s = strings.ReplaceAll(s, "\r\n", " ")
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// SetExpandEntityReferences controls how references to entities declared
+// in the internal DTD subset are parsed. When expand is true (the default)
+// they are replaced by the entity's value. When false each reference
+// becomes an EntityReference node whose read-only children hold the
+// entity's value. References inside attribute values are always expanded.
+func (d *Decoder) SetExpandEntityReferences(expand bool) {
+	d.preserveEntityRefs = !expand
+}
+
+// Entity references kept by SetExpandEntityReferences(false) are handed to
+// encoding/xml as the entity name wrapped in a marker character and split
+// back out of the character data. The marker is a private-use character
+// that occurs nowhere in the document, neither literally nor as a character
+// reference, so it cannot be confused with the document's own text. It is
+// not a name character either, so the name between two markers is never
+// cut short. In the unlikely case that every candidate occurs, references
+// are expanded instead.
+var entityMarkerRanges = [][2]rune{{0xE000, 0xF8FF}, {0xF0000, 0xFFFFD}}
+
+var (
+	charRefPattern      = regexp.MustCompile(`&#(x[0-9a-fA-F]+|[0-9]+);`)
+	declEncodingPattern = regexp.MustCompile(`^\s*<\?xml[^>]*\sencoding\s*=\s*["']([^"']+)["']`)
+	instEncodingPattern = regexp.MustCompile(`(?:^|\s)encoding\s*=\s*["']([^"']+)["']`)
+)
+
+// pickEntityMarker returns a character that does not occur in the source
+// text, or 0 if every candidate does
+func (d *Decoder) pickEntityMarker() rune {
+	src := d.sourceText
+	// encoding/xml reads non-UTF-8 input through the CharsetReader, so look
+	// at the characters it will see
+	if m := declEncodingPattern.FindSubmatch(src); m != nil && !strings.EqualFold(string(m[1]), "utf-8") && d.d.CharsetReader != nil {
+		if r, err := d.d.CharsetReader(string(m[1]), bytes.NewReader(src)); err == nil {
+			if converted, err := io.ReadAll(r); err == nil {
+				src = converted
+			}
+		}
+	}
+
+	used := make(map[rune]bool)
+	for _, r := range string(src) {
+		if r >= 0xE000 {
+			used[r] = true
+		}
+	}
+	for _, m := range charRefPattern.FindAllSubmatch(src, -1) {
+		var n uint64
+		var err error
+		if m[1][0] == 'x' {
+			n, err = strconv.ParseUint(string(m[1][1:]), 16, 32)
+		} else {
+			n, err = strconv.ParseUint(string(m[1]), 10, 32)
+		}
+		if err == nil {
+			used[rune(n)] = true
+		}
+	}
+
+	for _, rng := range entityMarkerRanges {
+		for r := rng[0]; r <= rng[1]; r++ {
+			if !used[r] {
+				return r
+			}
+		}
+	}
+	return 0
+}
+
+var entityDeclPattern = regexp.MustCompile(`<!ENTITY\s+([^\s%"']+)\s+(?:"([^"]*)"|'([^']*)')\s*>`)
+
+// declareEntities records the internal general entities declared in subset
+// on dt and makes them known to the underlying xml.Decoder
+func (d *Decoder) declareEntities(doc *document, dt *documentType, subset string) {
+	for _, m := range entityDeclPattern.FindAllStringSubmatch(subset, -1) {
+		name, value := m[1], m[2]+m[3]
+		if _, seen := d.entityValues[name]; seen {
+			// The first declaration is binding
+			continue
+		}
+		if d.entityValues == nil {
+			d.entityValues = make(map[string]string)
+			// Declarations are added to a copy, leaving the option as given
+			d.d.Entity = maps.Clone(d.d.Entity)
+			if d.preserveEntityRefs {
+				d.entityMarker = d.pickEntityMarker()
+			}
+		}
+		d.entityValues[name] = value
+
+		ent := &entity{node: node{nodeType: ENTITY_NODE, nodeName: DOMString(name), ownerDocument: doc}}
+		if value != "" {
+			ent.linkLastChild(ent, doc.CreateTextNode(DOMString(value)))
+		}
+		markReadOnly(ent)
+		dt.entities.nodes = append(dt.entities.nodes, ent)
+
+		if d.d.Entity == nil {
+			d.d.Entity = make(map[string]string)
+		}
+		if d.entityMarker != 0 {
+			marker := string(d.entityMarker)
+			d.d.Entity[name] = marker + name + marker
+		} else {
+			d.d.Entity[name] = value
+		}
+	}
+}
+
+// expandEntityMarkers replaces preserved entity references in s by their values
+func (d *Decoder) expandEntityMarkers(s string) string {
+	if d.entityMarker == 0 || !strings.ContainsRune(s, d.entityMarker) {
+		return s
+	}
+	var b strings.Builder
+	for {
+		before, name, after, ok := d.cutEntityRef(s)
+		b.WriteString(before)
+		if !ok {
+			return b.String()
+		}
+		b.WriteString(d.entityValues[name])
+		s = after
+	}
+}
+
+// cutEntityRef splits s around its first preserved entity reference. ok is
+// false when s holds none, in which case before is all of s.
+func (d *Decoder) cutEntityRef(s string) (before, name, after string, ok bool) {
+	marker := string(d.entityMarker)
+	before, rest, found := strings.Cut(s, marker)
+	if !found {
+		return s, "", "", false
+	}
+	name, after, found = strings.Cut(rest, marker)
+	if !found {
+		return s, "", "", false
+	}
+	return before, name, after, true
+}
+
+// appendWithEntityRefs appends character data containing preserved entity
+// references to parent as alternating Text and EntityReference nodes
+func (d *Decoder) appendWithEntityRefs(doc *document, parent Node, s string) error {
+	for s != "" {
+		before, name, after, ok := d.cutEntityRef(s)
+		if before != "" {
+			text := doc.CreateTextNode(DOMString(before))
+			parent.AppendChild(text)
+			d.applyFilter(doc, text)
+		}
+		if !ok {
+			break
+		}
+		ref, err := doc.CreateEntityReference(DOMString(name))
+		if err != nil {
+			return err
+		}
+		parent.AppendChild(ref)
+		s = after
+	}
+	return nil
+}
+
+// Errors returns the well-formedness errors the last Decode recovered from.
+// Only a lenient decoder, created with Strict set to false, recovers from
+// errors: a mismatched or missing end tag closes the open elements up to
+// the matching one, an end tag matching no open element is dropped, and a
+// stray & is read as a literal ampersand. A strict decoder stops at the
+// first error, which Decode returns, so Errors is always empty for it.
+func (d *Decoder) Errors() []error {
+	return d.errs
+}
+
+// recoverMarkup rewrites the source of a lenient decoder so that the
+// recoverable errors in it are repaired, recording each one, and restarts
+// the underlying xml.Decoder on the result. Errors it cannot repair are
+// left for Decode to report.
+func (d *Decoder) recoverMarkup() {
+	src := d.sourceText
+	raw := xml.NewDecoder(bytes.NewReader(src))
+	raw.Strict = false
+
+	lineOf := func(off int64) int {
+		return 1 + bytes.Count(src[:off], []byte("\n"))
+	}
+	report := func(off int64, format string, args ...any) {
+		d.errs = append(d.errs, &ParsingError{Err: &xml.SyntaxError{Msg: fmt.Sprintf(format, args...), Line: lineOf(off)}})
+	}
+
+	var out bytes.Buffer
+	var open []string
+	for {
+		start := raw.InputOffset()
+		token, err := raw.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			out.Write(src[start:])
+			open = nil
+			break
+		}
+		end := raw.InputOffset()
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			open = append(open, rawName(t.Name))
+		case xml.EndElement:
+			name := rawName(t.Name)
+			if start == end {
+				// The end of an empty-element tag
+				open = open[:len(open)-1]
+				continue
+			}
+			match := len(open) - 1
+			for match >= 0 && open[match] != name {
+				match--
+			}
+			if match < 0 {
+				report(start, "unexpected end element </%s>", name)
+				continue
+			}
+			for i := len(open) - 1; i > match; i-- {
+				report(start, "element <%s> closed by </%s>", open[i], name)
+				out.WriteString("</" + open[i] + ">")
+			}
+			open = open[:match]
+		case xml.CharData:
+			if text := src[start:end]; !bytes.HasPrefix(text, []byte("<![CDATA[")) {
+				d.escapeStrayAmpersands(&out, text, start, report)
+				continue
+			}
+		case xml.ProcInst:
+			// Offsets in a transcoded document do not match the source
+			if t.Target == "xml" && !isUTF8Declaration(string(t.Inst)) {
+				d.errs = nil
+				return
+			}
+		}
+		out.Write(src[start:end])
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		report(int64(len(src)), "element <%s> not closed", open[i])
+		out.WriteString("</" + open[i] + ">")
+	}
+
+	if len(d.errs) == 0 {
+		return
+	}
+	repaired := xml.NewDecoder(bytes.NewReader(out.Bytes()))
+	repaired.Strict = false
+	repaired.CharsetReader = d.d.CharsetReader
+	repaired.Entity = d.d.Entity
+	d.d = repaired
+	d.sourceText = out.Bytes()
+	d.buildLineIndex()
+}
+
+// escapeStrayAmpersands writes text, which starts at offset start, to out
+// with each & that does not begin a reference written as &amp;
+func (d *Decoder) escapeStrayAmpersands(out *bytes.Buffer, text []byte, start int64, report func(int64, string, ...any)) {
+	for i := 0; i < len(text); i++ {
+		if text[i] == '&' && !referencePattern.Match(text[i:]) {
+			report(start+int64(i), "stray & in character data")
+			out.WriteString("&amp;")
+			continue
+		}
+		out.WriteByte(text[i])
+	}
+}
+
+// referencePattern matches an entity or character reference at the start
+// of its input
+var referencePattern = regexp.MustCompile(`^&(#[0-9]+|#x[0-9a-fA-F]+|[\pL_:][\pL\pN_:.-]*);`)
+
+// isUTF8Declaration reports whether the XML declaration with the given
+// content leaves the encoding at, or sets it to, UTF-8
+func isUTF8Declaration(inst string) bool {
+	i := strings.Index(inst, "encoding")
+	if i < 0 {
+		return true
+	}
+	rest := strings.ToLower(inst[i:])
+	return strings.Contains(rest, "utf-8") || strings.Contains(rest, "utf8")
+}
+
+// rawName returns the qualified name of a token read by RawToken
+func rawName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
 // ParsingError represents an error that occurred during XML parsing.
 type ParsingError struct {
 	// The underlying error from the xml package.
@@ -215,6 +740,47 @@ func (d *Decoder) peekToken() (xml.Token, error) {
 	return token, nil
 }
 
+// applyFilter runs the node filter on a freshly attached node and detaches
+// the node again unless the filter accepts it.
+func (d *Decoder) applyFilter(doc *document, n Node) FilterAction {
+	if d.nodeFilter == nil {
+		return FilterAccept
+	}
+	action := d.nodeFilter(n)
+	if action != FilterDrop && action != FilterUnwrap {
+		return FilterAccept
+	}
+
+	if parent := n.ParentNode(); parent != nil {
+		parent.RemoveChild(n)
+	}
+	if doc.documentElement != nil && isSameNode(doc.documentElement, n) {
+		doc.documentElement = nil
+	}
+	if elem, ok := n.(*element); ok {
+		doc.releaseId(elem, elem.GetAttribute(doc.idAttribute()))
+	}
+	return action
+}
+
+// skipElement consumes tokens up to and including the end tag of the element
+// whose start tag was just read, without building any nodes.
+func (d *Decoder) skipElement() error {
+	for depth := 1; depth > 0; {
+		token, err := d.nextToken()
+		if err != nil {
+			return err
+		}
+		switch token.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return nil
+}
+
 func isValidXMLChar(r rune) bool {
 	return r == 0x9 || r == 0xA || r == 0xD ||
 		(r >= 0x20 && r <= 0xD7FF) ||
@@ -272,6 +838,11 @@ func (d *Decoder) Decode() (Document, error) {
 	}
 	docImpl := doc.(*document)
 
+	d.errs = nil
+	if !d.d.Strict && d.sourceText != nil {
+		d.recoverMarkup()
+	}
+
 	stack := []Node{doc}
 
 	for {
@@ -284,6 +855,9 @@ func (d *Decoder) Decode() (Document, error) {
 		}
 
 		parent := stack[len(stack)-1]
+		// Content of an unwrapped document element is hoisted to the Document,
+		// which takes only one element and no text
+		hoisted := len(stack) > 1 && parent == Node(doc)
 
 		switch t := token.(type) {
 		case xml.StartElement:
@@ -293,10 +867,9 @@ func (d *Decoder) Decode() (Document, error) {
 			}
 
 			// Create a new element
-			elem, err := doc.CreateElementNS(DOMString(t.Name.Space), DOMString(t.Name.Local))
-			if err != nil {
-				return nil, &ParsingError{Err: err}
-			}
+			// Names are not checked as QNames here, since XML 1.0 allows
+			// any name
+			var elem Element = docImpl.newElementNS(DOMString(t.Name.Space), DOMString(t.Name.Local))
 
 // Store position information at start-of-start-tag
 			if elemImpl, ok := elem.(*element); ok {
@@ -313,8 +886,17 @@ func (d *Decoder) Decode() (Document, error) {
 			// Determine start/end of start tag for attribute position mapping
 			endOff := findStartTagEndOffset(d.sourceText, getInternalNode(elem).sourcePosition.Offset)
 
+			nameOffs := attrNameOffsets(d.sourceText, getInternalNode(elem).sourcePosition.Offset, endOff)
+			if len(nameOffs) != len(t.Attr) {
+				nameOffs = nil
+			}
+
 			// Copy attributes with namespace validation
-			for _, attr := range t.Attr {
+			for i, attr := range t.Attr {
+				nameOff := int64(-1)
+				if nameOffs != nil {
+					nameOff = nameOffs[i]
+				}
 				// Validate namespace prefix rules during parsing
 				if attr.Name.Space == "xmlns" {
 					// This is a namespace declaration: xmlns:prefix="..."
@@ -325,15 +907,15 @@ func (d *Decoder) Decode() (Document, error) {
 					if prefix == "xmlns" {
 						return nil, &ParsingError{Err: fmt.Errorf("cannot declare xmlns prefix")}
 					}
-					if prefix == "xml" && attr.Value != "http://www.w3.org/XML/1998/namespace" {
+					if prefix == "xml" && attr.Value != xmlNamespaceURI {
 						return nil, &ParsingError{Err: fmt.Errorf("xml prefix must be bound to http://www.w3.org/XML/1998/namespace")}
 					}
-					if attr.Value == "http://www.w3.org/XML/1998/namespace" && prefix != "xml" {
+					if attr.Value == xmlNamespaceURI && prefix != "xml" {
 						return nil, &ParsingError{Err: fmt.Errorf("http://www.w3.org/XML/1998/namespace can only be bound to xml prefix")}
 					}
 				} else if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
 					// Default namespace declaration: xmlns="..."
-					if attr.Value == "http://www.w3.org/2000/xmlns/" {
+					if attr.Value == xmlnsNamespaceURI {
 						return nil, &ParsingError{Err: fmt.Errorf("cannot bind default namespace to xmlns namespace")}
 					}
 				} else if attr.Name.Space == "" && strings.HasPrefix(attr.Name.Local, "xmlns:") {
@@ -346,15 +928,19 @@ func (d *Decoder) Decode() (Document, error) {
 					if prefix == "xmlns" {
 						return nil, &ParsingError{Err: fmt.Errorf("cannot declare xmlns prefix")}
 					}
-					if prefix == "xml" && attr.Value != "http://www.w3.org/XML/1998/namespace" {
+					if prefix == "xml" && attr.Value != xmlNamespaceURI {
 						return nil, &ParsingError{Err: fmt.Errorf("xml prefix must be bound to http://www.w3.org/XML/1998/namespace")}
 					}
-					if attr.Value == "http://www.w3.org/XML/1998/namespace" && prefix != "xml" {
+					if attr.Value == xmlNamespaceURI && prefix != "xml" {
 						return nil, &ParsingError{Err: fmt.Errorf("http://www.w3.org/XML/1998/namespace can only be bound to xml prefix")}
 					}
 				}
 
-				err := elem.SetAttributeNS(DOMString(attr.Name.Space), DOMString(attr.Name.Local), DOMString(attr.Value))
+				value := attr.Value
+				if !d.keepAttrWhitespace {
+					value = d.normalizedAttrValue(value, nameOff, endOff)
+				}
+				err := elem.(*element).setAttributeNS(DOMString(attr.Name.Space), DOMString(attr.Name.Local), DOMString(d.expandEntityMarkers(value)))
 				if err != nil {
 					return nil, &ParsingError{Err: err}
 				}
@@ -362,11 +948,14 @@ func (d *Decoder) Decode() (Document, error) {
 				// Set position information for the attribute at the attribute name start, if found
 				if attrNode := elem.GetAttributeNodeNS(DOMString(attr.Name.Space), DOMString(attr.Name.Local)); attrNode != nil {
 					if attrImpl := getInternalNode(attrNode); attrImpl != nil {
-						startOff := getInternalNode(elem).sourcePosition.Offset
-						attrStart := findAttrNameOffset(d.sourceText, startOff, endOff, string(attr.Name.Local))
-						if attrStart >= 0 {
+						if attrStart := nameOff; attrStart >= 0 {
 							line, col := d.calculateLineColumn(attrStart)
 							attrImpl.sourcePosition = position{Line: line, Column: col, Offset: attrStart}
+							if d.preserveAttrEntities {
+								if raw, ok := rawAttrValue(d.sourceText, attrStart, endOff); ok {
+									setAttrRawValue(attrNode, DOMString(raw))
+								}
+							}
 						} else {
 							// Fallback to element position
 							attrImpl.sourcePosition = getInternalNode(elem).sourcePosition
@@ -375,9 +964,23 @@ func (d *Decoder) Decode() (Document, error) {
 				}
 			}
 
+			// Register the id as the element is built so GetElementById
+			// works as soon as Decode returns
+			if id := elem.GetAttributeNS("", "id"); id != "" {
+				docImpl.mu.Lock()
+				docImpl.updateIdMappingForElement(elem, "id", "", id)
+				docImpl.mu.Unlock()
+			}
+
 			// Append the new element to the parent
+			hadRoot := docImpl.documentElement != nil
 			parent.AppendChild(elem)
 
+			action := d.applyFilter(docImpl, elem)
+			if hoisted && hadRoot && action == FilterAccept {
+				return nil, &ParsingError{Err: fmt.Errorf("cannot unwrap the document element: it has more than one element child")}
+			}
+
 			// Peek at the next token to see if it's a matching end element.
 			nextToken, err := d.peekToken()
 			if err != nil {
@@ -392,11 +995,23 @@ func (d *Decoder) Decode() (Document, error) {
 				// This is a self-closing element. Consume the end token.
 				_, _ = d.nextToken()
 			} else {
-				// This is a regular start element. Push it onto the stack.
-				stack = append(stack, elem)
+				switch action {
+				case FilterDrop:
+					// Skip the content of a dropped element entirely.
+					if err := d.skipElement(); err != nil {
+						return nil, &ParsingError{Err: err}
+					}
+				case FilterUnwrap:
+					// Children of an unwrapped element go to its parent. The parent
+					// is pushed again so the matching end tag pops it.
+					stack = append(stack, parent)
+				default:
+					// This is a regular start element. Push it onto the stack.
+					stack = append(stack, elem)
+				}
 			}
 
-			if docImpl.documentElement == nil {
+			if docImpl.documentElement == nil && action == FilterAccept {
 				docImpl.documentElement = elem
 			}
 		case xml.EndElement:
@@ -407,6 +1022,16 @@ func (d *Decoder) Decode() (Document, error) {
 					return nil, &ParsingError{Err: fmt.Errorf("invalid character 0x%x in CharData", r)}
 				}
 			}
+			hoistedText := hoisted && strings.Trim(string(t), " \t\r\n") != ""
+			if d.entityMarker != 0 && strings.ContainsRune(string(t), d.entityMarker) {
+				if hoistedText {
+					return nil, &ParsingError{Err: fmt.Errorf("cannot unwrap the document element: text cannot be a child of the document")}
+				}
+				if err := d.appendWithEntityRefs(docImpl, parent, string(t)); err != nil {
+					return nil, &ParsingError{Err: err}
+				}
+				continue
+			}
 			text := doc.CreateTextNode(DOMString(t))
 
 			// Store position information (start of text token if possible)
@@ -418,6 +1043,9 @@ func (d *Decoder) Decode() (Document, error) {
 			}
 
 			parent.AppendChild(text)
+			if d.applyFilter(docImpl, text) == FilterAccept && hoistedText {
+				return nil, &ParsingError{Err: fmt.Errorf("cannot unwrap the document element: text cannot be a child of the document")}
+			}
 		case xml.Comment:
 			commentText := DOMString(t)
 			for _, r := range commentText {
@@ -441,7 +1069,11 @@ func (d *Decoder) Decode() (Document, error) {
 		case xml.ProcInst:
 			// The Go XML parser reports the XML declaration as a ProcInst with target "xml".
 			// We need to ignore this, as it's not a real processing instruction.
+			// Its encoding is kept as the document's CharacterSet.
 			if strings.EqualFold(t.Target, "xml") {
+				if m := instEncodingPattern.FindSubmatch(t.Inst); m != nil {
+					docImpl.characterSet = DOMString(m[1])
+				}
 				continue
 			}
 			for _, r := range string(t.Inst) {
@@ -522,6 +1154,15 @@ func (d *Decoder) Decode() (Document, error) {
 					dtNode.sourcePosition = position{Line: line, Column: col, Offset: offEnd}
 				}
 
+				if dt, ok := doctype.(*documentType); ok {
+					full := string(t)
+					if open, close := strings.Index(full, "["), strings.LastIndex(full, "]"); open >= 0 && close > open {
+						subset := full[open+1 : close]
+						dt.internalSubset = DOMString(strings.TrimSpace(subset))
+						d.declareEntities(docImpl, dt, subset)
+					}
+				}
+
 				if docImpl, ok := doc.(*document); ok {
 					docImpl.doctype = doctype
 				}
@@ -144,3 +144,401 @@ func TestDecode_Doctype(t *testing.T) {
 		t.Errorf("Expected system id to be 'http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd', got '%s'", doctype.SystemId())
 	}
 }
+
+func TestDecode_NodeFilter(t *testing.T) {
+	input := `<root><wrapper><p>one</p><script><p>inside</p>alert(1)</script><p>two</p></wrapper><script/></root>`
+	decoder := xmldom.NewDecoder(strings.NewReader(input))
+
+	var seen []string
+	decoder.SetNodeFilter(func(node xmldom.Node) xmldom.FilterAction {
+		if node.NodeType() == xmldom.TEXT_NODE {
+			seen = append(seen, "#"+string(node.NodeValue()))
+			return xmldom.FilterAccept
+		}
+		seen = append(seen, string(node.NodeName()))
+		switch node.NodeName() {
+		case "script":
+			return xmldom.FilterDrop
+		case "wrapper":
+			return xmldom.FilterUnwrap
+		}
+		return xmldom.FilterAccept
+	})
+
+	doc, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+
+	got, err := xmldom.Marshal(doc.DocumentElement())
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if want := `<root><p>one</p><p>two</p></root>`; string(got) != want {
+		t.Errorf("filtered document = %s, want %s", got, want)
+	}
+
+	// Nothing inside a dropped element should have been built or filtered
+	if joined := strings.Join(seen, ","); strings.Contains(joined, "inside") || strings.Contains(joined, "alert") {
+		t.Errorf("content of dropped elements was parsed into nodes: %s", joined)
+	}
+
+	if doc.GetElementsByTagName("script").Length() != 0 {
+		t.Errorf("script elements should have been dropped")
+	}
+}
+
+func TestDecode_NodeFilterRoot(t *testing.T) {
+	decoder := xmldom.NewDecoder(strings.NewReader(`<wrapper><root id="r"/></wrapper>`))
+	decoder.SetNodeFilter(func(node xmldom.Node) xmldom.FilterAction {
+		if node.NodeName() == "wrapper" {
+			return xmldom.FilterUnwrap
+		}
+		return xmldom.FilterAccept
+	})
+	doc, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if root := doc.DocumentElement(); root == nil || root.NodeName() != "root" {
+		t.Fatalf("unwrapping the root should promote its child to document element")
+	}
+	if doc.GetElementById("r") == nil {
+		t.Errorf("promoted element should be found by id")
+	}
+}
+
+func TestDecode_NodeFilterRootWithSeveralChildren(t *testing.T) {
+	unwrap := func(node xmldom.Node) xmldom.FilterAction {
+		switch node.NodeName() {
+		case "wrapper":
+			return xmldom.FilterUnwrap
+		case "skip":
+			return xmldom.FilterDrop
+		}
+		return xmldom.FilterAccept
+	}
+
+	for _, input := range []string{
+		`<wrapper><a/><b/></wrapper>`,
+		`<wrapper>text<a/></wrapper>`,
+	} {
+		decoder := xmldom.NewDecoder(strings.NewReader(input))
+		decoder.SetNodeFilter(unwrap)
+		if _, err := decoder.Decode(); err == nil {
+			t.Errorf("Decode(%s) should fail instead of truncating the document", input)
+		}
+	}
+
+	// Whitespace and content the filter drops do not count
+	decoder := xmldom.NewDecoder(strings.NewReader("<wrapper>\n  <a/>\n  <skip/><!--c-->\n</wrapper>"))
+	decoder.SetNodeFilter(unwrap)
+	doc, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if root := doc.DocumentElement(); root == nil || root.NodeName() != "a" {
+		t.Errorf("unwrapping the root should promote its only element child")
+	}
+}
+
+func TestDecode_PreserveAttributeEntities(t *testing.T) {
+	src := `<root a="&#65;&amp;B" b="plain"></root>`
+
+	decoder := xmldom.NewDecoder(strings.NewReader(src))
+	decoder.SetPreserveAttributeEntities(true)
+	doc, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	root := doc.DocumentElement()
+	if got := root.GetAttribute("a"); got != "A&B" {
+		t.Errorf("decoded value = %q, want %q", got, "A&B")
+	}
+
+	out, err := xmldom.Marshal(root)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if string(out) != src {
+		t.Errorf("round trip = %s, want %s", out, src)
+	}
+
+	// Changing the value drops the recorded source text
+	root.SetAttribute("a", "C")
+	out, _ = xmldom.Marshal(root)
+	if !strings.Contains(string(out), `a="C"`) {
+		t.Errorf("modified attribute not serialized from its value: %s", out)
+	}
+
+	// Without the option the references are normalized
+	doc, _ = xmldom.NewDecoder(strings.NewReader(src)).Decode()
+	out, _ = xmldom.Marshal(doc.DocumentElement())
+	if !strings.Contains(string(out), `a="A&amp;B"`) {
+		t.Errorf("expected normalized value without the option: %s", out)
+	}
+}
+
+func TestDecode_EntityReferences(t *testing.T) {
+	src := `<!DOCTYPE note [<!ENTITY company "Acme Corp">]><note a="&company;">Made by &company; today</note>`
+
+	decoder := xmldom.NewDecoder(strings.NewReader(src))
+	decoder.SetExpandEntityReferences(false)
+	doc, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+
+	ent := doc.Doctype().Entities().GetNamedItem("company")
+	if ent == nil || ent.TextContent() != "Acme Corp" {
+		t.Fatalf("expected declared entity 'company' with value 'Acme Corp'")
+	}
+
+	note := doc.DocumentElement()
+	if got := note.GetAttribute("a"); got != "Acme Corp" {
+		t.Errorf("attribute references should be expanded, got %q", got)
+	}
+	if note.ChildNodes().Length() != 3 {
+		t.Fatalf("expected text, entity reference, text; got %d children", note.ChildNodes().Length())
+	}
+	ref := note.ChildNodes().Item(1)
+	if ref.NodeType() != xmldom.ENTITY_REFERENCE_NODE || ref.NodeName() != "company" {
+		t.Fatalf("second child should be &company;, got %s", ref.NodeName())
+	}
+	if ref.FirstChild() == nil || ref.FirstChild().NodeValue() != "Acme Corp" {
+		t.Errorf("entity reference should expose the entity's value as children")
+	}
+	if got := note.TextContent(); got != "Made by Acme Corp today" {
+		t.Errorf("TextContent() = %q", got)
+	}
+
+	// The expansion is read-only
+	if err := ref.FirstChild().SetNodeValue("x"); err == nil || !strings.HasPrefix(err.Error(), "NoModificationAllowedError") {
+		t.Errorf("modifying expansion text should fail, got %v", err)
+	}
+	if err := ref.FirstChild().(xmldom.Text).SetData("x"); err == nil {
+		t.Errorf("SetData on expansion text should fail")
+	}
+	if _, err := ref.RemoveChild(ref.FirstChild()); err == nil {
+		t.Errorf("removing expansion children should fail")
+	}
+	extra := doc.CreateTextNode("x")
+	if _, err := ref.AppendChild(extra); err == nil {
+		t.Errorf("appending to an entity reference should fail")
+	}
+
+	out, err := xmldom.Marshal(note)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if !strings.Contains(string(out), "Made by &company; today") {
+		t.Errorf("entity reference not serialized: %s", out)
+	}
+
+	// By default declared entities are expanded in place
+	doc, err = xmldom.NewDecoder(strings.NewReader(src)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if got := doc.DocumentElement().TextContent(); got != "Made by Acme Corp today" || doc.DocumentElement().ChildNodes().Length() != 1 {
+		t.Errorf("expanded content = %q", got)
+	}
+}
+
+func TestDecode_EntityReferencesWithPrivateUseText(t *testing.T) {
+	// Private-use characters in the document's own text, literal and as
+	// character references, must not be mistaken for entity references
+	src := "<!DOCTYPE r [<!ENTITY e \"E\">]><r a=\"\uE000x\uE000\">\uE000e\uE000 &#xE001;&e;&#57346;\uE003</r>"
+
+	decoder := xmldom.NewDecoder(strings.NewReader(src))
+	decoder.SetExpandEntityReferences(false)
+	doc, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+
+	r := doc.DocumentElement()
+	if got := r.GetAttribute("a"); got != "\uE000x\uE000" {
+		t.Errorf("attribute value = %q", got)
+	}
+	var kinds []string
+	for child := r.FirstChild(); child != nil; child = child.NextSibling() {
+		kinds = append(kinds, string(child.NodeName()))
+	}
+	if got := strings.Join(kinds, ","); got != "#text,e,#text" {
+		t.Fatalf("children = %s, want #text,e,#text", got)
+	}
+	if got := r.TextContent(); got != "\uE000e\uE000 \uE001E\uE002\uE003" {
+		t.Errorf("TextContent() = %q", got)
+	}
+}
+
+func TestDecode_NormalizeAttributeValues(t *testing.T) {
+	src := "<root a=\"one\ntwo\tthree&#10;four\r\nfive\"/>"
+
+	doc, err := xmldom.NewDecoder(strings.NewReader(src)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if got, want := doc.DocumentElement().GetAttribute("a"), xmldom.DOMString("one two three\nfour five"); got != want {
+		t.Errorf("normalized value = %q, want %q", got, want)
+	}
+
+	decoder := xmldom.NewDecoder(strings.NewReader(src))
+	decoder.SetNormalizeAttributeValues(false)
+	doc, err = decoder.Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if got, want := doc.DocumentElement().GetAttribute("a"), xmldom.DOMString("one\ntwo\tthree\nfour\nfive"); got != want {
+		t.Errorf("raw value = %q, want %q", got, want)
+	}
+}
+
+func TestDecode_AttributesSharingLocalName(t *testing.T) {
+	doc, err := xmldom.NewDecoder(strings.NewReader(`<r xmlns:xsi="urn:xsi" xsi:type="t" type="u"/>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	r := doc.DocumentElement()
+	if got := r.GetAttributeNS("", "type"); got != "u" {
+		t.Errorf("GetAttributeNS(\"\", type) = %q, want %q", got, "u")
+	}
+	if got := r.GetAttributeNS("urn:xsi", "type"); got != "t" {
+		t.Errorf("GetAttributeNS(urn:xsi, type) = %q, want %q", got, "t")
+	}
+
+	// The default namespace declaration next to a prefixed one
+	src := `<r xmlns:p="urn:p" xmlns="urn:d"/>`
+	doc, err = xmldom.NewDecoder(strings.NewReader(src)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	r = doc.DocumentElement()
+	if got := r.GetAttribute("xmlns"); got != "urn:d" {
+		t.Errorf("GetAttribute(xmlns) = %q, want %q", got, "urn:d")
+	}
+	if r.NamespaceURI() != "urn:d" {
+		t.Errorf("NamespaceURI() = %q, want %q", r.NamespaceURI(), "urn:d")
+	}
+	out, err := xmldom.Marshal(r)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if string(out) != `<r xmlns:p="urn:p" xmlns="urn:d"></r>` {
+		t.Errorf("Marshal() = %s", out)
+	}
+
+	// Normalization works from each attribute's own source text
+	decoder := xmldom.NewDecoder(strings.NewReader("<r xmlns:p=\"urn:p\" p:a=\"x\ty\" a=\"1&#9;2\"/>"))
+	doc, err = decoder.Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	r = doc.DocumentElement()
+	if got := r.GetAttributeNS("urn:p", "a"); got != "x y" {
+		t.Errorf("p:a = %q, want %q", got, "x y")
+	}
+	if got := r.GetAttributeNS("", "a"); got != "1\t2" {
+		t.Errorf("a = %q, want %q", got, "1\t2")
+	}
+}
+
+func TestDecode_IndexesNestedIds(t *testing.T) {
+	xmlStr := `<root id="r"><a><b><c><d id="deep">leaf</d></c></b><e id="sibling"/></a></root>`
+	doc, err := xmldom.NewDecoder(strings.NewReader(xmlStr)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+
+	for id, tag := range map[xmldom.DOMString]xmldom.DOMString{"r": "root", "deep": "d", "sibling": "e"} {
+		elem := doc.GetElementById(id)
+		if elem == nil {
+			t.Errorf("GetElementById(%q) returned nil", id)
+			continue
+		}
+		if elem.TagName() != tag {
+			t.Errorf("GetElementById(%q) = <%s>, want <%s>", id, elem.TagName(), tag)
+		}
+	}
+	if deep := doc.GetElementById("deep"); deep != nil && deep.TextContent() != "leaf" {
+		t.Errorf("deep element text = %q, want %q", deep.TextContent(), "leaf")
+	}
+}
+
+func TestDecode_LenientCollectsErrors(t *testing.T) {
+	xmlStr := "<root>\n<a>fish & chips</a>\n<b><c>text</b>\n<d/></root>"
+
+	decoder := xmldom.NewDecoderWithOptions(strings.NewReader(xmlStr), &xmldom.DecoderOptions{Strict: false})
+	doc, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("lenient Decode() failed: %v", err)
+	}
+	errs := decoder.Errors()
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 recovered errors, got %d: %v", len(errs), errs)
+	}
+	if !strings.Contains(errs[0].Error(), "line 2") || !strings.Contains(errs[0].Error(), "stray &") {
+		t.Errorf("first error should be the stray & on line 2, got %v", errs[0])
+	}
+	if !strings.Contains(errs[1].Error(), "line 3") || !strings.Contains(errs[1].Error(), "<c> closed by </b>") {
+		t.Errorf("second error should be the unclosed <c> on line 3, got %v", errs[1])
+	}
+
+	// The partial document keeps the content around the errors
+	if got := doc.GetElementsByTagName("a").Item(0).TextContent(); got != "fish & chips" {
+		t.Errorf("text with a stray & = %q, want %q", got, "fish & chips")
+	}
+	if doc.GetElementsByTagName("c").Length() != 1 || doc.GetElementsByTagName("d").Length() != 1 {
+		t.Errorf("elements around the mismatched tag should be kept")
+	}
+
+	// A strict decoder stops at the first error
+	strict := xmldom.NewDecoder(strings.NewReader(xmlStr))
+	if _, err := strict.Decode(); err == nil {
+		t.Errorf("strict Decode() should fail")
+	}
+	if len(strict.Errors()) != 0 {
+		t.Errorf("strict decoder should not collect errors, got %v", strict.Errors())
+	}
+}
+
+func TestDecoder_Reset(t *testing.T) {
+	decoder := xmldom.NewDecoder(strings.NewReader(`<!DOCTYPE note [<!ENTITY company "Acme Corp">]><note a="x
+y">Made by &company;</note>`))
+	decoder.SetExpandEntityReferences(false)
+	decoder.SetNormalizeAttributeValues(false)
+	first, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("first Decode() failed: %v", err)
+	}
+
+	decoder.Reset(strings.NewReader("<list>\n  <item b=\"1\t2\"/>\n</list>"))
+	second, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("Decode() after Reset failed: %v", err)
+	}
+
+	note := first.DocumentElement()
+	if note.NodeName() != "note" || note.GetAttribute("a") != "x\ny" || note.ChildNodes().Item(1).NodeType() != xmldom.ENTITY_REFERENCE_NODE {
+		t.Errorf("first document changed by Reset: %s", note.NodeName())
+	}
+
+	list := second.DocumentElement()
+	if list.NodeName() != "list" || second.Doctype() != nil {
+		t.Fatalf("second document root = %s, want list without a doctype", list.NodeName())
+	}
+	item := list.GetElementsByTagName("item").Item(0).(xmldom.Element)
+	if got := item.GetAttribute("b"); got != "1\t2" {
+		t.Errorf("whitespace mode should survive Reset, got %q", got)
+	}
+	if line, _, _ := item.Position(); line != 2 {
+		t.Errorf("item line = %d, want 2", line)
+	}
+
+	// An entity declared by the first document is not carried over
+	decoder.Reset(strings.NewReader(`<note>&company;</note>`))
+	if _, err := decoder.Decode(); err == nil {
+		t.Errorf("undeclared entity after Reset should fail")
+	}
+}
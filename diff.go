@@ -0,0 +1,189 @@
+package xmldom
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FirstDifference walks a and b in parallel and reports the first structural
+// difference between them. path is an XPath-like location of the differing
+// node in a (or in b when the node only exists there), and reason is a short
+// human-readable description such as a differing tag, attribute value, text
+// or an extra or missing child. When the trees are equal, equal is true and
+// path and reason are empty.
+func FirstDifference(a, b Node) (path string, reason string, equal bool) {
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return "", "", true
+		}
+		return "/", "one of the nodes is nil", false
+	}
+
+	root := ""
+	if a.NodeType() != DOCUMENT_NODE {
+		root = "/" + pathStep(a)
+	}
+	path, reason = firstDifference(a, b, root)
+	if reason == "" {
+		return "", "", true
+	}
+	if path == "" {
+		path = "/"
+	}
+	return path, reason, false
+}
+
+func firstDifference(a, b Node, path string) (string, string) {
+	if a.NodeType() != b.NodeType() {
+		return path, fmt.Sprintf("node type %d differs from %d", a.NodeType(), b.NodeType())
+	}
+
+	switch a.NodeType() {
+	case ELEMENT_NODE:
+		if a.NodeName() != b.NodeName() || a.NamespaceURI() != b.NamespaceURI() {
+			return path, fmt.Sprintf("element <%s> differs from <%s>", describeName(a), describeName(b))
+		}
+		if p, r := attributeDifference(a, b, path); r != "" {
+			return p, r
+		}
+	case TEXT_NODE, CDATA_SECTION_NODE, COMMENT_NODE:
+		if a.NodeValue() != b.NodeValue() {
+			return path, fmt.Sprintf("%s %q differs from %q", valueKind(a), a.NodeValue(), b.NodeValue())
+		}
+	case PROCESSING_INSTRUCTION_NODE:
+		if a.NodeName() != b.NodeName() || a.NodeValue() != b.NodeValue() {
+			return path, fmt.Sprintf("processing instruction <?%s %s?> differs from <?%s %s?>",
+				a.NodeName(), a.NodeValue(), b.NodeName(), b.NodeValue())
+		}
+	default:
+		if a.NodeName() != b.NodeName() {
+			return path, fmt.Sprintf("node name %q differs from %q", a.NodeName(), b.NodeName())
+		}
+	}
+
+	childA, childB := a.FirstChild(), b.FirstChild()
+	for childA != nil && childB != nil {
+		if p, r := firstDifference(childA, childB, path+"/"+pathStep(childA)); r != "" {
+			return p, r
+		}
+		childA, childB = childA.NextSibling(), childB.NextSibling()
+	}
+	if childA != nil {
+		return path + "/" + pathStep(childA), fmt.Sprintf("child %s is missing from the second tree", describeNode(childA))
+	}
+	if childB != nil {
+		return path + "/" + pathStep(childB), fmt.Sprintf("child %s is missing from the first tree", describeNode(childB))
+	}
+	return "", ""
+}
+
+// attributeDifference compares the attributes of two elements by qualified name
+func attributeDifference(a, b Node, path string) (string, string) {
+	attrsA, attrsB := a.Attributes(), b.Attributes()
+	if attrsA != nil {
+		for i := uint(0); i < attrsA.Length(); i++ {
+			attrA := attrsA.Item(i)
+			var attrB Node
+			if attrsB != nil {
+				attrB = attrsB.GetNamedItem(attrA.NodeName())
+			}
+			attrPath := path + "/@" + string(attrA.NodeName())
+			if attrB == nil {
+				return attrPath, fmt.Sprintf("attribute %q is missing from the second tree", attrA.NodeName())
+			}
+			if attrA.NodeValue() != attrB.NodeValue() {
+				return attrPath, fmt.Sprintf("attribute %q value %q differs from %q", attrA.NodeName(), attrA.NodeValue(), attrB.NodeValue())
+			}
+		}
+	}
+	if attrsB != nil {
+		for i := uint(0); i < attrsB.Length(); i++ {
+			attrB := attrsB.Item(i)
+			if attrsA == nil || attrsA.GetNamedItem(attrB.NodeName()) == nil {
+				return path + "/@" + string(attrB.NodeName()), fmt.Sprintf("attribute %q is missing from the first tree", attrB.NodeName())
+			}
+		}
+	}
+	return "", ""
+}
+
+// nodePath returns the XPath-like location of n from its root, in the
+// format used by FirstDifference
+func nodePath(n Node) string {
+	var steps []string
+	for current := n; current != nil && current.NodeType() != DOCUMENT_NODE; current = current.ParentNode() {
+		steps = append(steps, pathStep(current))
+	}
+	var b strings.Builder
+	for i := len(steps) - 1; i >= 0; i-- {
+		b.WriteString("/")
+		b.WriteString(steps[i])
+	}
+	return b.String()
+}
+
+// pathStep returns the location step for n relative to its parent, with a
+// 1-based index when siblings share the same step name
+func pathStep(n Node) string {
+	name := stepName(n)
+	parent := n.ParentNode()
+	if parent == nil {
+		return name
+	}
+	index, total := 0, 0
+	for sibling := parent.FirstChild(); sibling != nil; sibling = sibling.NextSibling() {
+		if stepName(sibling) == name {
+			total++
+			if isSameNode(sibling, n) {
+				index = total
+			}
+		}
+	}
+	if total > 1 {
+		return fmt.Sprintf("%s[%d]", name, index)
+	}
+	return name
+}
+
+func stepName(n Node) string {
+	switch n.NodeType() {
+	case TEXT_NODE, CDATA_SECTION_NODE:
+		return "text()"
+	case COMMENT_NODE:
+		return "comment()"
+	case PROCESSING_INSTRUCTION_NODE:
+		return "processing-instruction()"
+	default:
+		return string(n.NodeName())
+	}
+}
+
+func describeName(n Node) string {
+	if ns := n.NamespaceURI(); ns != "" {
+		return fmt.Sprintf("{%s}%s", ns, n.NodeName())
+	}
+	return string(n.NodeName())
+}
+
+func describeNode(n Node) string {
+	switch n.NodeType() {
+	case ELEMENT_NODE:
+		return "<" + describeName(n) + ">"
+	case TEXT_NODE, CDATA_SECTION_NODE, COMMENT_NODE:
+		return fmt.Sprintf("%s %q", valueKind(n), n.NodeValue())
+	default:
+		return string(n.NodeName())
+	}
+}
+
+// valueKind names the type of a character data node in difference reasons
+func valueKind(n Node) string {
+	switch n.NodeType() {
+	case CDATA_SECTION_NODE:
+		return "CDATA section"
+	case COMMENT_NODE:
+		return "comment"
+	default:
+		return "text"
+	}
+}
@@ -0,0 +1,98 @@
+package xmldom_test
+
+import (
+	"testing"
+
+	"github.com/gogo-agent/xmldom"
+)
+
+func mustParse(t *testing.T, s string) xmldom.Document {
+	t.Helper()
+	doc, err := xmldom.UnmarshalDOM([]byte(s))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM(%q) failed: %v", s, err)
+	}
+	return doc
+}
+
+func TestFirstDifference(t *testing.T) {
+	tests := []struct {
+		name       string
+		a, b       string
+		wantPath   string
+		wantReason string
+	}{
+		{
+			name:       "attribute mismatch",
+			a:          `<root><item id="1"/><item id="2" kind="x"/></root>`,
+			b:          `<root><item id="1"/><item id="2" kind="y"/></root>`,
+			wantPath:   "/root/item[2]/@kind",
+			wantReason: `attribute "kind" value "x" differs from "y"`,
+		},
+		{
+			name:       "missing child",
+			a:          `<root><a/><b/></root>`,
+			b:          `<root><a/></root>`,
+			wantPath:   "/root/b",
+			wantReason: "child <b> is missing from the second tree",
+		},
+		{
+			name:       "extra child",
+			a:          `<root><a/></root>`,
+			b:          `<root><a/><c/></root>`,
+			wantPath:   "/root/c",
+			wantReason: "child <c> is missing from the first tree",
+		},
+		{
+			name:       "different tag",
+			a:          `<root><a/></root>`,
+			b:          `<root><b/></root>`,
+			wantPath:   "/root/a",
+			wantReason: "element <a> differs from <b>",
+		},
+		{
+			name:       "different text",
+			a:          `<root><a>one</a></root>`,
+			b:          `<root><a>two</a></root>`,
+			wantPath:   "/root/a/text()",
+			wantReason: `text "one" differs from "two"`,
+		},
+		{
+			name:       "different comment",
+			a:          `<root><!--one--></root>`,
+			b:          `<root><!--two--></root>`,
+			wantPath:   "/root/comment()",
+			wantReason: `comment "one" differs from "two"`,
+		},
+		{
+			name:       "missing comment",
+			a:          `<root><a/><!--note--></root>`,
+			b:          `<root><a/></root>`,
+			wantPath:   "/root/comment()",
+			wantReason: `child comment "note" is missing from the second tree`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, reason, equal := xmldom.FirstDifference(mustParse(t, tt.a), mustParse(t, tt.b))
+			if equal {
+				t.Fatalf("documents should differ")
+			}
+			if path != tt.wantPath {
+				t.Errorf("path = %q, want %q", path, tt.wantPath)
+			}
+			if reason != tt.wantReason {
+				t.Errorf("reason = %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestFirstDifferenceEqual(t *testing.T) {
+	src := `<root a="1"><x>text</x><!--c--><y/></root>`
+	path, reason, equal := xmldom.FirstDifference(mustParse(t, src), mustParse(t, src))
+	if !equal || path != "" || reason != "" {
+		t.Errorf("identical documents reported as different: %q %q", path, reason)
+	}
+}
@@ -1,27 +1,93 @@
 package xmldom
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// LineEnding selects the line separator written by an Encoder.
+type LineEnding int
+
+const (
+	// LineEndingLF writes "\n" line separators (the default).
+	LineEndingLF LineEnding = iota
+	// LineEndingCRLF writes "\r\n" line separators.
+	LineEndingCRLF
+	// LineEndingPreserve writes line separators exactly as produced.
+	LineEndingPreserve
+)
+
+// EmptyElementStyle selects how an Encoder writes elements with no
+// children.
+type EmptyElementStyle int
+
+const (
+	// EmptyElementExpanded writes a start and an end tag, as in <e></e>
+	// (the default).
+	EmptyElementExpanded EmptyElementStyle = iota
+	// EmptyElementSelfClosing writes an empty-element tag, as in <e/>.
+	EmptyElementSelfClosing
 )
 
 // Encoder writes DOM nodes as XML to an output stream.
 type Encoder struct {
-	e *xml.Encoder
-	w io.Writer
+	e   *xml.Encoder
+	w   io.Writer
+	lw  *lineEndingWriter
+	out io.Writer // the destination passed to NewEncoder
+
+	trailingNewline bool
+	emptyStyle      EmptyElementStyle
+
+	// encoding names the output encoding, "" meaning UTF-8
+	encoding   string
+	writeBOM   bool
+	bomWritten bool
+
+	// xmlDecl is set when SetXMLDeclaration asked for a declaration with
+	// the given pseudo-attributes
+	xmlDecl        bool
+	declVersion    DOMString
+	declEncoding   DOMString
+	declStandalone *bool
+
+	// namespaces holds the bindings assumed at the serialization root and
+	// scope those in effect at the element being written; "" stands for
+	// the default namespace
+	namespaces map[DOMString]DOMString
+	scope      map[DOMString]DOMString
+
+	tagBuf bytes.Buffer // reused by writeStartTag
 }
 
 // NewEncoder returns a new encoder that writes to w.
 func NewEncoder(w io.Writer) *Encoder {
+	lw := &lineEndingWriter{w: w}
 	enc := &Encoder{
-		e: xml.NewEncoder(w),
-		w: w,
+		e:   xml.NewEncoder(lw),
+		w:   lw,
+		lw:  lw,
+		out: w,
 	}
 	enc.e.Indent("", "  ")
 	return enc
 }
 
+// SetLineEnding sets the line separator used in indented output and in
+// serialized character data. The default is LineEndingLF.
+func (enc *Encoder) SetLineEnding(le LineEnding) {
+	enc.lw.le = le
+}
+
 // SetIndent sets the indentation for the encoder.
 // The prefix is written at the beginning of each line except the first.
 // The indent string is written for each level of indentation.
@@ -29,10 +95,173 @@ func (enc *Encoder) SetIndent(prefix, indent string) {
 	enc.e.Indent(prefix, indent)
 }
 
+// SetTrailingNewline controls whether Encode writes a single newline after
+// a Document. The default is false.
+func (enc *Encoder) SetTrailingNewline(enabled bool) {
+	enc.trailingNewline = enabled
+}
+
+// SetEmptyElementStyle sets how elements with no child nodes are written.
+// The default is EmptyElementExpanded, the form the Encoder wrote before
+// the style could be chosen.
+func (enc *Encoder) SetEmptyElementStyle(style EmptyElementStyle) {
+	enc.emptyStyle = style
+}
+
+// SetWriteBOM controls whether the first Encode starts the stream with the
+// byte-order mark of the output encoding. UTF-8 and UTF-16 have one; for
+// other encodings nothing is written. When SetOutputEncoding was not called
+// and the first node is a Document whose CharacterSet is UTF-16, UTF-16BE
+// or UTF-16LE, the stream is written in that encoding, since its mark must
+// be followed by UTF-16 text. The default is false.
+func (enc *Encoder) SetWriteBOM(enabled bool) {
+	enc.writeBOM = enabled
+}
+
+// SetOutputEncoding makes the encoder write its output in the named
+// encoding instead of UTF-8. transcode wraps the destination in a writer
+// that converts UTF-8 to that encoding, such as the Writer method of a new
+// golang.org/x/text encoder. It is called more than once, so each call must
+// return a writer with state of its own. Characters transcode cannot represent are
+// written as numeric character references in character data and attribute
+// values; anywhere else, such as in names, comments and CDATA sections,
+// Encode fails with InvalidCharacterError. Documents are preceded by an XML
+// declaration naming the encoding. A nil transcode restores UTF-8.
+func (enc *Encoder) SetOutputEncoding(name string, transcode func(io.Writer) io.Writer) {
+	if transcode == nil {
+		enc.encoding = ""
+		enc.lw.w = enc.out
+		return
+	}
+	enc.encoding = name
+	enc.lw.w = &charRefWriter{w: transcode(enc.out), out: enc.out, transcode: transcode}
+}
+
+// SetXMLDeclaration makes Encode write an XML declaration before each
+// Document, so the output stands alone as a well-formed document. Other
+// nodes are written without one. An empty version is written as "1.0" and
+// an empty encoding as the name of the output encoding, UTF-8 unless
+// SetOutputEncoding chose another. The version must be "1.0" or "1.1", and
+// a non-empty encoding must name the output encoding, compared without
+// regard to case; otherwise Encode fails with NotSupportedError before
+// writing the Document. The standalone pseudo-attribute is written only
+// when standalone is not nil.
+func (enc *Encoder) SetXMLDeclaration(version, encoding DOMString, standalone *bool) {
+	enc.xmlDecl = true
+	enc.declVersion = version
+	enc.declEncoding = encoding
+	enc.declStandalone = standalone
+}
+
+// declaration returns the XML declaration written before a Document, if
+// any. One is written when SetXMLDeclaration asked for it or the output is
+// not UTF-8.
+func (enc *Encoder) declaration() (xml.ProcInst, bool, error) {
+	if !enc.xmlDecl && enc.encoding == "" {
+		return xml.ProcInst{}, false, nil
+	}
+	version := enc.declVersion
+	switch version {
+	case "":
+		version = "1.0"
+	case "1.0", "1.1":
+	default:
+		return xml.ProcInst{}, false, NewDOMException("NotSupportedError", fmt.Sprintf("XML version %q is not supported", version))
+	}
+	encoding := DOMString(enc.encoding)
+	if encoding == "" {
+		encoding = "UTF-8"
+	}
+	if enc.declEncoding != "" {
+		if !strings.EqualFold(string(enc.declEncoding), string(encoding)) {
+			return xml.ProcInst{}, false, NewDOMException("NotSupportedError", fmt.Sprintf("declared encoding %q does not match the output encoding %s", enc.declEncoding, encoding))
+		}
+		encoding = enc.declEncoding
+	}
+	inst := `version="` + string(version) + `" encoding="` + string(encoding) + `"`
+	if enc.declStandalone != nil {
+		if *enc.declStandalone {
+			inst += ` standalone="yes"`
+		} else {
+			inst += ` standalone="no"`
+		}
+	}
+	return xml.ProcInst{Target: "xml", Inst: []byte(inst)}, true, nil
+}
+
+// byteOrderMark returns the byte-order mark for the named encoding, or nil
+// if it has none
+func byteOrderMark(encoding string) []byte {
+	switch strings.ToUpper(encoding) {
+	case "", "UTF-8", "UTF8":
+		return []byte{0xEF, 0xBB, 0xBF}
+	case "UTF-16", "UTF-16BE":
+		return []byte{0xFE, 0xFF}
+	case "UTF-16LE":
+		return []byte{0xFF, 0xFE}
+	}
+	return nil
+}
+
+// utf16Encoding returns the named UTF-16 encoding without a byte-order
+// mark of its own, or nil if the name is not a UTF-16 encoding
+func utf16Encoding(name string) encoding.Encoding {
+	switch strings.ToUpper(name) {
+	case "UTF-16", "UTF-16BE":
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	case "UTF-16LE":
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	}
+	return nil
+}
+
+// SetNamespaceContext sets the prefix to namespace URI bindings assumed to
+// be in scope where serialization starts, with "" standing for the default
+// namespace. Declarations are written only where a binding differs from
+// those already in effect, so a fragment or detached subtree can be written
+// for insertion into a known context without redundant declarations.
+func (enc *Encoder) SetNamespaceContext(ctx map[DOMString]DOMString) {
+	enc.namespaces = make(map[DOMString]DOMString, len(ctx))
+	for prefix, uri := range ctx {
+		enc.namespaces[prefix] = uri
+	}
+}
+
 // Encode writes the XML encoding of node to the stream.
 func (enc *Encoder) Encode(node Node) error {
+	if enc.writeBOM && !enc.bomWritten {
+		enc.bomWritten = true
+		if enc.encoding == "" && node.NodeType() == DOCUMENT_NODE {
+			charset := string(node.(Document).CharacterSet())
+			if e := utf16Encoding(charset); e != nil {
+				enc.SetOutputEncoding(charset, func(w io.Writer) io.Writer {
+					return e.NewEncoder().Writer(w)
+				})
+			}
+		}
+		if bom := byteOrderMark(enc.encoding); bom != nil {
+			if _, err := enc.out.Write(bom); err != nil {
+				return err
+			}
+		}
+	}
+
+	enc.scope = map[DOMString]DOMString{"xml": xmlNamespaceURI}
+	for prefix, uri := range enc.namespaces {
+		enc.scope[prefix] = uri
+	}
+
 	if node.NodeType() == DOCUMENT_NODE {
 		doc := node.(Document)
+		decl, ok, err := enc.declaration()
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := enc.e.EncodeToken(decl); err != nil {
+				return err
+			}
+		}
 		if doc.Doctype() != nil {
 			if err := enc.encodeDoctype(doc.Doctype()); err != nil {
 				return err
@@ -44,7 +273,21 @@ func (enc *Encoder) Encode(node Node) error {
 		return err
 	}
 
-	return enc.e.Flush()
+	if err := enc.e.Flush(); err != nil {
+		return err
+	}
+	if enc.trailingNewline && node.NodeType() == DOCUMENT_NODE {
+		if _, err := io.WriteString(enc.w, "\n"); err != nil {
+			return err
+		}
+	}
+	if err := enc.lw.flush(); err != nil {
+		return err
+	}
+	if cw, ok := enc.lw.w.(*charRefWriter); ok {
+		return cw.close()
+	}
+	return nil
 }
 
 func (enc *Encoder) encodeNode(node Node) error {
@@ -57,20 +300,63 @@ func (enc *Encoder) encodeNode(node Node) error {
 		return enc.encodeElement(node.(Element))
 
 	case TEXT_NODE:
-		return enc.e.EncodeToken(xml.CharData(node.NodeValue()))
+		return enc.encodeText(string(node.NodeValue()))
 
 	case COMMENT_NODE:
+		if err := checkComment(node.NodeValue()); err != nil {
+			return err
+		}
+		if err := enc.checkEncodable("comment", node.NodeValue()); err != nil {
+			return err
+		}
 		return enc.e.EncodeToken(xml.Comment(node.NodeValue()))
 
+	case ENTITY_REFERENCE_NODE:
+		// Written directly, like CDATA, since xml.Encoder would escape the '&'
+		if err := enc.checkEncodable("entity reference", node.NodeName()); err != nil {
+			return err
+		}
+		if err := enc.e.Flush(); err != nil {
+			return err
+		}
+		_, err := io.WriteString(enc.w, "&"+string(node.NodeName())+";")
+		return err
+
 	case CDATA_SECTION_NODE:
 		// CDATA sections must be written manually since Go's xml.Encoder
 		// doesn't provide a CDATA token type and would escape the content
-		// if we used xml.CharData
-		_, err := fmt.Fprintf(enc.w, "<![CDATA[%s]]>", string(node.NodeValue()))
+		// if we used xml.CharData. Flush first so the raw write lands
+		// after everything already buffered by the xml.Encoder.
+		if err := enc.checkEncodable("CDATA section", node.NodeValue()); err != nil {
+			return err
+		}
+		if err := enc.e.Flush(); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(enc.w, "<![CDATA["); err != nil {
+			return err
+		}
+		// A "]]>" in the data would end the section early, so the section
+		// is closed between "]]" and ">" and a new one opened
+		data := strings.ReplaceAll(string(node.NodeValue()), "]]>", "]]]]><![CDATA[>")
+		for len(data) > 0 {
+			n := chunkLen(data)
+			if _, err := io.WriteString(enc.w, data[:n]); err != nil {
+				return err
+			}
+			data = data[n:]
+		}
+		_, err := io.WriteString(enc.w, "]]>")
 		return err
 
 	case PROCESSING_INSTRUCTION_NODE:
 		pi := node.(ProcessingInstruction)
+		if err := checkProcInst(pi.Data()); err != nil {
+			return err
+		}
+		if err := enc.checkEncodable("processing instruction", pi.Target()+" "+pi.Data()); err != nil {
+			return err
+		}
 		return enc.e.EncodeToken(xml.ProcInst{
 			Target: string(pi.Target()),
 			Inst:   []byte(pi.Data()),
@@ -96,34 +382,92 @@ func (enc *Encoder) encodeNode(node Node) error {
 	return nil
 }
 
+// checkComment returns InvalidStateError if data cannot be written as a
+// comment: it may not contain "--" or end in "-"
+func checkComment(data DOMString) error {
+	if strings.Contains(string(data), "--") || strings.HasSuffix(string(data), "-") {
+		return NewDOMException("InvalidStateError", fmt.Sprintf("comment %q cannot be serialized", data))
+	}
+	return nil
+}
+
+// checkProcInst returns InvalidStateError if data would end the processing
+// instruction early
+func checkProcInst(data DOMString) error {
+	if strings.Contains(string(data), "?>") {
+		return NewDOMException("InvalidStateError", fmt.Sprintf("processing instruction data %q cannot be serialized", data))
+	}
+	return nil
+}
+
+// textChunkSize bounds how much character data is escaped per write, so
+// very large text nodes are streamed instead of copied in one piece.
+const textChunkSize = 32 * 1024
+
+// encodeText writes character data in chunks of at most textChunkSize bytes,
+// reusing a single buffer across chunks.
+func (enc *Encoder) encodeText(data string) error {
+	if len(data) <= textChunkSize {
+		return enc.e.EncodeToken(xml.CharData(data))
+	}
+	buf := make([]byte, 0, textChunkSize)
+	for len(data) > 0 {
+		n := chunkLen(data)
+		buf = append(buf[:0], data[:n]...)
+		if err := enc.e.EncodeToken(xml.CharData(buf)); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// chunkLen returns the length of the next chunk of s, cut at a rune boundary
+func chunkLen(s string) int {
+	if len(s) <= textChunkSize {
+		return len(s)
+	}
+	n := textChunkSize
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	if n == 0 {
+		return textChunkSize
+	}
+	return n
+}
+
 func (enc *Encoder) encodeElement(elem Element) error {
-	// Create start element
+	parentScope := enc.scope
+	defer func() { enc.scope = parentScope }()
+
+	tag := newStartTag(elem, enc.scope)
 	start := xml.StartElement{
-		Name: xml.Name{
-			Space: string(elem.NamespaceURI()),
-			Local: string(elem.LocalName()),
-		},
-	}
-
-	// Add attributes
-	if attrs := elem.Attributes(); attrs != nil {
-		for i := uint(0); i < attrs.Length(); i++ {
-			attr := attrs.Item(i)
-			if attr != nil && attr.NodeType() == ATTRIBUTE_NODE {
-				a := attr.(Attr)
-				start.Attr = append(start.Attr, xml.Attr{
-					Name: xml.Name{
-						Space: string(attr.NamespaceURI()),
-						Local: string(a.LocalName()),
-					},
-					Value: string(a.Value()),
-				})
-			}
+		Name: xml.Name{Local: tag.name},
+		Attr: tag.decls,
+	}
+	for i, a := range tag.attrs {
+		start.Attr = append(start.Attr, xml.Attr{
+			Name:  xml.Name{Local: tag.attrNames[i]},
+			Value: string(a.Value()),
+		})
+	}
+	enc.scope = tag.scope
+	if err := enc.checkEncodable("element name", DOMString(start.Name.Local)); err != nil {
+		return err
+	}
+	for _, a := range start.Attr {
+		if err := enc.checkEncodable("attribute name", DOMString(a.Name.Local)); err != nil {
+			return err
 		}
 	}
 
+	if enc.emptyStyle == EmptyElementSelfClosing && !elem.HasChildNodes() {
+		return enc.writeStartTag(start, true)
+	}
+
 	// Encode start element
-	if err := enc.e.EncodeToken(start); err != nil {
+	if err := enc.writeStartTag(start, false); err != nil {
 		return err
 	}
 
@@ -138,20 +482,427 @@ func (enc *Encoder) encodeElement(elem Element) error {
 	return enc.e.EncodeToken(xml.EndElement{Name: start.Name})
 }
 
+// writeStartTag writes start, or with selfClosing an empty-element tag
+// for it. xml.Encoder escapes attribute values as text and only writes
+// start and end tags, so when there are attributes or the tag closes
+// itself, the tag is written without attributes and captured, with any
+// indentation before it. Its '>' is then replaced with the attributes,
+// escaped by EscapeAttrValue, and '>' or "/>"; the end tag the encoder
+// writes for an empty-element tag is discarded.
+func (enc *Encoder) writeStartTag(start xml.StartElement, selfClosing bool) error {
+	if len(start.Attr) == 0 && !selfClosing {
+		return enc.e.EncodeToken(start)
+	}
+	if err := enc.e.Flush(); err != nil {
+		return err
+	}
+	out := enc.lw.w
+	defer func() { enc.lw.w = out }()
+
+	tag := &enc.tagBuf
+	tag.Reset()
+	enc.lw.w = tag
+	if err := enc.e.EncodeToken(xml.StartElement{Name: start.Name}); err != nil {
+		return err
+	}
+	if err := enc.e.Flush(); err != nil {
+		return err
+	}
+	if selfClosing {
+		enc.lw.w = io.Discard
+		if err := enc.e.EncodeToken(xml.EndElement{Name: start.Name}); err != nil {
+			return err
+		}
+		if err := enc.e.Flush(); err != nil {
+			return err
+		}
+	}
+
+	tag.Truncate(tag.Len() - 1)
+	for _, a := range start.Attr {
+		tag.WriteByte(' ')
+		tag.WriteString(a.Name.Local)
+		tag.WriteString(`="`)
+		tag.WriteString(EscapeAttrValue(a.Value))
+		tag.WriteByte('"')
+	}
+	if selfClosing {
+		tag.WriteString("/>")
+	} else {
+		tag.WriteByte('>')
+	}
+	_, err := out.Write(tag.Bytes())
+	return err
+}
+
+// startTag holds the names and namespace declarations written for an
+// element's start tag
+type startTag struct {
+	name      string
+	decls     []xml.Attr // namespace declarations, written before attrs
+	attrs     []Attr     // the other attributes, in order
+	attrNames []string   // the names written for attrs
+	scope     map[DOMString]DOMString
+}
+
+// newStartTag works out the start tag of elem under the bindings in scope,
+// and the bindings in effect inside it. Declarations written on the element
+// come first so their prefixes are kept; those repeating a binding already
+// in scope are dropped.
+func newStartTag(elem Element, scope map[DOMString]DOMString) startTag {
+	ns := &namespaceFixup{scope: scope}
+	var tag startTag
+	if nodes := elem.Attributes(); nodes != nil {
+		for i := uint(0); i < nodes.Length(); i++ {
+			a, ok := nodes.Item(i).(Attr)
+			if !ok {
+				continue
+			}
+			if !IsNamespaceDeclaration(a) {
+				tag.attrs = append(tag.attrs, a)
+				continue
+			}
+			// A declaration made with SetAttribute has no local name, so
+			// the prefix is taken from the node name
+			prefix := DOMString(strings.TrimPrefix(string(a.NodeName()), "xmlns:"))
+			if a.NodeName() == "xmlns" {
+				prefix = ""
+			}
+			ns.declare(prefix, a.Value())
+		}
+	}
+
+	tag.name = ns.qualify(elem.Prefix(), elem.NamespaceURI(), false, localNameOrName(elem))
+	tag.attrNames = make([]string, len(tag.attrs))
+	for i, a := range tag.attrs {
+		tag.attrNames[i] = ns.qualify(a.Prefix(), a.NamespaceURI(), true, localNameOrName(a))
+	}
+	tag.decls = ns.decls
+	tag.scope = ns.scope
+	return tag
+}
+
+// localNameOrName returns n's local name, or its node name for nodes
+// created without namespace support, which have no local name
+func localNameOrName(n Node) DOMString {
+	if local := n.LocalName(); local != "" {
+		return local
+	}
+	return n.NodeName()
+}
+
+// namespaceFixup works out the prefixes and declarations for one element.
+// The names it returns are written as-is, so the xml.Encoder does not add
+// declarations of its own.
+type namespaceFixup struct {
+	scope  map[DOMString]DOMString
+	copied bool
+	// fixed holds the prefixes declared or used on the element, which may
+	// not be bound again there
+	fixed map[DOMString]bool
+	decls []xml.Attr
+}
+
+// declare binds prefix to uri on the element unless that binding is
+// already in scope, and reports whether the binding is in effect. A prefix
+// cannot be undeclared, so an empty uri only works for the default
+// namespace.
+func (ns *namespaceFixup) declare(prefix, uri DOMString) bool {
+	if ns.scope[prefix] == uri {
+		return true
+	}
+	if prefix != "" && uri == "" || ns.fixed[prefix] {
+		return false
+	}
+	if !ns.copied {
+		scope := make(map[DOMString]DOMString, len(ns.scope)+1)
+		for p, u := range ns.scope {
+			scope[p] = u
+		}
+		ns.scope = scope
+		ns.copied = true
+	}
+	ns.scope[prefix] = uri
+	ns.fix(prefix)
+	name := "xmlns"
+	if prefix != "" {
+		name += ":" + string(prefix)
+	}
+	ns.decls = append(ns.decls, xml.Attr{Name: xml.Name{Local: name}, Value: string(uri)})
+	return true
+}
+
+func (ns *namespaceFixup) fix(prefix DOMString) {
+	if ns.fixed == nil {
+		ns.fixed = make(map[DOMString]bool)
+	}
+	ns.fixed[prefix] = true
+}
+
+// qualify returns the name to write for a node with the given prefix,
+// namespace and local name, declaring a prefix when none in scope fits.
+// Attributes are never placed in the default namespace.
+func (ns *namespaceFixup) qualify(prefix, uri DOMString, isAttr bool, localName DOMString) string {
+	if uri == "" {
+		if !isAttr {
+			ns.declare("", "")
+			ns.fix("")
+		}
+		return string(localName)
+	}
+	ownPrefix := prefix != "" || !isAttr
+	if !ownPrefix || ns.scope[prefix] != uri {
+		// Reuse a prefix already bound to uri, choosing the same one each
+		// time, then try declaring the node's own prefix and finally make
+		// one up
+		var bound []string
+		for p, u := range ns.scope {
+			if u == uri && p != "" {
+				bound = append(bound, string(p))
+			}
+		}
+		switch {
+		case len(bound) > 0:
+			sort.Strings(bound)
+			prefix = DOMString(bound[0])
+		case ownPrefix && ns.declare(prefix, uri):
+		default:
+			for i := 1; ; i++ {
+				prefix = DOMString("ns" + strconv.Itoa(i))
+				if _, ok := ns.scope[prefix]; !ok && ns.declare(prefix, uri) {
+					break
+				}
+			}
+		}
+	}
+	ns.fix(prefix)
+	if prefix == "" {
+		return string(localName)
+	}
+	return string(prefix) + ":" + string(localName)
+}
+
+// encodeDoctype writes the document type declaration in the PUBLIC or
+// SYSTEM form, depending on which identifiers are set, followed by the
+// internal subset if there is one
 func (enc *Encoder) encodeDoctype(doctype DocumentType) error {
-	// XML encoder doesn't support DOCTYPE directly, write as string
-	// This is a simplified approach
-	docStr := "<!DOCTYPE " + string(doctype.Name())
+	decl := "DOCTYPE " + string(doctype.Name())
 	if doctype.PublicId() != "" {
-		docStr += " PUBLIC \"" + string(doctype.PublicId()) + "\""
+		decl += " PUBLIC " + quoteLiteral(doctype.PublicId())
 		if doctype.SystemId() != "" {
-			docStr += " \"" + string(doctype.SystemId()) + "\""
+			decl += " " + quoteLiteral(doctype.SystemId())
 		}
 	} else if doctype.SystemId() != "" {
-		docStr += " SYSTEM \"" + string(doctype.SystemId()) + "\""
+		decl += " SYSTEM " + quoteLiteral(doctype.SystemId())
 	}
-	docStr += ">"
+	if subset := doctype.InternalSubset(); subset != "" {
+		decl += " [" + string(subset) + "]"
+	}
+	if err := enc.checkEncodable("document type declaration", DOMString(decl)); err != nil {
+		return err
+	}
+	return enc.e.EncodeToken(xml.Directive(decl))
+}
 
-	// Write directly as a comment (workaround for XML encoder limitation)
-	return enc.e.EncodeToken(xml.Comment(docStr))
+// quoteLiteral quotes a public or system identifier, which cannot contain
+// escapes, with double quotes unless it contains one
+func quoteLiteral(id DOMString) string {
+	if strings.Contains(string(id), `"`) {
+		return "'" + string(id) + "'"
+	}
+	return `"` + string(id) + `"`
+}
+
+// SerializedLength returns the number of bytes an Encoder with the default
+// settings writes for node, without keeping the output. It runs the same
+// serialization as Encode against a writer that only counts.
+func SerializedLength(node Node) (int, error) {
+	var cw countingWriter
+	if err := NewEncoder(&cw).Encode(node); err != nil {
+		return 0, err
+	}
+	return cw.n, nil
+}
+
+// countingWriter discards what is written to it, counting the bytes
+type countingWriter struct {
+	n int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += len(p)
+	return len(p), nil
+}
+
+// lineEndingWriter rewrites line separators on their way to the underlying
+// writer according to the selected LineEnding
+type lineEndingWriter struct {
+	w   io.Writer
+	le  LineEnding
+	cr  bool   // a '\r' was held back at the end of the previous write
+	buf []byte // reused between writes
+}
+
+func (lw *lineEndingWriter) Write(p []byte) (int, error) {
+	if !lw.rewrites(p) {
+		return lw.w.Write(p)
+	}
+
+	buf := lw.buf[:0]
+	for _, c := range p {
+		if lw.cr {
+			lw.cr = false
+			if c == '\n' {
+				// "\r\n" collapses to a single separator
+				buf = appendLineEnding(buf, lw.le)
+				continue
+			}
+			buf = append(buf, '\r')
+		}
+		switch c {
+		case '\r':
+			lw.cr = true
+		case '\n':
+			buf = appendLineEnding(buf, lw.le)
+		default:
+			buf = append(buf, c)
+		}
+	}
+	lw.buf = buf
+	if _, err := lw.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// rewrites reports whether p contains a line separator the selected
+// LineEnding changes. Writes that contain none, such as everything written
+// with the default LineEndingLF except a "\r\n", go straight through.
+func (lw *lineEndingWriter) rewrites(p []byte) bool {
+	switch {
+	case lw.le == LineEndingPreserve:
+		return false
+	case lw.cr || bytes.IndexByte(p, '\r') >= 0:
+		return true
+	case lw.le == LineEndingCRLF:
+		return bytes.IndexByte(p, '\n') >= 0
+	}
+	return false
+}
+
+// checkEncodable returns InvalidCharacterError if s, written as the named
+// part of the output, holds a character the output encoding cannot
+// represent. Only character data and attribute values may fall back to
+// numeric character references, which mean nothing anywhere else.
+func (enc *Encoder) checkEncodable(what string, s DOMString) error {
+	cw, ok := enc.lw.w.(*charRefWriter)
+	if !ok {
+		return nil
+	}
+	for _, r := range string(s) {
+		if r >= utf8.RuneSelf && !cw.representable(r) {
+			return NewDOMException("InvalidCharacterError", fmt.Sprintf("%s %q cannot be written in %s", what, s, enc.encoding))
+		}
+	}
+	return nil
+}
+
+// charRefWriter passes UTF-8 on to a transcoding writer, replacing the
+// characters it cannot represent with numeric character references
+type charRefWriter struct {
+	w         io.Writer
+	out       io.Writer // the destination w transcodes to
+	transcode func(io.Writer) io.Writer
+	known     map[rune]bool // representability of the non-ASCII runes seen
+	pending   []byte        // an incomplete rune held back from the last write
+	buf       []byte        // reused between writes
+}
+
+func (cw *charRefWriter) Write(p []byte) (int, error) {
+	src := p
+	if len(cw.pending) > 0 {
+		src = append(cw.pending, p...)
+		cw.pending = nil
+	}
+
+	buf := cw.buf[:0]
+	for len(src) > 0 {
+		if src[0] < utf8.RuneSelf {
+			buf = append(buf, src[0])
+			src = src[1:]
+			continue
+		}
+		if !utf8.FullRune(src) {
+			cw.pending = append([]byte(nil), src...)
+			break
+		}
+		r, size := utf8.DecodeRune(src)
+		if r == utf8.RuneError && size == 1 || cw.representable(r) {
+			buf = append(buf, src[:size]...)
+		} else {
+			buf = append(buf, "&#"...)
+			buf = strconv.AppendInt(buf, int64(r), 10)
+			buf = append(buf, ';')
+		}
+		src = src[size:]
+	}
+	cw.buf = buf
+	if _, err := cw.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// close writes out anything held back and, if the transcoding writer is
+// an io.Closer, closes it so it finishes its output, then starts a new one
+// for the next Encode
+func (cw *charRefWriter) close() error {
+	if len(cw.pending) > 0 {
+		pending := cw.pending
+		cw.pending = nil
+		if _, err := cw.w.Write(pending); err != nil {
+			return err
+		}
+	}
+	c, ok := cw.w.(io.Closer)
+	if !ok {
+		return nil
+	}
+	if err := c.Close(); err != nil {
+		return err
+	}
+	cw.w = cw.transcode(cw.out)
+	return nil
+}
+
+// representable reports whether the target encoding can hold r, found by
+// transcoding it on its own
+func (cw *charRefWriter) representable(r rune) bool {
+	ok, seen := cw.known[r]
+	if !seen {
+		_, err := cw.transcode(io.Discard).Write(utf8.AppendRune(nil, r))
+		ok = err == nil
+		if cw.known == nil {
+			cw.known = make(map[rune]bool)
+		}
+		cw.known[r] = ok
+	}
+	return ok
+}
+
+// flush writes out a trailing '\r' held back by Write
+func (lw *lineEndingWriter) flush() error {
+	if !lw.cr {
+		return nil
+	}
+	lw.cr = false
+	_, err := lw.w.Write([]byte{'\r'})
+	return err
+}
+
+func appendLineEnding(buf []byte, le LineEnding) []byte {
+	if le == LineEndingCRLF {
+		return append(buf, '\r', '\n')
+	}
+	return append(buf, '\n')
 }
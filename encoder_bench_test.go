@@ -0,0 +1,31 @@
+package xmldom_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gogo-agent/xmldom"
+)
+
+// BenchmarkEncodeLargeText serializes a multi-megabyte text node. Bytes
+// allocated per op should stay well below the text size since character
+// data is streamed in chunks.
+func BenchmarkEncodeLargeText(b *testing.B) {
+	impl := xmldom.NewDOMImplementation()
+	doc, err := impl.CreateDocument("", "root", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	text := strings.Repeat("lorem ipsum <dolor> & sit amet ", 1<<17) // ~4MB
+	doc.DocumentElement().AppendChild(doc.CreateTextNode(xmldom.DOMString(text)))
+
+	b.SetBytes(int64(len(text)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := xmldom.NewEncoder(io.Discard).Encode(doc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
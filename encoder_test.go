@@ -0,0 +1,565 @@
+package xmldom_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gogo-agent/xmldom"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestEncoderLineEnding(t *testing.T) {
+	doc, err := xmldom.NewDecoder(strings.NewReader(`<root><a>x</a><b/></root>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+
+	var buf strings.Builder
+	enc := xmldom.NewEncoder(&buf)
+	enc.SetLineEnding(xmldom.LineEndingCRLF)
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+
+	got := buf.String()
+	if !strings.Contains(got, "\r\n  <a>") {
+		t.Errorf("expected CRLF line endings in indented output, got %q", got)
+	}
+	if strings.Count(got, "\n") != strings.Count(got, "\r\n") {
+		t.Errorf("found bare LF in CRLF output: %q", got)
+	}
+
+	buf.Reset()
+	enc = xmldom.NewEncoder(&buf)
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	if got := buf.String(); strings.Contains(got, "\r") || !strings.Contains(got, "\n") {
+		t.Errorf("expected LF line endings by default, got %q", got)
+	}
+}
+
+func TestEncoderLargeText(t *testing.T) {
+	impl := xmldom.NewDOMImplementation()
+	doc, err := impl.CreateDocument("", "root", nil)
+	if err != nil {
+		t.Fatalf("CreateDocument() failed: %v", err)
+	}
+	// Multi-byte runes and escapable characters straddle chunk boundaries
+	text := strings.Repeat("é<&x", 50000)
+	doc.DocumentElement().AppendChild(doc.CreateTextNode(xmldom.DOMString(text)))
+
+	var buf strings.Builder
+	enc := xmldom.NewEncoder(&buf)
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+
+	want := strings.Repeat("é&lt;&amp;x", 50000)
+	if got := buf.String(); !strings.Contains(got, want) {
+		t.Errorf("large text was not serialized intact (len %d)", len(got))
+	}
+}
+
+func TestEncoderTrailingNewline(t *testing.T) {
+	doc, err := xmldom.NewDecoder(strings.NewReader(`<root><a/></root>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+
+	var buf strings.Builder
+	if err := xmldom.NewEncoder(&buf).Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	if got := buf.String(); strings.HasSuffix(got, "\n") {
+		t.Errorf("expected no trailing newline by default, got %q", got)
+	}
+
+	buf.Reset()
+	enc := xmldom.NewEncoder(&buf)
+	enc.SetTrailingNewline(true)
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	got := buf.String()
+	if !strings.HasSuffix(got, "</root>\n") || strings.HasSuffix(got, "\n\n") {
+		t.Errorf("expected exactly one trailing newline, got %q", got)
+	}
+}
+
+func TestEncoderNamespaceContext(t *testing.T) {
+	doc, err := xmldom.NewDecoder(strings.NewReader(
+		`<root xmlns="urn:default" xmlns:x="urn:x"><x:item x:key="1"><name>n</name><other xmlns="urn:other"/></x:item></root>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	frag := doc.CreateDocumentFragment()
+	frag.AppendChild(doc.DocumentElement().FirstChild())
+
+	var buf strings.Builder
+	enc := xmldom.NewEncoder(&buf)
+	enc.SetIndent("", "")
+	enc.SetNamespaceContext(map[xmldom.DOMString]xmldom.DOMString{
+		"":  "urn:default",
+		"x": "urn:x",
+	})
+	if err := enc.Encode(frag); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	want := `<x:item x:key="1"><name>n</name><other xmlns="urn:other"></other></x:item>`
+	if got := buf.String(); got != want {
+		t.Errorf("Encode() = %s, want %s", got, want)
+	}
+
+	// Without a context the bindings must be declared on the fragment
+	buf.Reset()
+	enc = xmldom.NewEncoder(&buf)
+	enc.SetIndent("", "")
+	if err := enc.Encode(frag); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	reparsed, err := xmldom.NewDecoder(strings.NewReader(buf.String())).Decode()
+	if err != nil {
+		t.Fatalf("output %s is not well-formed: %v", buf.String(), err)
+	}
+	item := reparsed.DocumentElement()
+	if item.NamespaceURI() != "urn:x" || item.GetAttributeNS("urn:x", "key") != "1" {
+		t.Errorf("namespaces were lost in %s", buf.String())
+	}
+	if name := item.FirstChild(); name.NamespaceURI() != "urn:default" {
+		t.Errorf("child namespace = %q, want urn:default in %s", name.NamespaceURI(), buf.String())
+	}
+}
+
+func TestEncoderRedundantDefaultNamespace(t *testing.T) {
+	const xmlnsNS = "http://www.w3.org/2000/xmlns/"
+	doc, err := xmldom.NewDOMImplementation().CreateDocument("", "", nil)
+	if err != nil {
+		t.Fatalf("CreateDocument() failed: %v", err)
+	}
+	root, _ := doc.CreateElementNS("urn:a", "root")
+	root.SetAttributeNS(xmlnsNS, "xmlns", "urn:a")
+	doc.AppendChild(root)
+	child, _ := doc.CreateElementNS("urn:a", "child")
+	child.SetAttributeNS(xmlnsNS, "xmlns", "urn:a")
+	root.AppendChild(child)
+	grandchild, _ := doc.CreateElementNS("urn:a", "grandchild")
+	grandchild.SetAttribute("xmlns", "urn:a")
+	child.AppendChild(grandchild)
+	plain, _ := doc.CreateElement("plain")
+	child.AppendChild(plain)
+	inner, _ := doc.CreateElement("inner")
+	inner.SetAttribute("xmlns", "")
+	plain.AppendChild(inner)
+	prefixed, _ := doc.CreateElementNS("urn:a", "prefixed")
+	prefixed.SetAttribute("xmlns:p", "urn:p")
+	root.AppendChild(prefixed)
+
+	var buf strings.Builder
+	enc := xmldom.NewEncoder(&buf)
+	enc.SetIndent("", "")
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	want := `<root xmlns="urn:a"><child><grandchild></grandchild><plain xmlns=""><inner></inner></plain></child><prefixed xmlns:p="urn:p"></prefixed></root>`
+	if got := buf.String(); got != want {
+		t.Errorf("Encode() = %s, want %s", got, want)
+	}
+}
+
+func TestEncoderWriteBOM(t *testing.T) {
+	doc, err := xmldom.NewDecoder(strings.NewReader(`<root>é</root>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+
+	var buf strings.Builder
+	enc := xmldom.NewEncoder(&buf)
+	enc.SetWriteBOM(true)
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	got := buf.String()
+	if !strings.HasPrefix(got, "\xEF\xBB\xBF<root>") {
+		t.Errorf("output should start with the UTF-8 BOM, got %q", got)
+	}
+	if strings.Count(got, "\xEF\xBB\xBF") != 1 {
+		t.Errorf("BOM should be written once per stream, got %q", got)
+	}
+
+	buf.Reset()
+	if err := xmldom.NewEncoder(&buf).Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	if strings.HasPrefix(buf.String(), "\xEF\xBB\xBF") {
+		t.Errorf("no BOM should be written by default")
+	}
+}
+
+func TestEncoderWriteBOMUTF16(t *testing.T) {
+	// The input is already UTF-8, so the declared charset is read as is
+	opts := &xmldom.DecoderOptions{
+		Strict: true,
+		CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+			return input, nil
+		},
+	}
+	doc, err := xmldom.NewDecoderWithOptions(strings.NewReader(`<?xml version="1.0" encoding="UTF-16"?><root>é</root>`), opts).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if doc.CharacterSet() != "UTF-16" {
+		t.Fatalf("CharacterSet() = %q, want the declared UTF-16", doc.CharacterSet())
+	}
+
+	var buf strings.Builder
+	enc := xmldom.NewEncoder(&buf)
+	enc.SetIndent("", "")
+	enc.SetWriteBOM(true)
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	got := buf.String()
+	if !strings.HasPrefix(got, "\xFE\xFF") {
+		t.Fatalf("output should start with the UTF-16BE BOM, got % x", got[:min(len(got), 4)])
+	}
+	text, err := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder().String(got[2:])
+	if err != nil {
+		t.Fatalf("output after the BOM is not UTF-16BE: %v", err)
+	}
+	if want := `<?xml version="1.0" encoding="UTF-16"?><root>é</root>`; text != want {
+		t.Errorf("Encode() = %q, want %q", text, want)
+	}
+
+	buf.Reset()
+	enc = xmldom.NewEncoder(&buf)
+	enc.SetWriteBOM(true)
+	enc.SetOutputEncoding("UTF-16LE", unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder().Writer)
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	if got := buf.String(); !strings.HasPrefix(got, "\xFF\xFE<\x00") {
+		t.Errorf("SetOutputEncoding should choose the UTF-16LE BOM, got % x", got[:min(len(got), 4)])
+	}
+}
+
+func TestEncoderSetOutputEncoding(t *testing.T) {
+	doc, err := xmldom.NewDecoder(strings.NewReader(`<root a="€">café €</root>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+
+	var buf strings.Builder
+	enc := xmldom.NewEncoder(&buf)
+	enc.SetIndent("", "")
+	enc.SetOutputEncoding("ISO-8859-1", func(w io.Writer) io.Writer {
+		return charmap.ISO8859_1.NewEncoder().Writer(w)
+	})
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	want := `<?xml version="1.0" encoding="ISO-8859-1"?><root a="&#8364;">caf` + "\xE9" + ` &#8364;</root>`
+	if got := buf.String(); got != want {
+		t.Errorf("Encode() = %q, want %q", got, want)
+	}
+
+	back, err := xmldom.NewDecoder(strings.NewReader(buf.String())).Decode()
+	if err != nil {
+		t.Fatalf("Decode() of latin-1 output failed: %v", err)
+	}
+	if got := back.DocumentElement().TextContent(); got != "café €" {
+		t.Errorf("round-tripped text = %q, want %q", got, "café €")
+	}
+}
+
+func TestEncoderSetOutputEncodingUnrepresentable(t *testing.T) {
+	doc := createTestDoc(t)
+	comment := doc.CreateComment("5 €")
+	cdata, _ := doc.CreateCDATASection("5 €")
+	pi, _ := doc.CreateProcessingInstruction("price", "5 €")
+	named, _ := doc.CreateElement("prix€")
+
+	for _, node := range []xmldom.Node{comment, cdata, pi, named} {
+		t.Run(string(node.NodeName()), func(t *testing.T) {
+			var buf strings.Builder
+			enc := xmldom.NewEncoder(&buf)
+			enc.SetOutputEncoding("ISO-8859-1", charmap.ISO8859_1.NewEncoder().Writer)
+			err := enc.Encode(node)
+			if err == nil || !strings.HasPrefix(err.Error(), "InvalidCharacterError") {
+				t.Errorf("Encode() error = %v, want InvalidCharacterError", err)
+			}
+			if strings.Contains(buf.String(), "&#8364;") {
+				t.Errorf("no character reference should be written outside text and attribute values, got %q", buf.String())
+			}
+		})
+	}
+}
+
+func TestEncoderSetOutputEncodingClosesTranscoder(t *testing.T) {
+	doc := createTestDoc(t)
+	text := doc.CreateTextNode("日本")
+
+	var buf strings.Builder
+	enc := xmldom.NewEncoder(&buf)
+	enc.SetOutputEncoding("ISO-2022-JP", func(w io.Writer) io.Writer {
+		return japanese.ISO2022JP.NewEncoder().Writer(w)
+	})
+	for i := 0; i < 2; i++ {
+		if err := enc.Encode(text); err != nil {
+			t.Fatalf("Encode() failed: %v", err)
+		}
+		// The stateful encoding only returns to ASCII when its writer is
+		// closed
+		if got := buf.String(); !strings.HasSuffix(got, "\x1b(B") {
+			t.Errorf("output after Encode %d should end by switching back to ASCII, got %q", i+1, got)
+		}
+	}
+	back, err := japanese.ISO2022JP.NewDecoder().String(buf.String())
+	if err != nil {
+		t.Fatalf("output is not ISO-2022-JP: %v", err)
+	}
+	if back != "日本日本" {
+		t.Errorf("decoded output = %q, want %q", back, "日本日本")
+	}
+}
+
+func TestEncoderSetXMLDeclaration(t *testing.T) {
+	doc, err := xmldom.NewDecoder(strings.NewReader(`<root><item/></root>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+
+	var buf strings.Builder
+	enc := xmldom.NewEncoder(&buf)
+	enc.SetIndent("", "")
+	standalone := true
+	enc.SetXMLDeclaration("", "", &standalone)
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?><root><item></item></root>`
+	if got := buf.String(); got != want {
+		t.Errorf("Encode(doc) = %s, want %s", got, want)
+	}
+
+	buf.Reset()
+	enc = xmldom.NewEncoder(&buf)
+	enc.SetIndent("", "")
+	enc.SetXMLDeclaration("1.0", "", nil)
+	if err := enc.Encode(doc.DocumentElement()); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	if got, want := buf.String(), `<root><item></item></root>`; got != want {
+		t.Errorf("Encode(element) = %s, want %s", got, want)
+	}
+
+	buf.Reset()
+	enc = xmldom.NewEncoder(&buf)
+	enc.SetIndent("", "")
+	enc.SetXMLDeclaration("1.1", "utf-8", nil)
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	if got, want := buf.String(), `<?xml version="1.1" encoding="utf-8"?><root><item></item></root>`; got != want {
+		t.Errorf("Encode(doc) = %s, want %s", got, want)
+	}
+
+	// The declaration must not claim a version or charset the output lacks
+	for _, tt := range []struct{ version, encoding xmldom.DOMString }{
+		{"1.0", "ISO-8859-1"},
+		{`1.0" encoding="ISO-8859-1`, ""},
+		{"2.0", ""},
+	} {
+		buf.Reset()
+		enc = xmldom.NewEncoder(&buf)
+		enc.SetXMLDeclaration(tt.version, tt.encoding, nil)
+		err := enc.Encode(doc)
+		if err == nil || !strings.HasPrefix(err.Error(), "NotSupportedError") {
+			t.Errorf("SetXMLDeclaration(%q, %q): Encode() error = %v, want NotSupportedError", tt.version, tt.encoding, err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("SetXMLDeclaration(%q, %q): Encode() wrote %s", tt.version, tt.encoding, buf.String())
+		}
+	}
+}
+
+func TestSerializedLength(t *testing.T) {
+	sources := []string{
+		`<root/>`,
+		`<root a="1 &amp; 2"><child>text &lt; more</child><!--note--><?pi data?></root>`,
+		`<!DOCTYPE root><root xmlns="urn:a" xmlns:x="urn:x"><x:item x:key="é">café` + "\r\n" + `€</x:item><![CDATA[raw <data>]]></root>`,
+		`<root>` + strings.Repeat(`<item n="1">some text</item>`, 1000) + `</root>`,
+	}
+	for _, src := range sources {
+		doc, err := xmldom.NewDecoder(strings.NewReader(src)).Decode()
+		if err != nil {
+			t.Fatalf("Decode(%q) failed: %v", src, err)
+		}
+		for _, node := range []xmldom.Node{doc, doc.DocumentElement()} {
+			var buf strings.Builder
+			if err := xmldom.NewEncoder(&buf).Encode(node); err != nil {
+				t.Fatalf("Encode() failed: %v", err)
+			}
+			got, err := xmldom.SerializedLength(node)
+			if err != nil {
+				t.Fatalf("SerializedLength() failed: %v", err)
+			}
+			if got != buf.Len() {
+				t.Errorf("SerializedLength() = %d, want %d for %.40q", got, buf.Len(), src)
+			}
+		}
+	}
+}
+
+func TestEncoderCDATAWithTerminator(t *testing.T) {
+	doc, err := xmldom.NewDecoder(strings.NewReader(`<root/>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	const data = "a]]>b <c> ]]>"
+	cdata, err := doc.CreateCDATASection(data)
+	if err != nil {
+		t.Fatalf("CreateCDATASection() failed: %v", err)
+	}
+	doc.DocumentElement().AppendChild(cdata)
+
+	var buf strings.Builder
+	enc := xmldom.NewEncoder(&buf)
+	enc.SetIndent("", "")
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	want := `<root><![CDATA[a]]]]><![CDATA[>b <c> ]]]]><![CDATA[>]]></root>`
+	if got := buf.String(); got != want {
+		t.Fatalf("Encode() = %s, want %s", got, want)
+	}
+
+	back, err := xmldom.NewDecoder(strings.NewReader(buf.String())).Decode()
+	if err != nil {
+		t.Fatalf("Decode() of output failed: %v", err)
+	}
+	// The Decoder reads CDATA sections as Text nodes, so only the content
+	// can be compared
+	if got := back.DocumentElement().TextContent(); got != data {
+		t.Errorf("re-parsed content = %q, want %q", got, data)
+	}
+}
+
+func TestEncoderCommentsAndProcessingInstructions(t *testing.T) {
+	const src = `<root><!-- Comment --><child/><?app do this?></root>`
+	doc, err := xmldom.NewDecoder(strings.NewReader(src)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+
+	var buf strings.Builder
+	enc := xmldom.NewEncoder(&buf)
+	enc.SetIndent("", "")
+	if err := enc.Encode(doc.DocumentElement()); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	marshaled, err := xmldom.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	for _, out := range []string{buf.String(), string(marshaled)} {
+		back, err := xmldom.UnmarshalDOM([]byte(out))
+		if err != nil {
+			t.Fatalf("UnmarshalDOM(%s) failed: %v", out, err)
+		}
+		root := back.DocumentElement()
+		comment, child, pi := root.FirstChild(), root.FirstChild().NextSibling(), root.LastChild()
+		if comment.NodeType() != xmldom.COMMENT_NODE || comment.NodeValue() != " Comment " {
+			t.Errorf("first child of %s is not the comment", out)
+		}
+		if child.NodeName() != "child" {
+			t.Errorf("second child of %s is %q, want child", out, child.NodeName())
+		}
+		if p, ok := pi.(xmldom.ProcessingInstruction); !ok || p.Target() != "app" || p.Data() != "do this" {
+			t.Errorf("last child of %s is not the processing instruction", out)
+		}
+	}
+
+	bad := doc.CreateComment("a -- b")
+	doc.DocumentElement().AppendChild(bad)
+	if err := xmldom.NewEncoder(io.Discard).Encode(doc); err == nil || !strings.HasPrefix(err.Error(), "InvalidStateError") {
+		t.Errorf("Encode() of a comment containing -- returned %v, want InvalidStateError", err)
+	}
+	if _, err := xmldom.Marshal(doc); err == nil || !strings.HasPrefix(err.Error(), "InvalidStateError") {
+		t.Errorf("Marshal() of a comment containing -- returned %v, want InvalidStateError", err)
+	}
+	doc.DocumentElement().RemoveChild(bad)
+
+	badPI, _ := doc.CreateProcessingInstruction("app", "a ?> b")
+	doc.DocumentElement().AppendChild(badPI)
+	if err := xmldom.NewEncoder(io.Discard).Encode(doc); err == nil || !strings.HasPrefix(err.Error(), "InvalidStateError") {
+		t.Errorf("Encode() of a processing instruction containing ?> returned %v, want InvalidStateError", err)
+	}
+}
+
+func TestEncoderDoctype(t *testing.T) {
+	impl := xmldom.NewDOMImplementation()
+	tests := []struct {
+		publicID, systemID xmldom.DOMString
+		want               string
+	}{
+		{"-//W3C//DTD HTML 4.01//EN", "http://www.w3.org/TR/html4/strict.dtd",
+			`<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd"><html></html>`},
+		{"", "html.dtd", `<!DOCTYPE html SYSTEM "html.dtd"><html></html>`},
+		{"", `say "hi".dtd`, `<!DOCTYPE html SYSTEM 'say "hi".dtd'><html></html>`},
+		{"", "", `<!DOCTYPE html><html></html>`},
+	}
+	for _, tt := range tests {
+		doctype, err := impl.CreateDocumentType("html", tt.publicID, tt.systemID)
+		if err != nil {
+			t.Fatalf("CreateDocumentType() failed: %v", err)
+		}
+		doc, err := impl.CreateDocument("", "html", doctype)
+		if err != nil {
+			t.Fatalf("CreateDocument() failed: %v", err)
+		}
+		var buf strings.Builder
+		enc := xmldom.NewEncoder(&buf)
+		enc.SetIndent("", "")
+		if err := enc.Encode(doc); err != nil {
+			t.Fatalf("Encode() failed: %v", err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("Encode() = %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestEncoderSetEmptyElementStyle(t *testing.T) {
+	doc, err := xmldom.NewDecoder(strings.NewReader(`<root><item a="1" b="2"/><full>x</full></root>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	tests := []struct {
+		style  xmldom.EmptyElementStyle
+		indent string
+		want   string
+	}{
+		{xmldom.EmptyElementExpanded, "", `<root><item a="1" b="2"></item><full>x</full></root>`},
+		{xmldom.EmptyElementSelfClosing, "", `<root><item a="1" b="2"/><full>x</full></root>`},
+		{xmldom.EmptyElementSelfClosing, "  ", "<root>\n  <item a=\"1\" b=\"2\"/>\n  <full>x</full>\n</root>"},
+	}
+	for _, tt := range tests {
+		var buf strings.Builder
+		enc := xmldom.NewEncoder(&buf)
+		enc.SetIndent("", tt.indent)
+		enc.SetEmptyElementStyle(tt.style)
+		if err := enc.Encode(doc); err != nil {
+			t.Fatalf("Encode() failed: %v", err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("Encode() with style %d = %q, want %q", tt.style, got, tt.want)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package xmldom
 
 import (
+	"fmt"
 	"io"
 	"strings"
+	"unicode/utf8"
 )
 
 // EscapeText writes to w the properly escaped XML equivalent of plain text data.
@@ -23,9 +25,50 @@ import (
 // This function provides full compatibility with encoding/xml.EscapeText while ensuring
 // DOM specification compliance for character data handling.
 func EscapeText(w io.Writer, s []byte) error {
+	return escapeText(w, s, EscapePolicy{})
+}
+
+// InvalidCharPolicy selects what escaping does with characters outside the
+// XML character range.
+type InvalidCharPolicy int
+
+const (
+	// ReplaceInvalidChars replaces them with U+FFFD, as EscapeText does
+	ReplaceInvalidChars InvalidCharPolicy = iota
+	// DropInvalidChars leaves them out of the output
+	DropInvalidChars
+	// RejectInvalidChars fails with InvalidCharacterError
+	RejectInvalidChars
+)
+
+// EscapePolicy controls the choices EscapeStringWithPolicy makes beyond
+// escaping the markup characters. The zero value is the policy of
+// EscapeString.
+type EscapePolicy struct {
+	// PreserveWhitespace writes tab and newline as they are instead of as
+	// numeric character references. This is valid in text content, but
+	// attribute values lose them to normalization. Carriage returns are
+	// still written as &#xD;, since a parser reads a raw one as a newline.
+	PreserveWhitespace bool
+	// InvalidChars is what happens to characters that are not allowed in
+	// XML: control characters other than tab, newline and carriage return,
+	// U+FFFE, U+FFFF and bytes that are not valid UTF-8.
+	InvalidChars InvalidCharPolicy
+
+	// attribute escapes for a value between double quotes, as
+	// EscapeAttrValue does: '"' as &quot; and '\'' left as it is
+	attribute bool
+}
+
+func escapeText(w io.Writer, s []byte, policy EscapePolicy) error {
 	var esc []byte
 	last := 0
-	for i, c := range s {
+	for i, width := 0, 1; i < len(s); i += width {
+		c := s[i]
+		width = 1
+		if policy.PreserveWhitespace && (c == '\t' || c == '\n') {
+			continue
+		}
 		switch c {
 		case '<':
 			esc = []byte("&lt;")
@@ -34,8 +77,15 @@ func EscapeText(w io.Writer, s []byte) error {
 		case '&':
 			esc = []byte("&amp;")
 		case '"':
-			esc = []byte("&#34;")
+			if policy.attribute {
+				esc = []byte("&quot;")
+			} else {
+				esc = []byte("&#34;")
+			}
 		case '\'':
+			if policy.attribute {
+				continue
+			}
 			esc = []byte("&#39;")
 		case '\t':
 			esc = []byte("&#x9;")
@@ -45,12 +95,28 @@ func EscapeText(w io.Writer, s []byte) error {
 			esc = []byte("&#xD;")
 		default:
 			// Handle invalid XML characters (control characters except tab, newline, carriage return)
-			if c < 0x20 && c != 0x09 && c != 0x0A && c != 0x0D {
+			r := rune(c)
+			if c >= utf8.RuneSelf {
+				r, width = utf8.DecodeRune(s[i:])
+				if r == utf8.RuneError && width == 1 {
+					r = -1
+				}
+			}
+			if r >= 0x20 && r != 0xFFFE && r != 0xFFFF {
+				continue
+			}
+			switch policy.InvalidChars {
+			case DropInvalidChars:
+				esc = nil
+			case RejectInvalidChars:
+				if r < 0 {
+					return NewDOMException("InvalidCharacterError", fmt.Sprintf("byte %#x at offset %d is not valid UTF-8", c, i))
+				}
+				return NewDOMException("InvalidCharacterError", fmt.Sprintf("character %U at offset %d is not allowed in XML", r, i))
+			default:
 				// Replace invalid characters with Unicode replacement character (U+FFFD)
 				// In UTF-8, this is the 3-byte sequence: 0xEF 0xBF 0xBD
 				esc = []byte("\uFFFD")
-			} else {
-				continue
 			}
 		}
 		if _, err := w.Write(s[last:i]); err != nil {
@@ -59,7 +125,7 @@ func EscapeText(w io.Writer, s []byte) error {
 		if _, err := w.Write(esc); err != nil {
 			return err
 		}
-		last = i + 1
+		last = i + width
 	}
 	_, err := w.Write(s[last:])
 	return err
@@ -79,6 +145,33 @@ func EscapeString(s string) string {
 	return b.String()
 }
 
+// EscapeAttrValue returns s escaped for use as an attribute value between
+// double quotes. Besides '<', '>' and '&' it escapes '"' as &quot;, and tab,
+// newline and carriage return as numeric character references, which
+// attribute-value normalization would otherwise turn into spaces.
+// Characters outside the valid XML character range are replaced with the
+// Unicode replacement character, as in EscapeText.
+func EscapeAttrValue(s string) string {
+	var b strings.Builder
+	if err := escapeText(&b, []byte(s), EscapePolicy{attribute: true}); err != nil {
+		// strings.Builder.Write never returns an error, so this should never happen
+		panic("unexpected error from strings.Builder.Write: " + err.Error())
+	}
+	return b.String()
+}
+
+// EscapeStringWithPolicy is EscapeString with the handling of whitespace
+// and invalid characters chosen by policy. It returns an error only when
+// policy.InvalidChars is RejectInvalidChars and s contains such a
+// character.
+func EscapeStringWithPolicy(s string, policy EscapePolicy) (string, error) {
+	var b strings.Builder
+	if err := escapeText(&b, []byte(s), policy); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
 // UnescapeText decodes XML character entity references in text data.
 // This function reverses the escaping performed by EscapeText.
 //
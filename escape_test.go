@@ -130,6 +130,106 @@ func TestEscapeString(t *testing.T) {
 	}
 }
 
+func TestEscapeAttrValue(t *testing.T) {
+	input := "say \"a < b & c\"\nthen\tgo"
+	want := "say &quot;a &lt; b &amp; c&quot;&#xA;then&#x9;go"
+	if got := xmldom.EscapeAttrValue(input); got != want {
+		t.Errorf("EscapeAttrValue(%q) = %q, want %q", input, got, want)
+	}
+
+	// Marshal writes attribute values with it, and they read back unchanged
+	doc, err := xmldom.NewDOMImplementation().CreateDocument("", "root", nil)
+	if err != nil {
+		t.Fatalf("CreateDocument() failed: %v", err)
+	}
+	doc.DocumentElement().SetAttribute("v", xmldom.DOMString(input))
+	data, err := xmldom.Marshal(doc.DocumentElement())
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if got, want := string(data), `<root v="`+want+`"></root>`; got != want {
+		t.Errorf("Marshal() = %s, want %s", got, want)
+	}
+	back, err := xmldom.UnmarshalDOM(data)
+	if err != nil {
+		t.Fatalf("UnmarshalDOM() failed: %v", err)
+	}
+	if got := back.DocumentElement().GetAttribute("v"); got != xmldom.DOMString(input) {
+		t.Errorf("round-tripped value = %q, want %q", got, input)
+	}
+
+	// The Encoder escapes attribute values the same way, also in
+	// empty-element tags
+	var buf bytes.Buffer
+	if err := xmldom.NewEncoder(&buf).Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	if got, want := buf.String(), `<root v="`+want+`"></root>`; got != want {
+		t.Errorf("Encode() = %s, want %s", got, want)
+	}
+	var selfClosing bytes.Buffer
+	enc := xmldom.NewEncoder(&selfClosing)
+	enc.SetEmptyElementStyle(xmldom.EmptyElementSelfClosing)
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	if got, want := selfClosing.String(), `<root v="`+want+`"/>`; got != want {
+		t.Errorf("Encode() with self-closing tags = %s, want %s", got, want)
+	}
+	back, err = xmldom.UnmarshalDOM(buf.Bytes())
+	if err != nil {
+		t.Fatalf("UnmarshalDOM() failed: %v", err)
+	}
+	if got := back.DocumentElement().GetAttribute("v"); got != xmldom.DOMString(input) {
+		t.Errorf("value round-tripped through Encoder = %q, want %q", got, input)
+	}
+}
+
+func TestEscapeStringWithPolicy(t *testing.T) {
+	input := "a < b\n\tc\x01d"
+
+	got, err := xmldom.EscapeStringWithPolicy(input, xmldom.EscapePolicy{})
+	if err != nil || got != xmldom.EscapeString(input) {
+		t.Errorf("zero policy = %q, %v, want %q", got, err, xmldom.EscapeString(input))
+	}
+
+	got, err = xmldom.EscapeStringWithPolicy(input, xmldom.EscapePolicy{PreserveWhitespace: true})
+	if want := "a &lt; b\n\tc\uFFFDd"; err != nil || got != want {
+		t.Errorf("preserving whitespace = %q, %v, want %q", got, err, want)
+	}
+
+	got, err = xmldom.EscapeStringWithPolicy(input, xmldom.EscapePolicy{InvalidChars: xmldom.DropInvalidChars})
+	if want := "a &lt; b&#xA;&#x9;cd"; err != nil || got != want {
+		t.Errorf("dropping invalid characters = %q, %v, want %q", got, err, want)
+	}
+
+	_, err = xmldom.EscapeStringWithPolicy(input, xmldom.EscapePolicy{InvalidChars: xmldom.RejectInvalidChars})
+	if err == nil || !strings.HasPrefix(err.Error(), "InvalidCharacterError") {
+		t.Errorf("rejecting invalid characters returned %v, want InvalidCharacterError", err)
+	}
+	got, err = xmldom.EscapeStringWithPolicy("a\r\nb", xmldom.EscapePolicy{InvalidChars: xmldom.RejectInvalidChars})
+	if want := "a&#xD;&#xA;b"; err != nil || got != want {
+		t.Errorf("valid input = %q, %v, want %q", got, err, want)
+	}
+
+	// A raw carriage return would be read back as a newline
+	got, err = xmldom.EscapeStringWithPolicy("a\r\nb", xmldom.EscapePolicy{PreserveWhitespace: true})
+	if want := "a&#xD;\nb"; err != nil || got != want {
+		t.Errorf("preserving whitespace around a carriage return = %q, %v, want %q", got, err, want)
+	}
+
+	for _, invalid := range []string{"a\uFFFEb", "a\uFFFFb", "a\xffb"} {
+		_, err = xmldom.EscapeStringWithPolicy(invalid, xmldom.EscapePolicy{InvalidChars: xmldom.RejectInvalidChars})
+		if err == nil || !strings.HasPrefix(err.Error(), "InvalidCharacterError") {
+			t.Errorf("rejecting %q returned %v, want InvalidCharacterError", invalid, err)
+		}
+		got, err = xmldom.EscapeStringWithPolicy(invalid, xmldom.EscapePolicy{})
+		if want := "a\uFFFDb"; err != nil || got != want {
+			t.Errorf("replacing in %q = %q, %v, want %q", invalid, got, err, want)
+		}
+	}
+}
+
 func TestUnescapeText(t *testing.T) {
 	tests := []struct {
 		name     string
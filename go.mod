@@ -2,11 +2,7 @@ module github.com/gogo-agent/xmldom
 
 go 1.24.5
 
-require golang.org/x/text v0.27.0
-
 require (
-	github.com/golang/groupcache v0.0.0-20241129210726-2c02b8208cf8 // indirect
-	github.com/golang/protobuf v1.5.4 // indirect
-	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
-	google.golang.org/protobuf v1.33.0 // indirect
+	github.com/golang/groupcache v0.0.0-20241129210726-2c02b8208cf8
+	golang.org/x/text v0.27.0
 )
@@ -0,0 +1,201 @@
+package xmldom
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DanglingReferenceError reports an IDREF attribute value that does not
+// match the id of any element in the document.
+type DanglingReferenceError struct {
+	Path      string    // location of the referring element, as returned by FirstDifference
+	Attribute DOMString // name of the IDREF attribute
+	Ref       DOMString // the unresolved id
+}
+
+func (e *DanglingReferenceError) Error() string {
+	return fmt.Sprintf("%s: %s references unknown id %q", e.Path, e.Attribute, e.Ref)
+}
+
+// CheckReferentialIntegrity verifies that IDREF attributes point at
+// existing ids. idrefAttrs maps an element's qualified name, or "*" for
+// every element, to the names of its IDREF attributes. Attribute values
+// are split on whitespace, so IDREFS lists are checked item by item. One
+// *DanglingReferenceError is returned per unresolved reference, in
+// document order.
+func (d *document) CheckReferentialIntegrity(idrefAttrs map[DOMString][]DOMString) []error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	ids := make(map[DOMString]bool)
+	var elements []*element
+	collectElements(d, func(e *element) {
+		if id := e.attributes.GetNamedItem(d.idAttribute()); id != nil {
+			ids[id.NodeValue()] = true
+		}
+		elements = append(elements, e)
+	})
+
+	var errs []error
+	for _, e := range elements {
+		for _, name := range idrefAttrNames(idrefAttrs, e.nodeName) {
+			attr := e.attributes.GetNamedItem(name)
+			if attr == nil {
+				continue
+			}
+			for _, ref := range strings.Fields(string(attr.NodeValue())) {
+				if !ids[DOMString(ref)] {
+					errs = append(errs, &DanglingReferenceError{
+						Path:      nodePath(e),
+						Attribute: name,
+						Ref:       DOMString(ref),
+					})
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// idrefAttrNames returns the IDREF attribute names for an element named
+// tagName, including those registered for every element under "*"
+func idrefAttrNames(idrefAttrs map[DOMString][]DOMString, tagName DOMString) []DOMString {
+	names := make([]DOMString, 0, len(idrefAttrs[tagName])+len(idrefAttrs["*"]))
+	names = append(names, idrefAttrs[tagName]...)
+	if tagName != "*" {
+		names = append(names, idrefAttrs["*"]...)
+	}
+	return names
+}
+
+// FindDuplicateIds reports every id carried by more than one element in
+// the document, mapped to those elements in document order.
+func (d *document) FindDuplicateIds() map[DOMString][]Element {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	holders := make(map[DOMString][]Element)
+	collectElements(d, func(e *element) {
+		if id := e.attributes.GetNamedItem(d.idAttribute()); id != nil && id.NodeValue() != "" {
+			holders[id.NodeValue()] = append(holders[id.NodeValue()], e)
+		}
+	})
+	for id, elems := range holders {
+		if len(elems) < 2 {
+			delete(holders, id)
+		}
+	}
+	return holders
+}
+
+// firstElementWithId returns the first element in document order whose id
+// attribute is elementId, or nil. The caller must hold d.mu.
+func (d *document) firstElementWithId(elementId DOMString) Element {
+	var found Element
+	collectElements(d, func(e *element) {
+		if found == nil && elementHasId(e, elementId) {
+			found = e
+		}
+	})
+	return found
+}
+
+// releaseId drops elem from the id index entry for id, once elem no longer
+// carries that id in the tree. If the id was shared, the elements that
+// still carry it are looked up again, and once at most one does, lookups of
+// the id leave the scanning path. The caller must hold d.mu.
+func (d *document) releaseId(elem Element, id DOMString) {
+	if id == "" {
+		return
+	}
+	if isSameNode(d.idMap[id], elem) {
+		d.removeIdMapping(id)
+	}
+	if !d.duplicateIds[id] {
+		return
+	}
+
+	var first Element
+	holders := 0
+	collectElements(d, func(e *element) {
+		if elementHasId(e, id) {
+			if first == nil {
+				first = e
+			}
+			holders++
+		}
+	})
+	if first != nil {
+		d.idMap[id] = first
+	}
+	if holders < 2 {
+		delete(d.duplicateIds, id)
+	}
+}
+
+// releaseSharedIds settles the shared ids carried by root, if it is an
+// element, and by the elements below it, once root has been removed from
+// the tree. The caller must hold d.mu.
+func (d *document) releaseSharedIds(root Node) {
+	if len(d.duplicateIds) == 0 {
+		return
+	}
+	release := func(e *element) {
+		if id := e.attributes.GetNamedItem(d.idAttribute()); id != nil && d.duplicateIds[id.NodeValue()] {
+			d.releaseId(e, id.NodeValue())
+		}
+	}
+	if e, ok := root.(*element); ok {
+		release(e)
+	}
+	collectElements(root, release)
+}
+
+// elementHasId reports whether elem currently carries the given id
+func elementHasId(elem Element, id DOMString) bool {
+	e, ok := elem.(*element)
+	if !ok || e.attributes == nil {
+		return false
+	}
+	d, _ := e.ownerDocument.(*document)
+	attr := e.attributes.GetNamedItem(d.idAttribute())
+	return attr != nil && attr.NodeValue() == id
+}
+
+// indexIds adds root, if it is an element, and every element below it that
+// carries an id to the id index. The caller must hold d.mu.
+func (d *document) indexIds(root Node) {
+	index := func(e *element) {
+		if id := e.attributes.GetNamedItem(d.idAttribute()); id != nil {
+			d.updateIdMappingForElement(e, d.idAttribute(), "", id.NodeValue())
+		}
+	}
+	if e, ok := root.(*element); ok {
+		index(e)
+	}
+	collectElements(root, index)
+}
+
+// unindexIds removes root, if it is an element, and every element below it
+// from the id index. The caller must hold d.mu.
+func (d *document) unindexIds(root Node) {
+	unindex := func(e *element) {
+		if id := e.attributes.GetNamedItem(d.idAttribute()); id != nil {
+			d.releaseId(e, id.NodeValue())
+		}
+	}
+	if e, ok := root.(*element); ok {
+		unindex(e)
+	}
+	collectElements(root, unindex)
+}
+
+// collectElements calls fn for each element below root in document order
+func collectElements(root Node, fn func(*element)) {
+	for child := root.FirstChild(); child != nil; child = child.NextSibling() {
+		if e, ok := child.(*element); ok {
+			fn(e)
+			collectElements(e, fn)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package xmldom
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestDuplicateIdFlagCleared checks that an id stops being treated as
+// shared, and so stops being resolved by scanning the tree, once only one
+// element carries it again.
+func TestDuplicateIdFlagCleared(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		resolve  func(root Element) error
+		wantNode string
+	}{
+		{
+			name: "attribute changed",
+			resolve: func(root Element) error {
+				return root.FirstChild().(Element).SetAttribute("id", "other")
+			},
+			wantNode: "c",
+		},
+		{
+			name: "attribute removed",
+			resolve: func(root Element) error {
+				return root.LastChild().(Element).RemoveAttribute("id")
+			},
+			wantNode: "a",
+		},
+		{
+			name: "element removed",
+			resolve: func(root Element) error {
+				_, err := root.RemoveChild(root.FirstChild())
+				return err
+			},
+			wantNode: "c",
+		},
+		{
+			name: "later holder removed",
+			resolve: func(root Element) error {
+				_, err := root.RemoveChild(root.LastChild())
+				return err
+			},
+			wantNode: "a",
+		},
+		{
+			name: "holders merged",
+			src:  `<root><a id="x">1</a><a id="x">2</a></root>`,
+			resolve: func(root Element) error {
+				return root.LastElementChild().MergeWithPrevious()
+			},
+			wantNode: "a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := tt.src
+			if src == "" {
+				src = `<root><a id="x"/><b/><c id="x"/></root>`
+			}
+			doc, err := NewDecoder(strings.NewReader(src)).Decode()
+			if err != nil {
+				t.Fatalf("Decode() failed: %v", err)
+			}
+			d := doc.(*document)
+			if !d.duplicateIds["x"] {
+				t.Fatalf("id x should be marked as shared after parsing")
+			}
+
+			if err := tt.resolve(doc.DocumentElement()); err != nil {
+				t.Fatalf("mutation failed: %v", err)
+			}
+			if d.duplicateIds["x"] {
+				t.Errorf("id x is still marked as shared")
+			}
+			if got := doc.GetElementById("x"); got == nil || got.TagName() != DOMString(tt.wantNode) {
+				t.Errorf("GetElementById(x) = %v, want <%s>", got, tt.wantNode)
+			}
+		})
+	}
+}
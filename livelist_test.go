@@ -0,0 +1,78 @@
+package xmldom
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestLiveListRecomputesLazily checks that live lists do no work until they
+// are read, however often the tree changes in between.
+func TestLiveListRecomputesLazily(t *testing.T) {
+	doc, err := NewDecoder(strings.NewReader(`<root><item/></root>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	root := doc.DocumentElement()
+
+	list := doc.GetElementsByTagName("item").(*nodeList)
+	children := root.ChildNodes().(*nodeList)
+	updates := 0
+	for _, l := range []*nodeList{list, children} {
+		update := l.update
+		l.update = func() {
+			updates++
+			update()
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		item, _ := doc.CreateElement("item")
+		root.AppendChild(item)
+	}
+	if updates != 0 {
+		t.Errorf("lists were recomputed %d times before being read", updates)
+	}
+
+	if list.Length() != 101 || children.Length() != 101 {
+		t.Errorf("Length() = %d and %d, want 101", list.Length(), children.Length())
+	}
+	list.Item(100)
+	children.Item(100)
+	if updates != 2 {
+		t.Errorf("lists were recomputed %d times, want once each", updates)
+	}
+}
+
+// TestLiveListSkipsMutationsOutsideRoot checks that a list stays clean
+// while the tree changes outside its root, and is refreshed when a node is
+// moved out of it.
+func TestLiveListSkipsMutationsOutsideRoot(t *testing.T) {
+	doc, err := NewDecoder(strings.NewReader(`<root><a><item/></a><b/></root>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	a := doc.DocumentElement().FirstChild().(Element)
+	b := a.NextSibling().(Element)
+
+	list := a.GetElementsByTagName("item").(*nodeList)
+	if list.Length() != 1 {
+		t.Fatalf("Length() = %d, want 1", list.Length())
+	}
+	for i := 0; i < 10; i++ {
+		item, _ := doc.CreateElement("item")
+		b.AppendChild(item)
+		item.SetAttribute("n", "1")
+	}
+	if list.dirty {
+		t.Error("list was marked dirty by mutations outside its root")
+	}
+
+	b.AppendChild(a.FirstChild())
+	if list.Length() != 0 {
+		t.Errorf("Length() = %d after moving the item out, want 0", list.Length())
+	}
+	a.AppendChild(b.FirstChild())
+	if list.Length() != 1 {
+		t.Errorf("Length() = %d after moving an item in, want 1", list.Length())
+	}
+}
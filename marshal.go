@@ -22,82 +22,123 @@ func UnmarshalDOM(data []byte) (Document, error) {
 // Marshal returns the XML encoding of v.
 // This function handles both DOM Documents and regular structs.
 func Marshal(v interface{}) ([]byte, error) {
-	// Check if v is a DOM Document
-	if doc, ok := v.(Document); ok {
-		return marshalDOM(doc)
-	}
-	// Check if v is a DOM Element
-	if elem, ok := v.(Element); ok {
-		return marshalElement(elem)
-	}
 	// Check if v is any DOM Node
 	if node, ok := v.(Node); ok {
-		return marshalNode(node)
+		return marshalDOMNode(node, &marshalWriter{})
 	}
 	// For non-DOM objects, delegate to Go's standard xml.Marshal
 	return xml.Marshal(v)
 }
 
-// marshalDOM serializes a DOM Document to XML
-func marshalDOM(doc Document) ([]byte, error) {
-	var buf bytes.Buffer
+// MarshalIndent works like Marshal, but each element of a DOM node begins
+// on a new line that starts with prefix followed by one copy of indent per
+// level of nesting. Elements that hold only text stay on one line. Values
+// other than DOM nodes are passed to encoding/xml's MarshalIndent.
+func MarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
+	node, ok := v.(Node)
+	if !ok {
+		return xml.MarshalIndent(v, prefix, indent)
+	}
+	return marshalDOMNode(node, &marshalWriter{prefix: prefix, indent: indent})
+}
 
-	// Write XML declaration
-	buf.WriteString(`<?xml version="1.0"?>`)
+// marshalDOMNode serializes a DOM node to XML. A Document is written as an
+// XML declaration followed by its document element; other nodes are
+// written without a declaration.
+func marshalDOMNode(node Node, buf *marshalWriter) ([]byte, error) {
+	if doc, ok := node.(Document); ok {
+		// Write XML declaration
+		buf.WriteString(`<?xml version="1.0"?>`)
+		buf.putNewline = true
 
-	// Serialize the document element
-	root := doc.DocumentElement()
-	if root == nil {
-		return buf.Bytes(), nil // Empty document
+		// Serialize the document element
+		root := doc.DocumentElement()
+		if root == nil {
+			return buf.Bytes(), nil // Empty document
+		}
+		node = root
 	}
-
-	if err := serializeElement(&buf, root, false); err != nil {
+	if err := serializeNode(buf, node, rootScope()); err != nil {
 		return nil, err
 	}
-
 	return buf.Bytes(), nil
 }
 
-// marshalElement serializes a DOM Element to XML (without XML declaration)
-func marshalElement(elem Element) ([]byte, error) {
-	var buf bytes.Buffer
-	if err := serializeElement(&buf, elem, false); err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+// marshalWriter collects the output of Marshal and MarshalIndent. With a
+// prefix or indent, each start tag begins a new line indented by its
+// depth, and so does an end tag after child elements, which is how
+// encoding/xml lays out indented output.
+type marshalWriter struct {
+	bytes.Buffer
+	prefix, indent string
+	depth          int
+	indentedIn     bool // the last tag written is a start tag
+	putNewline     bool // the next indented tag starts a new line
 }
 
-// marshalNode serializes any DOM Node to XML (without XML declaration)
-func marshalNode(node Node) ([]byte, error) {
-	var buf bytes.Buffer
-	if err := serializeNode(&buf, node); err != nil {
-		return nil, err
+// writeIndent starts a new line for a start tag, with depthDelta 1, or an
+// end tag, with depthDelta -1
+func (buf *marshalWriter) writeIndent(depthDelta int) {
+	if buf.prefix == "" && buf.indent == "" {
+		return
 	}
-	return buf.Bytes(), nil
+	if depthDelta < 0 {
+		buf.depth--
+		if buf.indentedIn {
+			buf.indentedIn = false
+			return
+		}
+	}
+	if buf.putNewline {
+		buf.WriteByte('\n')
+	}
+	buf.putNewline = true
+	buf.WriteString(buf.prefix)
+	for i := 0; i < buf.depth; i++ {
+		buf.WriteString(buf.indent)
+	}
+	if depthDelta > 0 {
+		buf.depth++
+		buf.indentedIn = true
+	}
+}
+
+// rootScope returns the namespace bindings in effect where serialization
+// starts: only the xml prefix is bound
+func rootScope() map[DOMString]DOMString {
+	return map[DOMString]DOMString{"xml": xmlNamespaceURI}
 }
 
-// serializeElement serializes an Element and its children to XML
-func serializeElement(buf *bytes.Buffer, elem Element, skipRoot bool) error {
+// serializeElement serializes an Element and its children to XML. scope
+// holds the namespace bindings in effect at elem; declarations are added
+// wherever elem, its attributes or its descendants need a binding that is
+// not yet in scope.
+func serializeElement(buf *marshalWriter, elem Element, skipRoot bool, scope map[DOMString]DOMString) error {
+	var name string
 	if !skipRoot {
+		tag := newStartTag(elem, scope)
+		name = tag.name
+		scope = tag.scope
+
 		// Write opening tag
+		buf.writeIndent(1)
 		buf.WriteString("<")
-		buf.WriteString(string(elem.TagName()))
+		buf.WriteString(name)
 
-		// Write attributes
-		attrs := elem.Attributes()
-		if attrs != nil {
-			for i := uint(0); i < attrs.Length(); i++ {
-				attr := attrs.Item(i)
-				if attr != nil && attr.NodeType() == ATTRIBUTE_NODE {
-					if attrNode, ok := attr.(Attr); ok {
-						buf.WriteString(" ")
-						buf.WriteString(string(attrNode.Name()))
-						buf.WriteString(`="`)
-						buf.WriteString(EscapeString(string(attrNode.Value())))
-						buf.WriteString(`"`)
-					}
-				}
-			}
+		// Write namespace declarations, then the other attributes
+		for _, decl := range tag.decls {
+			buf.WriteString(" ")
+			buf.WriteString(decl.Name.Local)
+			buf.WriteString(`="`)
+			buf.WriteString(EscapeAttrValue(decl.Value))
+			buf.WriteString(`"`)
+		}
+		for i, attrNode := range tag.attrs {
+			buf.WriteString(" ")
+			buf.WriteString(tag.attrNames[i])
+			buf.WriteString(`="`)
+			buf.WriteString(attrSerializedValue(attrNode))
+			buf.WriteString(`"`)
 		}
 
 		// Check if element has children
@@ -105,8 +146,9 @@ func serializeElement(buf *bytes.Buffer, elem Element, skipRoot bool) error {
 		if !hasChildren {
 			// For SCXML conformance, always use explicit opening/closing tags
 			// instead of self-closing tags for empty elements
+			buf.writeIndent(-1)
 			buf.WriteString("></")
-			buf.WriteString(string(elem.TagName()))
+			buf.WriteString(name)
 			buf.WriteString(">")
 			return nil
 		}
@@ -116,15 +158,16 @@ func serializeElement(buf *bytes.Buffer, elem Element, skipRoot bool) error {
 
 	// Serialize children
 	for child := elem.FirstChild(); child != nil; child = child.NextSibling() {
-		if err := serializeNode(buf, child); err != nil {
+		if err := serializeNode(buf, child, scope); err != nil {
 			return err
 		}
 	}
 
 	if !skipRoot {
 		// Write closing tag
+		buf.writeIndent(-1)
 		buf.WriteString("</")
-		buf.WriteString(string(elem.TagName()))
+		buf.WriteString(name)
 		buf.WriteString(">")
 	}
 
@@ -132,18 +175,25 @@ func serializeElement(buf *bytes.Buffer, elem Element, skipRoot bool) error {
 }
 
 // serializeNode serializes any DOM node to XML
-func serializeNode(buf *bytes.Buffer, node Node) error {
+func serializeNode(buf *marshalWriter, node Node, scope map[DOMString]DOMString) error {
 	switch node.NodeType() {
 	case ELEMENT_NODE:
 		if elem, ok := node.(Element); ok {
-			return serializeElement(buf, elem, false)
+			return serializeElement(buf, elem, false, scope)
 		}
 	case TEXT_NODE:
 		if text, ok := node.(Text); ok {
 			buf.WriteString(EscapeString(string(text.Data())))
 		}
+	case ENTITY_REFERENCE_NODE:
+		buf.WriteString("&")
+		buf.WriteString(string(node.NodeName()))
+		buf.WriteString(";")
 	case COMMENT_NODE:
 		if comment, ok := node.(Comment); ok {
+			if err := checkComment(comment.Data()); err != nil {
+				return err
+			}
 			buf.WriteString("<!--")
 			buf.WriteString(string(comment.Data()))
 			buf.WriteString("-->")
@@ -151,11 +201,14 @@ func serializeNode(buf *bytes.Buffer, node Node) error {
 	case CDATA_SECTION_NODE:
 		if cdata, ok := node.(CDATASection); ok {
 			buf.WriteString("<![CDATA[")
-			buf.WriteString(string(cdata.Data()))
+			buf.WriteString(strings.ReplaceAll(string(cdata.Data()), "]]>", "]]]]><![CDATA[>"))
 			buf.WriteString("]]>")
 		}
 	case PROCESSING_INSTRUCTION_NODE:
 		if pi, ok := node.(ProcessingInstruction); ok {
+			if err := checkProcInst(pi.Data()); err != nil {
+				return err
+			}
 			buf.WriteString("<?")
 			buf.WriteString(string(pi.Target()))
 			if data := string(pi.Data()); data != "" {
@@ -168,3 +221,83 @@ func serializeNode(buf *bytes.Buffer, node Node) error {
 	}
 	return nil
 }
+
+// fragmentWrapper is the name of the element that parseFragment wraps
+// markup in so it can be read as a document
+const fragmentWrapper = "xmldom-fragment"
+
+// parseFragment parses markup as the content of an element placed at
+// context, so prefixes and the default namespace in scope at context apply
+// to it. The parsed nodes are adopted into doc, which indexes their ids, and
+// returned in order. Malformed markup returns SyntaxError.
+func parseFragment(doc Document, context Node, markup DOMString) ([]Node, error) {
+	var src strings.Builder
+	src.WriteString("<" + fragmentWrapper)
+	for prefix, uri := range inScopeNamespaces(context) {
+		if prefix == "" {
+			src.WriteString(` xmlns="`)
+		} else {
+			src.WriteString(" xmlns:" + prefix + `="`)
+		}
+		src.WriteString(EscapeString(uri))
+		src.WriteString(`"`)
+	}
+	src.WriteString(">")
+	src.WriteString(string(markup))
+	src.WriteString("</" + fragmentWrapper + ">")
+
+	parsed, err := NewDecoder(strings.NewReader(src.String())).Decode()
+	if err != nil {
+		return nil, NewDOMException("SyntaxError", err.Error())
+	}
+	wrapper := parsed.DocumentElement()
+	if wrapper == nil || wrapper.LocalName() != fragmentWrapper {
+		return nil, NewDOMException("SyntaxError", "markup is not a well-formed fragment")
+	}
+
+	var nodes []Node
+	for child := wrapper.FirstChild(); child != nil; {
+		next := child.NextSibling()
+		adopted, err := doc.AdoptNode(child)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, adopted)
+		child = next
+	}
+	return nodes, nil
+}
+
+// inScopeNamespaces returns the prefix to namespace URI bindings in effect
+// at n, with "" standing for the default namespace
+func inScopeNamespaces(n Node) map[string]string {
+	bindings := make(map[string]string)
+	bind := func(prefix, uri string) {
+		if _, ok := bindings[prefix]; !ok {
+			bindings[prefix] = uri
+		}
+	}
+	for ; n != nil; n = n.ParentNode() {
+		elem, ok := n.(Element)
+		if !ok {
+			continue
+		}
+		attrs := elem.Attributes()
+		for i := uint(0); i < attrs.Length(); i++ {
+			attr, ok := attrs.Item(i).(Attr)
+			if !ok || !IsNamespaceDeclaration(attr) {
+				continue
+			}
+			if attr.NodeName() == "xmlns" {
+				bind("", string(attr.Value()))
+			} else {
+				bind(string(attr.LocalName()), string(attr.Value()))
+			}
+		}
+		if prefix := elem.Prefix(); prefix != "" {
+			bind(string(prefix), string(elem.NamespaceURI()))
+		}
+	}
+	delete(bindings, "xml")
+	return bindings
+}
@@ -0,0 +1,50 @@
+package xmldom
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestActiveRangesHeldWeakly checks that the document does not keep ranges
+// alive: one that is dropped without Detach stops being tracked once it is
+// collected, and Detach unregisters one still in use.
+func TestActiveRangesHeldWeakly(t *testing.T) {
+	doc, err := NewDecoder(strings.NewReader(`<root><a/><b/></root>`)).Decode()
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	d := doc.(*document)
+	tracked := func() int {
+		n := 0
+		for _, p := range d.activeRanges {
+			if p.Value() != nil {
+				n++
+			}
+		}
+		return n
+	}
+
+	kept := doc.CreateRange()
+	for i := 0; i < 10; i++ {
+		doc.CreateRange().CloneRange()
+	}
+	runtime.GC()
+	if n := tracked(); n != 1 {
+		t.Errorf("%d ranges tracked after collection, want only the one still referenced", n)
+	}
+
+	// MoveTo drops the entries of collected ranges
+	root := doc.DocumentElement()
+	if err := root.LastChild().MoveTo(root, root.FirstChild()); err != nil {
+		t.Fatalf("MoveTo() failed: %v", err)
+	}
+	if len(d.activeRanges) != 1 {
+		t.Errorf("MoveTo() left %d entries, want 1", len(d.activeRanges))
+	}
+
+	kept.Detach()
+	if len(d.activeRanges) != 0 {
+		t.Errorf("Detach() left %d entries, want none", len(d.activeRanges))
+	}
+}
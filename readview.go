@@ -0,0 +1,193 @@
+package xmldom
+
+// ReadOnlyNode is a non-mutating view of a node. It exposes navigation and
+// inspection only, so code holding a ReadOnlyNode cannot change the tree.
+type ReadOnlyNode interface {
+	NodeType() uint16
+	NodeName() DOMString
+	LocalName() DOMString
+	NamespaceURI() DOMString
+	NodeValue() DOMString
+	TextContent() DOMString
+	GetAttribute(name DOMString) DOMString
+	HasAttribute(name DOMString) bool
+	ParentNode() ReadOnlyNode
+	FirstChild() ReadOnlyNode
+	LastChild() ReadOnlyNode
+	PreviousSibling() ReadOnlyNode
+	NextSibling() ReadOnlyNode
+	ChildNodes() []ReadOnlyNode
+}
+
+// ReadOnlyDocument is a non-mutating view of a Document that is safe to
+// share between goroutines. Every read holds the document's read lock for
+// its whole duration, so multi-step reads such as TextContent or
+// GetElementsByTagName see a consistent tree.
+type ReadOnlyDocument interface {
+	ReadOnlyNode
+	DocumentElement() ReadOnlyNode
+	GetElementById(elementId DOMString) ReadOnlyNode
+	GetElementsByTagName(name DOMString) []ReadOnlyNode
+}
+
+// ReadView returns a read-only view of the document
+func (d *document) ReadView() ReadOnlyDocument {
+	return &readOnlyDocument{readOnlyNode{n: d, doc: d}}
+}
+
+// readOnlyNode wraps a Node and guards reads with the owner document's
+// read lock. The wrapped methods used here do not lock themselves.
+type readOnlyNode struct {
+	n   Node
+	doc *document
+}
+
+func (r readOnlyNode) wrap(n Node) ReadOnlyNode {
+	if n == nil {
+		return nil
+	}
+	return readOnlyNode{n: n, doc: r.doc}
+}
+
+func (r readOnlyNode) NodeType() uint16 {
+	return r.n.NodeType()
+}
+
+func (r readOnlyNode) NodeName() DOMString {
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	return r.n.NodeName()
+}
+
+func (r readOnlyNode) LocalName() DOMString {
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	return r.n.LocalName()
+}
+
+func (r readOnlyNode) NamespaceURI() DOMString {
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	return r.n.NamespaceURI()
+}
+
+func (r readOnlyNode) NodeValue() DOMString {
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	return r.n.NodeValue()
+}
+
+func (r readOnlyNode) TextContent() DOMString {
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	return r.n.TextContent()
+}
+
+func (r readOnlyNode) GetAttribute(name DOMString) DOMString {
+	e, ok := r.n.(*element)
+	if !ok {
+		return ""
+	}
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	if e.attributes != nil {
+		if attr := e.attributes.GetNamedItem(name); attr != nil {
+			return attr.NodeValue()
+		}
+	}
+	return ""
+}
+
+func (r readOnlyNode) HasAttribute(name DOMString) bool {
+	e, ok := r.n.(*element)
+	if !ok {
+		return false
+	}
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	return e.hasAttributeInternal(name)
+}
+
+func (r readOnlyNode) ParentNode() ReadOnlyNode {
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	return r.wrap(r.n.ParentNode())
+}
+
+func (r readOnlyNode) FirstChild() ReadOnlyNode {
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	return r.wrap(r.n.FirstChild())
+}
+
+func (r readOnlyNode) LastChild() ReadOnlyNode {
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	return r.wrap(r.n.LastChild())
+}
+
+func (r readOnlyNode) PreviousSibling() ReadOnlyNode {
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	return r.wrap(r.n.PreviousSibling())
+}
+
+func (r readOnlyNode) NextSibling() ReadOnlyNode {
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	return r.wrap(r.n.NextSibling())
+}
+
+// ChildNodes returns a snapshot of the children
+func (r readOnlyNode) ChildNodes() []ReadOnlyNode {
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	var children []ReadOnlyNode
+	for child := r.n.FirstChild(); child != nil; child = child.NextSibling() {
+		children = append(children, r.wrap(child))
+	}
+	return children
+}
+
+type readOnlyDocument struct {
+	readOnlyNode
+}
+
+func (r *readOnlyDocument) DocumentElement() ReadOnlyNode {
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	if r.doc.documentElement == nil {
+		return nil
+	}
+	return r.wrap(r.doc.documentElement)
+}
+
+func (r *readOnlyDocument) GetElementById(elementId DOMString) ReadOnlyNode {
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	if elem := r.doc.elementById(elementId); elem != nil {
+		return r.wrap(elem)
+	}
+	return nil
+}
+
+// GetElementsByTagName returns a snapshot of the elements in document order
+// whose qualified name matches name; "*" matches all elements.
+func (r *readOnlyDocument) GetElementsByTagName(name DOMString) []ReadOnlyNode {
+	r.doc.mu.RLock()
+	defer r.doc.mu.RUnlock()
+	var result []ReadOnlyNode
+	var collect func(parent Node)
+	collect = func(parent Node) {
+		for child := parent.FirstChild(); child != nil; child = child.NextSibling() {
+			if child.NodeType() == ELEMENT_NODE {
+				if name == "*" || child.NodeName() == name {
+					result = append(result, r.wrap(child))
+				}
+				collect(child)
+			}
+		}
+	}
+	collect(r.doc)
+	return result
+}
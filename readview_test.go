@@ -0,0 +1,74 @@
+package xmldom_test
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gogo-agent/xmldom"
+)
+
+func TestReadView(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(`<library><book id="b1">Go</book><book id="b2">XML</book></library>`))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+	view := doc.ReadView()
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 64)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				root := view.DocumentElement()
+				if root == nil || root.NodeName() != "library" {
+					errs <- "missing document element"
+					return
+				}
+				if books := view.GetElementsByTagName("book"); len(books) != 2 {
+					errs <- "expected 2 books"
+					return
+				}
+				if b := view.GetElementById("b2"); b == nil || b.TextContent() != "XML" {
+					errs <- "GetElementById(b2) failed"
+					return
+				}
+				if first := root.FirstChild(); first.GetAttribute("id") != "b1" || first.NextSibling().GetAttribute("id") != "b2" {
+					errs <- "unexpected sibling navigation"
+					return
+				}
+			}
+		}()
+	}
+	// A writer on the underlying document runs alongside the readers
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 100; j++ {
+			doc.DocumentElement().SetAttribute("rev", xmldom.DOMString(strings.Repeat("x", j%5)))
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Error(e)
+	}
+
+	// The view's method set contains no mutators
+	for _, typ := range []reflect.Type{
+		reflect.TypeOf((*xmldom.ReadOnlyDocument)(nil)).Elem(),
+		reflect.TypeOf((*xmldom.ReadOnlyNode)(nil)).Elem(),
+	} {
+		for i := 0; i < typ.NumMethod(); i++ {
+			name := typ.Method(i).Name
+			for _, prefix := range []string{"Set", "Append", "Insert", "Remove", "Replace", "Create", "Normalize"} {
+				if strings.HasPrefix(name, prefix) {
+					t.Errorf("%s exposes mutator %s", typ.Name(), name)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package xmldom
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// Reindent reads XML from r and writes it to w with each element, comment
+// and processing instruction on its own line, starting with prefix and
+// followed by one copy of indent per level of nesting. It streams tokens
+// without building a DOM, so memory use does not grow with the document.
+//
+// Tags, attributes, comments and CDATA sections are copied byte for byte.
+// Whitespace-only text between tags is treated as formatting and replaced.
+// Once an element is found to contain other text (mixed content), the rest
+// of that element is copied verbatim, including any whitespace.
+func Reindent(r io.Reader, w io.Writer, prefix, indent string) error {
+	rec := &recordingReader{r: r}
+	dec := xml.NewDecoder(rec)
+	out := bufio.NewWriter(w)
+
+	ri := &reindenter{out: out, prefix: prefix, indent: indent}
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &ParsingError{Err: err}
+		}
+		raw := rec.slice(start, dec.InputOffset())
+		ri.token(tok, raw)
+		rec.discard(dec.InputOffset())
+	}
+	if ri.depth != 0 {
+		return &ParsingError{Err: io.ErrUnexpectedEOF}
+	}
+	return out.Flush()
+}
+
+// recordingReader keeps the bytes read from r that the decoder has not yet
+// consumed as complete tokens, so each token's source text can be copied.
+type recordingReader struct {
+	r    io.Reader
+	buf  []byte
+	base int64 // input offset of buf[0]
+}
+
+func (rr *recordingReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.buf = append(rr.buf, p[:n]...)
+	return n, err
+}
+
+func (rr *recordingReader) slice(start, end int64) []byte {
+	return rr.buf[start-rr.base : end-rr.base]
+}
+
+// discard drops recorded bytes before offset
+func (rr *recordingReader) discard(offset int64) {
+	n := copy(rr.buf, rr.buf[offset-rr.base:])
+	rr.buf = rr.buf[:n]
+	rr.base = offset
+}
+
+type reindentLevel struct {
+	hasChildren bool // an element, comment or PI was written inside
+}
+
+type reindenter struct {
+	out            *bufio.Writer
+	prefix, indent string
+
+	levels   []reindentLevel
+	depth    int
+	verbatim int    // depth at which verbatim copying started, 0 if none
+	pending  []byte // whitespace-only text seen since the last token
+	wrote    bool
+}
+
+func (ri *reindenter) newline(depth int) {
+	if ri.wrote {
+		ri.out.WriteByte('\n')
+	}
+	ri.wrote = true
+	ri.out.WriteString(ri.prefix)
+	for i := 0; i < depth; i++ {
+		ri.out.WriteString(ri.indent)
+	}
+}
+
+// child starts a new line for a markup token inside the current element
+func (ri *reindenter) child() {
+	if ri.depth > 0 {
+		ri.levels[ri.depth-1].hasChildren = true
+	}
+	ri.newline(ri.depth)
+}
+
+func (ri *reindenter) token(tok xml.Token, raw []byte) {
+	if ri.verbatim > 0 {
+		ri.out.Write(ri.pending)
+		ri.pending = nil
+		ri.out.Write(raw)
+		switch tok.(type) {
+		case xml.StartElement:
+			ri.depth++
+			ri.levels = append(ri.levels, reindentLevel{})
+		case xml.EndElement:
+			ri.depth--
+			ri.levels = ri.levels[:len(ri.levels)-1]
+			if ri.depth < ri.verbatim {
+				ri.verbatim = 0
+			}
+		}
+		return
+	}
+
+	switch t := tok.(type) {
+	case xml.StartElement:
+		ri.child()
+		ri.out.Write(raw)
+		ri.depth++
+		ri.levels = append(ri.levels, reindentLevel{})
+	case xml.EndElement:
+		// The end of a self-closing tag has no source text of its own
+		if len(raw) > 0 {
+			if ri.levels[ri.depth-1].hasChildren {
+				ri.newline(ri.depth - 1)
+			}
+			ri.out.Write(raw)
+		}
+		ri.depth--
+		ri.levels = ri.levels[:len(ri.levels)-1]
+	case xml.CharData:
+		isCDATA := bytes.HasPrefix(raw, []byte("<![CDATA["))
+		if !isCDATA && strings.TrimSpace(string(t)) == "" {
+			ri.pending = append(ri.pending[:0], raw...)
+			return
+		}
+		if ri.depth == 0 {
+			// Text outside the document element is not well-formed; copy it
+			ri.out.Write(raw)
+			ri.wrote = true
+		} else {
+			ri.out.Write(ri.pending)
+			ri.out.Write(raw)
+			ri.verbatim = ri.depth
+		}
+	default:
+		// Comments, processing instructions and directives
+		ri.child()
+		ri.out.Write(raw)
+	}
+	ri.pending = nil
+}
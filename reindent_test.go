@@ -0,0 +1,44 @@
+package xmldom_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gogo-agent/xmldom"
+)
+
+func TestReindent(t *testing.T) {
+	input := `<?xml version="1.0"?><!-- top --><root a="1"><item id="x"><name>First</name><empty/></item>` +
+		`<p>Some <b>bold</b>  text</p><code><![CDATA[if a < b {}]]></code></root>`
+	want := `<?xml version="1.0"?>
+<!-- top -->
+<root a="1">
+  <item id="x">
+    <name>First</name>
+    <empty/>
+  </item>
+  <p>Some <b>bold</b>  text</p>
+  <code><![CDATA[if a < b {}]]></code>
+</root>`
+
+	var out strings.Builder
+	if err := xmldom.Reindent(strings.NewReader(input), &out, "", "  "); err != nil {
+		t.Fatalf("Reindent failed: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("Reindent output:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	// Existing formatting is replaced rather than added to
+	var again strings.Builder
+	if err := xmldom.Reindent(strings.NewReader(want), &again, "", "  "); err != nil {
+		t.Fatalf("Reindent failed: %v", err)
+	}
+	if again.String() != want {
+		t.Errorf("Reindent is not idempotent:\n%s", again.String())
+	}
+
+	if err := xmldom.Reindent(strings.NewReader("<root><a>"), &out, "", "  "); err == nil {
+		t.Errorf("expected error for truncated input")
+	}
+}
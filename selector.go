@@ -0,0 +1,334 @@
+package xmldom
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A selector is a chain of compound selectors joined by combinators, as in
+// "section > item.new". compounds[i] is joined to compounds[i-1] by
+// combinators[i-1].
+type selector struct {
+	compounds   []compoundSelector
+	combinators []byte // ' ' for descendant, '>' for child
+}
+
+// compoundSelector is a sequence of simple selectors that must all match
+// the same element
+type compoundSelector struct {
+	tagName DOMString // "" or "*" matches any element
+	id      DOMString
+	classes []DOMString
+	attrs   []attributeSelector
+}
+
+type attributeSelector struct {
+	name     DOMString
+	value    DOMString
+	hasValue bool
+}
+
+// parseSelector parses the supported subset of CSS selectors: type and
+// universal selectors, #id, .class, [attr] and [attr=value], joined by
+// descendant and child combinators. Type selectors are matched against
+// qualified names, so they may contain a colon. Anything else returns
+// SyntaxError.
+func parseSelector(text string) (*selector, error) {
+	p := &selectorParser{src: text}
+	sel := &selector{}
+	p.skipSpace()
+	for {
+		compound, err := p.compound()
+		if err != nil {
+			return nil, err
+		}
+		sel.compounds = append(sel.compounds, compound)
+
+		hadSpace := p.skipSpace()
+		if p.done() {
+			return sel, nil
+		}
+		combinator := byte(' ')
+		if p.peek() == '>' {
+			combinator = '>'
+			p.pos++
+			p.skipSpace()
+		} else if !hadSpace {
+			return nil, p.errorf("unexpected %q", p.peek())
+		}
+		sel.combinators = append(sel.combinators, combinator)
+	}
+}
+
+type selectorParser struct {
+	src string
+	pos int
+}
+
+func (p *selectorParser) done() bool { return p.pos >= len(p.src) }
+
+func (p *selectorParser) peek() byte { return p.src[p.pos] }
+
+func (p *selectorParser) errorf(format string, args ...any) error {
+	return NewDOMException("SyntaxError", fmt.Sprintf("invalid selector %q at offset %d: ", p.src, p.pos)+fmt.Sprintf(format, args...))
+}
+
+func (p *selectorParser) skipSpace() bool {
+	start := p.pos
+	for !p.done() && strings.IndexByte(" \t\r\n", p.peek()) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+// ident reads a name. Colons are allowed only when qualified is true; dots
+// always end a name since they start a class selector.
+func (p *selectorParser) ident(qualified bool) string {
+	start := p.pos
+	for _, r := range p.src[p.pos:] {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r < utf8.RuneSelf && !(qualified && r == ':') {
+			break
+		}
+		p.pos += utf8.RuneLen(r)
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *selectorParser) compound() (compoundSelector, error) {
+	var c compoundSelector
+	start := p.pos
+	if !p.done() && p.peek() == '*' {
+		c.tagName = "*"
+		p.pos++
+	} else {
+		c.tagName = DOMString(p.ident(true))
+	}
+	for !p.done() {
+		switch p.peek() {
+		case '#':
+			p.pos++
+			id := p.ident(false)
+			if id == "" {
+				return c, p.errorf("expected an id after #")
+			}
+			c.id = DOMString(id)
+		case '.':
+			p.pos++
+			class := p.ident(false)
+			if class == "" {
+				return c, p.errorf("expected a class name after .")
+			}
+			c.classes = append(c.classes, DOMString(class))
+		case '[':
+			attr, err := p.attribute()
+			if err != nil {
+				return c, err
+			}
+			c.attrs = append(c.attrs, attr)
+		default:
+			if p.pos == start {
+				return c, p.errorf("expected a selector, found %q", p.peek())
+			}
+			return c, nil
+		}
+	}
+	if p.pos == start {
+		return c, p.errorf("expected a selector")
+	}
+	return c, nil
+}
+
+// attribute reads an [attr] or [attr=value] selector; value may be quoted
+func (p *selectorParser) attribute() (attributeSelector, error) {
+	var a attributeSelector
+	p.pos++ // [
+	p.skipSpace()
+	name := p.ident(true)
+	if name == "" {
+		return a, p.errorf("expected an attribute name")
+	}
+	a.name = DOMString(name)
+	p.skipSpace()
+	if !p.done() && p.peek() == '=' {
+		p.pos++
+		p.skipSpace()
+		if p.done() {
+			return a, p.errorf("expected an attribute value")
+		}
+		if quote := p.peek(); quote == '"' || quote == '\'' {
+			end := strings.IndexByte(p.src[p.pos+1:], quote)
+			if end < 0 {
+				return a, p.errorf("unterminated string")
+			}
+			a.value = DOMString(p.src[p.pos+1 : p.pos+1+end])
+			p.pos += end + 2
+		} else {
+			value := p.ident(true)
+			if value == "" {
+				return a, p.errorf("expected an attribute value")
+			}
+			a.value = DOMString(value)
+		}
+		a.hasValue = true
+		p.skipSpace()
+	}
+	if p.done() || p.peek() != ']' {
+		return a, p.errorf("expected ]")
+	}
+	p.pos++
+	return a, nil
+}
+
+// matches reports whether e matches the whole selector
+func (s *selector) matches(e *element) bool {
+	return s.matchesFrom(e, len(s.compounds)-1)
+}
+
+// matchesFrom reports whether e matches compounds[i] and the ancestors of
+// e match the compounds before it
+func (s *selector) matchesFrom(e *element, i int) bool {
+	if !s.compounds[i].matches(e) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	for parent := e.parentNode; parent != nil; parent = getInternalNode(parent).parentNode {
+		ancestor, ok := parent.(*element)
+		if !ok {
+			return false
+		}
+		if s.matchesFrom(ancestor, i-1) {
+			return true
+		}
+		if s.combinators[i-1] == '>' {
+			return false
+		}
+	}
+	return false
+}
+
+func (c *compoundSelector) matches(e *element) bool {
+	if c.tagName != "" && c.tagName != "*" && c.tagName != e.nodeName {
+		return false
+	}
+	attrValue := func(name DOMString) (DOMString, bool) {
+		if e.attributes == nil {
+			return "", false
+		}
+		if attr := e.attributes.GetNamedItem(name); attr != nil {
+			return attr.NodeValue(), true
+		}
+		return "", false
+	}
+	if c.id != "" {
+		d, _ := e.ownerDocument.(*document)
+		if id, _ := attrValue(d.idAttribute()); id != c.id {
+			return false
+		}
+	}
+	if len(c.classes) > 0 {
+		classValue, _ := attrValue("class")
+		classes := strings.Fields(string(classValue))
+		for _, want := range c.classes {
+			found := false
+			for _, class := range classes {
+				if class == string(want) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	for _, a := range c.attrs {
+		value, ok := attrValue(a.name)
+		if !ok || a.hasValue && value != a.value {
+			return false
+		}
+	}
+	return true
+}
+
+// QuerySelector returns the first element in document order that matches
+// selector, or nil if none does. Supported are type selectors, matched
+// case-sensitively against qualified names, the universal selector *,
+// #id (matched against the attribute named by SetIdAttributeName), .class
+// (a whitespace-separated token of the class attribute), [attr] and
+// [attr=value], and the descendant and child combinators. An
+// unsupported or malformed selector returns SyntaxError.
+func (d *document) QuerySelector(selector string) (Element, error) {
+	sel, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if e := firstMatch(d, sel); e != nil {
+		return e, nil
+	}
+	return nil, nil
+}
+
+// QuerySelectorAll returns a static NodeList of the elements that match
+// selector, in document order. The selectors supported are those of
+// QuerySelector. The list is a snapshot: it is not updated when the tree
+// changes afterwards.
+func (d *document) QuerySelectorAll(selector string) (NodeList, error) {
+	sel, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	items := []Node{}
+	collectElements(d, func(e *element) {
+		if sel.matches(e) {
+			items = append(items, e)
+		}
+	})
+	return &nodeList{items: items}, nil
+}
+
+// Closest returns e or its nearest ancestor element that matches selector,
+// or nil if none does. The walk stops at the first ancestor that is not an
+// element, such as the document. The selectors supported are those of
+// Document.QuerySelector.
+func (e *element) Closest(selector string) (Element, error) {
+	sel, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := e.ownerDocument.(*document); ok {
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+	}
+	for current := e; current != nil; {
+		if sel.matches(current) {
+			return current, nil
+		}
+		current, _ = current.parentNode.(*element)
+	}
+	return nil, nil
+}
+
+// firstMatch returns the first element below root that matches sel
+func firstMatch(root Node, sel *selector) *element {
+	for child := root.FirstChild(); child != nil; child = child.NextSibling() {
+		e, ok := child.(*element)
+		if !ok {
+			continue
+		}
+		if sel.matches(e) {
+			return e
+		}
+		if found := firstMatch(e, sel); found != nil {
+			return found
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package xmldom_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gogo-agent/xmldom"
+)
+
+const selectorTestXML = `<library>
+  <shelf id="s1">
+    <book class="old rare" lang="en">First</book>
+    <section><book class="new">Nested</book></section>
+  </shelf>
+  <shelf id="s2">
+    <book class="new" lang="fr">Second</book>
+    <Book>Upper</Book>
+  </shelf>
+</library>`
+
+func TestQuerySelector(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(selectorTestXML))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+
+	tests := []struct {
+		selector string
+		want     string // text of the first match, "" for none
+	}{
+		{"book", "First"},
+		{"Book", "Upper"},
+		{"BOOK", ""},
+		{"#s2 book", "Second"},
+		{".new", "Nested"},
+		{"book.old.rare", "First"},
+		{"book.new.rare", ""},
+		{"[lang]", "First"},
+		{"[lang=fr]", "Second"},
+		{`book[lang="fr"]`, "Second"},
+		{"shelf > book.new", "Second"},
+		{"shelf book.new", "Nested"},
+		{"library > book", ""},
+		{"library  >  shelf#s2 > *", "Second"},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		got, err := doc.QuerySelector(tt.selector)
+		if err != nil {
+			t.Errorf("QuerySelector(%q) failed: %v", tt.selector, err)
+			continue
+		}
+		if tt.want == "" {
+			if got != nil {
+				t.Errorf("QuerySelector(%q) = %q, want no match", tt.selector, got.TextContent())
+			}
+			continue
+		}
+		if got == nil {
+			t.Errorf("QuerySelector(%q) = nil, want %q", tt.selector, tt.want)
+		} else if string(got.TextContent()) != tt.want {
+			t.Errorf("QuerySelector(%q) = %q, want %q", tt.selector, got.TextContent(), tt.want)
+		}
+	}
+
+	if got, err := doc.QuerySelector("*"); err != nil || got != doc.DocumentElement() {
+		t.Errorf("QuerySelector(\"*\") should return the document element, got %v, %v", got, err)
+	}
+
+	for _, bad := range []string{"", "book >", "[lang", ".", "book,shelf", "a:hover("} {
+		if _, err := doc.QuerySelector(bad); err == nil || !strings.HasPrefix(err.Error(), "SyntaxError") {
+			t.Errorf("QuerySelector(%q) should fail with SyntaxError, got %v", bad, err)
+		}
+	}
+}
+
+func TestQuerySelectorAll(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(selectorTestXML))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+
+	books, err := doc.QuerySelectorAll("shelf book")
+	if err != nil {
+		t.Fatalf("QuerySelectorAll failed: %v", err)
+	}
+	var texts []string
+	for i := uint(0); i < books.Length(); i++ {
+		texts = append(texts, string(books.Item(i).TextContent()))
+	}
+	if got, want := strings.Join(texts, ","), "First,Nested,Second"; got != want {
+		t.Errorf("QuerySelectorAll(\"shelf book\") = %s, want %s", got, want)
+	}
+
+	// The result is a snapshot
+	shelf, _ := doc.QuerySelector("#s2")
+	extra, _ := doc.CreateElement("book")
+	shelf.AppendChild(extra)
+	books.Item(0).ParentNode().RemoveChild(books.Item(0))
+	if books.Length() != 3 {
+		t.Errorf("static list length changed to %d after mutation", books.Length())
+	}
+	if again, _ := doc.QuerySelectorAll("shelf book"); again.Length() != 3 || again.Item(2) != xmldom.Node(extra) {
+		t.Errorf("a new query should see the mutated tree")
+	}
+
+	if none, err := doc.QuerySelectorAll("missing"); err != nil || none.Length() != 0 {
+		t.Errorf("QuerySelectorAll with no match = %v, %v; want an empty list", none, err)
+	}
+	if _, err := doc.QuerySelectorAll("book >"); err == nil {
+		t.Errorf("QuerySelectorAll should reject a malformed selector")
+	}
+}
+
+func TestClosest(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(selectorTestXML))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+	nested, err := doc.QuerySelector("section > book")
+	if err != nil || nested == nil {
+		t.Fatalf("QuerySelector(section > book) = %v, %v", nested, err)
+	}
+
+	tests := []struct {
+		selector string
+		want     string // tag name and id of the match, "" for none
+	}{
+		{"book", "book"},
+		{"section", "section"},
+		{"shelf", "shelf#s1"},
+		{"library > shelf", "shelf#s1"},
+		{"#s2", ""},
+		{"library", "library"},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		got, err := nested.Closest(tt.selector)
+		if err != nil {
+			t.Errorf("Closest(%q) failed: %v", tt.selector, err)
+			continue
+		}
+		desc := ""
+		if got != nil {
+			desc = string(got.TagName())
+			if id := got.GetAttribute("id"); id != "" {
+				desc += "#" + string(id)
+			}
+		}
+		if desc != tt.want {
+			t.Errorf("Closest(%q) = %q, want %q", tt.selector, desc, tt.want)
+		}
+	}
+
+	if _, err := nested.Closest("book + book"); err == nil {
+		t.Errorf("Closest with an unsupported selector should fail")
+	}
+	detached, _ := doc.CreateElement("book")
+	if got, err := detached.Closest("shelf"); got != nil || err != nil {
+		t.Errorf("Closest on a detached element = %v, %v; want nil, nil", got, err)
+	}
+}
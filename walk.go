@@ -0,0 +1,147 @@
+package xmldom
+
+import "iter"
+
+// WalkAction tells a walk function how to proceed after visiting a node.
+type WalkAction int
+
+const (
+	// WalkContinue continues the walk with the next node.
+	WalkContinue WalkAction = iota
+	// WalkStop ends the walk immediately.
+	WalkStop
+)
+
+// WalkReverse calls fn for root and each of its descendants in reverse
+// document order: the last descendant is visited first and root last.
+//
+// The node preceding the current one is determined before fn is called, so
+// fn may remove the node it is given without disturbing the walk. Removing
+// other nodes during the walk may cause them to be skipped.
+func WalkReverse(root Node, fn func(Node) WalkAction) {
+	if root == nil {
+		return
+	}
+	for n := lastDescendantOrSelf(root); n != nil; {
+		prev := precedingInSubtree(root, n)
+		if fn(n) == WalkStop {
+			return
+		}
+		n = prev
+	}
+}
+
+// DescendantsReverse returns an iterator over the descendants of n in
+// reverse document order. The node itself is not included. As with
+// WalkReverse, the yielded node may be removed during iteration.
+func (n *node) DescendantsReverse() iter.Seq[Node] {
+	return descendantsReverse(n)
+}
+
+func descendantsReverse(root Node) iter.Seq[Node] {
+	return func(yield func(Node) bool) {
+		WalkReverse(root, func(n Node) WalkAction {
+			if isSameNode(n, root) || !yield(n) {
+				return WalkStop
+			}
+			return WalkContinue
+		})
+	}
+}
+
+// FindFirst returns the first descendant of n in document order for which
+// pred returns true, or nil if there is none. The node itself is not
+// tested; use FindFirstOrSelf for that. The walk stops at the first match,
+// so nothing after it is visited.
+func (n *node) FindFirst(pred func(Node) bool) Node {
+	return findFirst(n, pred)
+}
+
+// FindFirstOrSelf returns n if pred returns true for it, and otherwise the
+// result of n.FindFirst(pred).
+func FindFirstOrSelf(n Node, pred func(Node) bool) Node {
+	if n == nil {
+		return nil
+	}
+	if pred(n) {
+		return n
+	}
+	return n.FindFirst(pred)
+}
+
+func findFirst(root Node, pred func(Node) bool) Node {
+	for n := root.FirstChild(); n != nil; {
+		if pred(n) {
+			return n
+		}
+		if child := n.FirstChild(); child != nil {
+			n = child
+			continue
+		}
+		for n.NextSibling() == nil {
+			if n = n.ParentNode(); n == nil || isSameNode(n, root) {
+				return nil
+			}
+		}
+		n = n.NextSibling()
+	}
+	return nil
+}
+
+// lastDescendantOrSelf returns the last node of n's subtree in document order
+func lastDescendantOrSelf(n Node) Node {
+	for last := n.LastChild(); last != nil; last = n.LastChild() {
+		n = last
+	}
+	return n
+}
+
+// precedingInSubtree returns the node before n in document order, or nil
+// once root has been reached
+func precedingInSubtree(root, n Node) Node {
+	if isSameNode(n, root) {
+		return nil
+	}
+	if prev := n.PreviousSibling(); prev != nil {
+		return lastDescendantOrSelf(prev)
+	}
+	return n.ParentNode()
+}
+
+// CommonAncestor returns the deepest node that is an inclusive ancestor of
+// every node given, as Range.CommonAncestorContainer does for a range's two
+// boundary containers. It returns nil when no nodes are given or when they
+// are not all in the same tree.
+func CommonAncestor(nodes ...Node) Node {
+	if len(nodes) == 0 || nodes[0] == nil {
+		return nil
+	}
+	common := inclusiveAncestors(nodes[0])
+	for _, n := range nodes[1:] {
+		if n == nil {
+			return nil
+		}
+		chain := inclusiveAncestors(n)
+		shared := 0
+		for shared < len(common) && shared < len(chain) && isSameNode(common[shared], chain[shared]) {
+			shared++
+		}
+		if shared == 0 {
+			return nil
+		}
+		common = common[:shared]
+	}
+	return common[len(common)-1]
+}
+
+// inclusiveAncestors returns n and its ancestors, starting from the root
+func inclusiveAncestors(n Node) []Node {
+	var chain []Node
+	for ; n != nil; n = n.ParentNode() {
+		chain = append(chain, n)
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
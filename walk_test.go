@@ -0,0 +1,127 @@
+package xmldom_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gogo-agent/xmldom"
+)
+
+func TestWalkReverse(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(`<root><a><a1/><a2/></a><b><b1/></b></root>`))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+	root := doc.DocumentElement()
+
+	var names []string
+	xmldom.WalkReverse(root, func(n xmldom.Node) xmldom.WalkAction {
+		names = append(names, string(n.NodeName()))
+		return xmldom.WalkContinue
+	})
+	if got, want := strings.Join(names, ","), "b1,b,a2,a1,a,root"; got != want {
+		t.Errorf("WalkReverse order = %s, want %s", got, want)
+	}
+
+	names = names[:0]
+	for n := range root.DescendantsReverse() {
+		names = append(names, string(n.NodeName()))
+	}
+	if got, want := strings.Join(names, ","), "b1,b,a2,a1,a"; got != want {
+		t.Errorf("DescendantsReverse order = %s, want %s", got, want)
+	}
+
+	// Stopping early
+	count := 0
+	xmldom.WalkReverse(root, func(n xmldom.Node) xmldom.WalkAction {
+		count++
+		if n.NodeName() == "a2" {
+			return xmldom.WalkStop
+		}
+		return xmldom.WalkContinue
+	})
+	if count != 3 {
+		t.Errorf("WalkStop should end the walk after 3 nodes, visited %d", count)
+	}
+}
+
+func TestWalkReverseRemoval(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(`<root><a><a1/><a2/></a><b><b1/></b><c/></root>`))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+	root := doc.DocumentElement()
+
+	var visited []string
+	for n := range root.DescendantsReverse() {
+		visited = append(visited, string(n.NodeName()))
+		n.ParentNode().RemoveChild(n)
+	}
+	if got, want := strings.Join(visited, ","), "c,b1,b,a2,a1,a"; got != want {
+		t.Errorf("removal visited %s, want %s", got, want)
+	}
+	if root.HasChildNodes() {
+		t.Errorf("root should have no children after removing every descendant")
+	}
+}
+
+func TestCommonAncestor(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(`<root><a><a1><x/></a1><a2><y/></a2></a><b><z/></b></root>`))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+	find := func(name xmldom.DOMString) xmldom.Node {
+		return doc.GetElementsByTagName(name).Item(0)
+	}
+	x, y, z := find("x"), find("y"), find("z")
+
+	if got := xmldom.CommonAncestor(x, y, z); got != xmldom.Node(doc.DocumentElement()) {
+		t.Errorf("CommonAncestor(x, y, z) = %v, want root", got)
+	}
+	if got := xmldom.CommonAncestor(x, y); got != find("a") {
+		t.Errorf("CommonAncestor(x, y) = %v, want a", got)
+	}
+	if got := xmldom.CommonAncestor(find("a"), x); got != find("a") {
+		t.Errorf("an ancestor of the others should be its own result, got %v", got)
+	}
+	if got := xmldom.CommonAncestor(z); got != z {
+		t.Errorf("CommonAncestor of one node should be the node, got %v", got)
+	}
+
+	detached, _ := doc.CreateElement("detached")
+	if got := xmldom.CommonAncestor(x, detached); got != nil {
+		t.Errorf("nodes in different trees should have no common ancestor, got %v", got)
+	}
+	if got := xmldom.CommonAncestor(); got != nil {
+		t.Errorf("CommonAncestor() = %v, want nil", got)
+	}
+}
+
+func TestFindFirst(t *testing.T) {
+	doc, err := xmldom.UnmarshalDOM([]byte(`<root flag="r"><a><a1/><a2 flag="1"/></a><b flag="2"><b1/></b><c/></root>`))
+	if err != nil {
+		t.Fatalf("UnmarshalDOM failed: %v", err)
+	}
+	root := doc.DocumentElement()
+
+	var visited []string
+	hasFlag := func(n xmldom.Node) bool {
+		visited = append(visited, string(n.NodeName()))
+		e, ok := n.(xmldom.Element)
+		return ok && e.HasAttribute("flag")
+	}
+	found := root.FindFirst(hasFlag)
+	if found == nil || found.NodeName() != "a2" {
+		t.Fatalf("FindFirst() = %v, want a2", found)
+	}
+	if got, want := strings.Join(visited, ","), "a,a1,a2"; got != want {
+		t.Errorf("FindFirst visited %s, want %s", got, want)
+	}
+
+	if found := xmldom.FindFirstOrSelf(root, hasFlag); found != xmldom.Node(root) {
+		t.Errorf("FindFirstOrSelf() = %v, want the root", found)
+	}
+	if found := root.FirstChild().FindFirst(func(n xmldom.Node) bool { return n.NodeName() == "b1" }); found != nil {
+		t.Errorf("FindFirst() left the subtree and returned %s", found.NodeName())
+	}
+}
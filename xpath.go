@@ -3,6 +3,8 @@ package xmldom
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"iter"
 	"math"
 	"sort"
 	"strconv"
@@ -73,6 +75,12 @@ type XPathExpression interface {
 	Evaluate(contextNode Node, resultType uint16, result XPathResult) (XPathResult, error)
 	// SetVariableBindings sets variable bindings for the expression
 	SetVariableBindings(bindings map[string]XPathValue)
+	// SetVariableResolver sets a resolver consulted for variables that
+	// have no binding
+	SetVariableResolver(resolver XPathVariableResolver)
+	// SetDefaultElementNamespace makes unprefixed element name tests match
+	// only elements in namespaceURI
+	SetDefaultElementNamespace(namespaceURI string)
 }
 
 // XPathNSResolver provides namespace resolution for XPath expressions
@@ -81,6 +89,25 @@ type XPathNSResolver interface {
 	LookupNamespaceURI(prefix string) string
 }
 
+// XPathVariableResolver resolves variable references such as $target.
+// A resolver may be set on a compiled expression, or the XPathNSResolver
+// passed to CreateExpression or Evaluate may also implement it.
+// ResolveVariable returns a nil value for unknown variables.
+type XPathVariableResolver interface {
+	ResolveVariable(name string) (XPathValue, error)
+}
+
+// XPathDefaultNamespaceResolver supplies a default element namespace for
+// unprefixed element name tests, so that //state matches state elements in
+// a default-namespaced document and nothing else. The XPathNSResolver
+// passed to CreateExpression or Evaluate may implement it; a namespace set
+// with SetDefaultElementNamespace takes precedence. Without a default
+// element namespace, unprefixed names match elements by local name in any
+// namespace.
+type XPathDefaultNamespaceResolver interface {
+	DefaultElementNamespace() string
+}
+
 // XPathEvaluatorBase defines the core XPath evaluation methods
 // This will be mixed into the Document interface
 type XPathEvaluatorBase interface {
@@ -316,9 +343,13 @@ type XPathContext struct {
 	ContextSize       int
 	ContextPosition   int
 	VariableBindings  map[string]XPathValue
+	VariableResolver  XPathVariableResolver
 	FunctionLibrary   map[string]XPathFunction
 	NamespaceResolver XPathNSResolver
-	Document          Document // Access to existing DOM indexes and operations
+	// DefaultElementNamespace, when set, is the namespace unprefixed
+	// element name tests must match
+	DefaultElementNamespace string
+	Document                Document // Access to existing DOM indexes and operations
 
 	// Context for tracing and cancellation
 	Context context.Context
@@ -469,6 +500,11 @@ func (n *xpathNamespaceNode) IsEqualNode(other Node) bool {
 	}
 	return false
 }
+func (n *xpathNamespaceNode) StructuralHash() uint64 {
+	h := fnv.New64a()
+	hashNode(h, n)
+	return h.Sum64()
+}
 func (n *xpathNamespaceNode) IsSameNode(other Node) bool {
 	otherNS, ok := other.(*xpathNamespaceNode)
 	return ok && n == otherNS
@@ -491,6 +527,20 @@ func (n *xpathNamespaceNode) Attributes() NamedNodeMap
 func (n *xpathNamespaceNode) HasAttributes() bool                                   { return false }
 func (n *xpathNamespaceNode) IsSupported(feature DOMString, version DOMString) bool { return false }
 func (n *xpathNamespaceNode) GetRootNode() Node                                     { return n.ownerElement.GetRootNode() }
+func (n *xpathNamespaceNode) PreviousElementSibling() Element                       { return nil }
+func (n *xpathNamespaceNode) NextElementSibling() Element                           { return nil }
+func (n *xpathNamespaceNode) Depth() int                                            { return n.ownerElement.Depth() + 1 }
+func (n *xpathNamespaceNode) SubtreeNodeCount() int                                 { return 1 }
+func (n *xpathNamespaceNode) TextNodes(includeCDATA bool) []Text                    { return nil }
+func (n *xpathNamespaceNode) EachAttribute(fn func(owner Element, attr Attr))       {}
+func (n *xpathNamespaceNode) DescendantsReverse() iter.Seq[Node]                    { return func(func(Node) bool) {} }
+func (n *xpathNamespaceNode) FindFirst(pred func(Node) bool) Node                   { return nil }
+func (n *xpathNamespaceNode) MoveTo(newParent Node, refChild Node) error {
+	return NewDOMException("HierarchyRequestError", "Namespace nodes cannot be moved")
+}
+func (n *xpathNamespaceNode) ResolveURI(relative DOMString) (DOMString, error) {
+	return n.ownerElement.ResolveURI(relative)
+}
 
 // Concrete AST node implementations
 
@@ -514,14 +564,16 @@ func (n xpathPathNode) Evaluate(ctx *XPathContext) (XPathValue, error) {
 			// Create new context for this step with proper position tracking
 			// XPath positions are 1-based, not 0-based
 			stepCtx := &XPathContext{
-				ContextNode:       node,
-				ContextSize:       len(currentNodes),
-				ContextPosition:   position + 1, // Convert to 1-based position
-				VariableBindings:  ctx.VariableBindings,
-				FunctionLibrary:   ctx.FunctionLibrary,
-				NamespaceResolver: ctx.NamespaceResolver,
-				Document:          ctx.Document,
-				Context:           ctx.Context,
+				ContextNode:             node,
+				ContextSize:             len(currentNodes),
+				ContextPosition:         position + 1, // Convert to 1-based position
+				VariableBindings:        ctx.VariableBindings,
+				VariableResolver:        ctx.VariableResolver,
+				FunctionLibrary:         ctx.FunctionLibrary,
+				NamespaceResolver:       ctx.NamespaceResolver,
+				DefaultElementNamespace: ctx.DefaultElementNamespace,
+				Document:                ctx.Document,
+				Context:                 ctx.Context,
 			}
 
 			// Evaluate the step
@@ -610,14 +662,16 @@ func (n xpathAxisNode) Evaluate(ctx *XPathContext) (XPathValue, error) {
 			for i, node := range currentNodes {
 				// Create new context for predicate evaluation
 				predCtx := &XPathContext{
-					ContextNode:       node,
-					ContextSize:       len(currentNodes), // Size of current node set
-					ContextPosition:   i + 1,             // Position within current node set (1-based)
-					VariableBindings:  ctx.VariableBindings,
-					FunctionLibrary:   ctx.FunctionLibrary,
-					NamespaceResolver: ctx.NamespaceResolver,
-					Document:          ctx.Document,
-					Context:           ctx.Context,
+					ContextNode:             node,
+					ContextSize:             len(currentNodes), // Size of current node set
+					ContextPosition:         i + 1,             // Position within current node set (1-based)
+					VariableBindings:        ctx.VariableBindings,
+					VariableResolver:        ctx.VariableResolver,
+					FunctionLibrary:         ctx.FunctionLibrary,
+					NamespaceResolver:       ctx.NamespaceResolver,
+					DefaultElementNamespace: ctx.DefaultElementNamespace,
+					Document:                ctx.Document,
+					Context:                 ctx.Context,
 				}
 
 				result, err := predicate.Evaluate(predCtx)
@@ -831,7 +885,7 @@ func (n xpathAxisNode) getNamespaceNodes(elem Element, ctx *XPathContext) []Node
 	namespaces := make(map[string]string) // prefix -> URI mapping
 
 	// Always include the xml namespace (implicit in all documents)
-	namespaces["xml"] = "http://www.w3.org/XML/1998/namespace"
+	namespaces["xml"] = xmlNamespaceURI
 
 	// Walk up the tree collecting namespace declarations
 	current := Node(elem)
@@ -1248,6 +1302,11 @@ func (e *XPathError) Error() string {
 	return e.Message
 }
 
+// XPathException is the DOM Level 3 XPath name for XPathError. Errors
+// raised while compiling or evaluating an expression, such as a reference
+// to a variable that cannot be resolved, are XPathExceptions.
+type XPathException = XPathError
+
 // NewXPathError creates a new XPathError
 func NewXPathError(errorType XPathErrorType, message string, position int) *XPathError {
 	return &XPathError{
@@ -1258,7 +1317,7 @@ func NewXPathError(errorType XPathErrorType, message string, position int) *XPat
 }
 
 // NewXPathException creates a new XPath exception (alias for NewXPathError)
-func NewXPathException(errorType string, message string) *XPathError {
+func NewXPathException(errorType string, message string) *XPathException {
 	// Map string error types to XPathErrorType constants
 	var errType XPathErrorType
 	switch errorType {
@@ -1395,6 +1454,8 @@ type xpathExpression struct {
 	ast              XPathNode
 	document         *document
 	variableBindings map[string]XPathValue
+	variableResolver XPathVariableResolver
+	defaultElementNS string
 	mu               sync.RWMutex // Protect variable bindings
 }
 
@@ -1409,6 +1470,21 @@ func (xe *xpathExpression) SetVariableBindings(bindings map[string]XPathValue) {
 	}
 }
 
+// SetVariableResolver sets the resolver used for variables without a binding
+func (xe *xpathExpression) SetVariableResolver(resolver XPathVariableResolver) {
+	xe.mu.Lock()
+	defer xe.mu.Unlock()
+	xe.variableResolver = resolver
+}
+
+// SetDefaultElementNamespace sets the namespace unprefixed element name
+// tests must match
+func (xe *xpathExpression) SetDefaultElementNamespace(namespaceURI string) {
+	xe.mu.Lock()
+	defer xe.mu.Unlock()
+	xe.defaultElementNS = namespaceURI
+}
+
 func (xe *xpathExpression) Evaluate(contextNode Node, resultType uint16, result XPathResult) (XPathResult, error) {
 	if contextNode == nil {
 		return nil, NewXPathException("TYPE_ERR", "Context node cannot be null")
@@ -1420,17 +1496,29 @@ func (xe *xpathExpression) Evaluate(contextNode Node, resultType uint16, result
 	for k, v := range xe.variableBindings {
 		varBindings[k] = v
 	}
+	varResolver := xe.variableResolver
+	defaultNS := xe.defaultElementNS
 	xe.mu.RUnlock()
+	if varResolver == nil {
+		varResolver, _ = xe.resolver.(XPathVariableResolver)
+	}
+	if defaultNS == "" {
+		if r, ok := xe.resolver.(XPathDefaultNamespaceResolver); ok {
+			defaultNS = r.DefaultElementNamespace()
+		}
+	}
 
 	// Create evaluation context
 	context := &XPathContext{
-		ContextNode:       contextNode,
-		ContextSize:       1,
-		ContextPosition:   1,
-		VariableBindings:  varBindings,
-		FunctionLibrary:   getBuiltinFunctions(),
-		NamespaceResolver: xe.resolver,
-		Document:          xe.document,
+		ContextNode:             contextNode,
+		ContextSize:             1,
+		ContextPosition:         1,
+		VariableBindings:        varBindings,
+		VariableResolver:        varResolver,
+		FunctionLibrary:         getBuiltinFunctions(),
+		NamespaceResolver:       xe.resolver,
+		DefaultElementNamespace: defaultNS,
+		Document:                xe.document,
 	}
 
 	// Evaluate AST
@@ -2103,7 +2191,7 @@ func getBuiltinFunctions() map[string]XPathFunction {
 				for node := context.ContextNode; node != nil; node = node.ParentNode() {
 					if elem, ok := node.(Element); ok {
 						// Check for xml:lang attribute
-						if langAttr := elem.GetAttributeNS("http://www.w3.org/XML/1998/namespace", "lang"); string(langAttr) != "" {
+						if langAttr := elem.GetAttributeNS(xmlNamespaceURI, "lang"); string(langAttr) != "" {
 							langValue := strings.ToLower(string(langAttr))
 
 							// XPath 1.0 lang() function rules:
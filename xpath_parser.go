@@ -1281,6 +1281,11 @@ func (nt *xpathNodeTest) matchesElementName(node Node, ctx *XPathContext) bool {
 
 	// Handle unqualified names
 	if !strings.Contains(testName, ":") {
+		// With a default element namespace, match it and the local name
+		if ctx.DefaultElementNamespace != "" {
+			localName := elementName[strings.Index(elementName, ":")+1:]
+			return string(node.NamespaceURI()) == ctx.DefaultElementNamespace && localName == testName
+		}
 		// Simple name match
 		if elementName == testName || testName == "*" {
 			return true
@@ -1443,5 +1448,14 @@ func (n xpathVariableNode) Evaluate(ctx *XPathContext) (XPathValue, error) {
 	if value, exists := ctx.VariableBindings[n.name]; exists {
 		return value, nil
 	}
+	if ctx.VariableResolver != nil {
+		value, err := ctx.VariableResolver.ResolveVariable(n.name)
+		if err != nil {
+			return nil, NewXPathError(XPathErrorTypeContext, "Cannot resolve variable $"+n.name+": "+err.Error(), 0)
+		}
+		if value != nil {
+			return value, nil
+		}
+	}
 	return nil, NewXPathError(XPathErrorTypeContext, "Undefined variable: $"+n.name, 0)
 }
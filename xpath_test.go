@@ -1,6 +1,7 @@
 package xmldom
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -424,3 +425,106 @@ func TestXPathBuiltinFunctions(t *testing.T) {
 // 	}
 // }
 }
+
+// mapVariableResolver resolves variables from a map and also acts as a
+// namespace resolver that knows no prefixes
+type mapVariableResolver map[string]XPathValue
+
+func (r mapVariableResolver) LookupNamespaceURI(prefix string) string { return "" }
+
+func (r mapVariableResolver) ResolveVariable(name string) (XPathValue, error) {
+	return r[name], nil
+}
+
+func TestXPathVariableResolver(t *testing.T) {
+	xmlData := `<machine><state id="idle"/><state id="running"/><state id="done"/></machine>`
+	doc, err := NewDecoder(strings.NewReader(xmlData)).Decode()
+	if err != nil {
+		t.Fatalf("Failed to parse XML: %v", err)
+	}
+	resolver := mapVariableResolver{"target": NewXPathStringValue("running")}
+
+	result, err := doc.Evaluate("//state[@id=$target]", doc, resolver, XPATH_ORDERED_NODE_SNAPSHOT_TYPE, nil)
+	if err != nil {
+		t.Fatalf("Evaluate() failed: %v", err)
+	}
+	if n, _ := result.SnapshotLength(); n != 1 {
+		t.Fatalf("Expected 1 matching state, got %d", n)
+	}
+	node, _ := result.SnapshotItem(0)
+	if id := node.(Element).GetAttribute("id"); id != "running" {
+		t.Errorf("Expected state 'running', got %q", id)
+	}
+
+	// A resolver set on a compiled expression works without a namespace resolver
+	expr, err := doc.CreateExpression("count(//state[@id!=$target])", nil)
+	if err != nil {
+		t.Fatalf("CreateExpression() failed: %v", err)
+	}
+	expr.SetVariableResolver(resolver)
+	result, err = expr.Evaluate(doc, XPATH_NUMBER_TYPE, nil)
+	if err != nil {
+		t.Fatalf("Evaluate() failed: %v", err)
+	}
+	if count, _ := result.NumberValue(); count != 2 {
+		t.Errorf("Expected 2 other states, got %v", count)
+	}
+
+	// Unresolved variables raise an XPath exception
+	_, err = doc.Evaluate("//state[@id=$missing]", doc, resolver, XPATH_ORDERED_NODE_SNAPSHOT_TYPE, nil)
+	var exception *XPathException
+	if !errors.As(err, &exception) {
+		t.Errorf("Expected an XPathException for unresolved variable, got %v", err)
+	}
+}
+
+type defaultNamespaceResolver string
+
+func (r defaultNamespaceResolver) LookupNamespaceURI(prefix string) string { return "" }
+
+func (r defaultNamespaceResolver) DefaultElementNamespace() string { return string(r) }
+
+func TestXPathDefaultElementNamespace(t *testing.T) {
+	xmlData := `<scxml xmlns="http://www.w3.org/2005/07/scxml"><state id="a"/><state id="b"/></scxml>`
+	doc, err := NewDecoder(strings.NewReader(xmlData)).Decode()
+	if err != nil {
+		t.Fatalf("Failed to parse XML: %v", err)
+	}
+	count := func(resolver XPathNSResolver) float64 {
+		t.Helper()
+		result, err := doc.Evaluate("count(//state)", doc, resolver, XPATH_NUMBER_TYPE, nil)
+		if err != nil {
+			t.Fatalf("Evaluate() failed: %v", err)
+		}
+		n, _ := result.NumberValue()
+		return n
+	}
+
+	if n := count(nil); n != 2 {
+		t.Errorf("Without a default namespace, expected 2 states by local name, got %v", n)
+	}
+	if n := count(defaultNamespaceResolver("http://www.w3.org/2005/07/scxml")); n != 2 {
+		t.Errorf("With the SCXML default namespace, expected 2 states, got %v", n)
+	}
+	if n := count(defaultNamespaceResolver("urn:other")); n != 0 {
+		t.Errorf("With another default namespace, expected no states, got %v", n)
+	}
+
+	expr, err := doc.CreateExpression("count(/scxml/state)", nil)
+	if err != nil {
+		t.Fatalf("CreateExpression() failed: %v", err)
+	}
+	expr.SetDefaultElementNamespace("urn:other")
+	result, err := expr.Evaluate(doc, XPATH_NUMBER_TYPE, nil)
+	if err != nil {
+		t.Fatalf("Evaluate() failed: %v", err)
+	}
+	if n, _ := result.NumberValue(); n != 0 {
+		t.Errorf("Expected no match in urn:other, got %v", n)
+	}
+	expr.SetDefaultElementNamespace("http://www.w3.org/2005/07/scxml")
+	result, _ = expr.Evaluate(doc, XPATH_NUMBER_TYPE, nil)
+	if n, _ := result.NumberValue(); n != 2 {
+		t.Errorf("Expected 2 states in the SCXML namespace, got %v", n)
+	}
+}